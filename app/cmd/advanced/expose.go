@@ -2,12 +2,16 @@ package advanced
 
 import (
 	"context"
+	"fmt"
+	"strings"
 
 	"github.com/sirupsen/logrus"
 	"github.com/urfave/cli"
 
 	"github.com/longhorn/go-spdk-helper/pkg/spdk/client"
 	"github.com/longhorn/go-spdk-helper/pkg/util"
+
+	spdktypes "github.com/longhorn/go-spdk-helper/pkg/spdk/types"
 )
 
 func ExposeCmd() cli.Command {
@@ -15,6 +19,8 @@ func ExposeCmd() cli.Command {
 		Name: "expose",
 		Subcommands: []cli.Command{
 			StartExposeCmd(),
+			StartExposeWithListenersCmd(),
+			ReconcileExposeListenersCmd(),
 			StopExposeCmd(),
 		},
 	}
@@ -72,6 +78,139 @@ func startExpose(c *cli.Context) error {
 	return util.PrintObject(true)
 }
 
+func StartExposeWithListenersCmd() cli.Command {
+	return cli.Command{
+		Name: "start-listeners",
+		Usage: "Expose a bdev via nvmf on multiple listeners: start-listeners --nqn <NVMF SUBSYSTEM NQN> --bdev-name <BDEV ALIAS or BDEV UUID> " +
+			"--listener <TRTYPE>,<IP>,<PORT>[,<ANA STATE>] --listener <TRTYPE>,<IP>,<PORT>[,<ANA STATE>] ...",
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:     "nqn",
+				Usage:    "NVMe-oF target subsystem NQN",
+				Required: true,
+			},
+			cli.StringFlag{
+				Name:     "bdev-name",
+				Usage:    "Name of the exported bdev lvol",
+				Required: true,
+			},
+			cli.StringFlag{
+				Name:     "nguid",
+				Usage:    "Namespace globally unique identifier",
+				Required: false,
+			},
+			cli.StringFlag{
+				Name:     "ns-uuid",
+				Usage:    "Namespace UUID",
+				Required: false,
+			},
+			cli.StringSliceFlag{
+				Name:     "listener",
+				Usage:    "Listen address in the format <TRTYPE>,<IP>,<PORT>[,<ANA STATE>], e.g. tcp,10.0.0.1,4420,optimized or rdma,fd00::1,4420",
+				Required: true,
+			},
+			cli.UintFlag{
+				Name:  "min-cntlid",
+				Usage: "Minimum controller ID of the subsystem. 0 means the SPDK default",
+			},
+			cli.UintFlag{
+				Name:  "max-cntlid",
+				Usage: "Maximum controller ID of the subsystem. 0 means the SPDK default",
+			},
+		},
+		Action: func(c *cli.Context) {
+			if err := startExposeWithListeners(c); err != nil {
+				logrus.WithError(err).Fatalf("Failed to run start expose with listeners command")
+			}
+		},
+	}
+}
+
+func startExposeWithListeners(c *cli.Context) error {
+	listeners, err := parseListeners(c.StringSlice("listener"))
+	if err != nil {
+		return err
+	}
+
+	spdkCli, err := client.NewClient(context.Background())
+	if err != nil {
+		return err
+	}
+
+	if err := spdkCli.StartExposeBdevWithListeners(c.String("nqn"), c.String("bdev-name"), c.String("nguid"), c.String("ns-uuid"),
+		listeners, uint16(c.Uint("min-cntlid")), uint16(c.Uint("max-cntlid"))); err != nil {
+		return err
+	}
+
+	return util.PrintObject(true)
+}
+
+func ReconcileExposeListenersCmd() cli.Command {
+	return cli.Command{
+		Name:  "reconcile-listeners",
+		Usage: "Add and remove listeners of an exposed bdev to match the given set: reconcile-listeners --nqn <NVMF SUBSYSTEM NQN> --listener <TRTYPE>,<IP>,<PORT>[,<ANA STATE>] ...",
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:     "nqn",
+				Usage:    "NVMe-oF target subsystem NQN",
+				Required: true,
+			},
+			cli.StringSliceFlag{
+				Name:  "listener",
+				Usage: "Desired listen address in the format <TRTYPE>,<IP>,<PORT>[,<ANA STATE>]. Listeners not specified are removed",
+			},
+		},
+		Action: func(c *cli.Context) {
+			if err := reconcileExposeListeners(c); err != nil {
+				logrus.WithError(err).Fatalf("Failed to run reconcile expose listeners command")
+			}
+		},
+	}
+}
+
+func reconcileExposeListeners(c *cli.Context) error {
+	listeners, err := parseListeners(c.StringSlice("listener"))
+	if err != nil {
+		return err
+	}
+
+	spdkCli, err := client.NewClient(context.Background())
+	if err != nil {
+		return err
+	}
+
+	if err := spdkCli.ReconcileExposeListeners(c.String("nqn"), listeners); err != nil {
+		return err
+	}
+
+	return util.PrintObject(true)
+}
+
+// parseListeners parses listeners in the format <TRTYPE>,<IP>,<PORT>[,<ANA STATE>].
+// Commas are used as separators so that IPv6 addresses need no brackets.
+func parseListeners(args []string) ([]spdktypes.NvmfSubsystemListener, error) {
+	listeners := []spdktypes.NvmfSubsystemListener{}
+	for _, arg := range args {
+		parts := strings.Split(arg, ",")
+		if len(parts) != 3 && len(parts) != 4 {
+			return nil, fmt.Errorf("listener %q not in <TRTYPE>,<IP>,<PORT>[,<ANA STATE>] format", arg)
+		}
+
+		listener := spdktypes.NvmfSubsystemListener{
+			Address: spdktypes.NvmfSubsystemListenAddress{
+				Trtype:  spdktypes.NvmeTransportType(strings.ToLower(parts[0])),
+				Traddr:  parts[1],
+				Trsvcid: parts[2],
+			},
+		}
+		if len(parts) == 4 {
+			listener.AnaState = spdktypes.NvmfSubsystemListenerAnaState(parts[3])
+		}
+		listeners = append(listeners, listener)
+	}
+	return listeners, nil
+}
+
 func StopExposeCmd() cli.Command {
 	return cli.Command{
 		Name:  "stop",
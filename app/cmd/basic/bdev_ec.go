@@ -2,13 +2,22 @@ package basic
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
 
 	"github.com/sirupsen/logrus"
 	"github.com/urfave/cli"
 
 	"github.com/longhorn/go-spdk-helper/pkg/spdk/client"
+	"github.com/longhorn/go-spdk-helper/pkg/types"
 	"github.com/longhorn/go-spdk-helper/pkg/util"
+
+	spdktypes "github.com/longhorn/go-spdk-helper/pkg/spdk/types"
 )
 
 func BdevEcCmd() cli.Command {
@@ -28,6 +37,14 @@ func BdevEcCmd() cli.Command {
 			BdevEcWibStatusCmd(),
 			BdevEcUnmapStatusCmd(),
 			BdevEcScrubProgressCmd(),
+			BdevEcScrubStartCmd(),
+			BdevEcScrubStopCmd(),
+			BdevEcScrubQosSetCmd(),
+			BdevEcProvisionCmd(),
+			BdevEcMetricsCmd(),
+			BdevEcWatchCmd(),
+			BdevEcSalvageCmd(),
+			BdevEcExpandCmd(),
 		},
 	}
 }
@@ -81,14 +98,18 @@ func bdevEcCreate(c *cli.Context) error {
 		return err
 	}
 
-	bdevName, err := spdkCli.BdevEcCreate(
-		c.String("name"),
-		uint32(c.Uint("data-chunks")),
-		uint32(c.Uint("parity-chunks")),
-		uint32(c.Uint("strip-size-kb")),
-		c.StringSlice("base-bdevs"),
-		c.Bool("salvage"),
-	)
+	req := spdktypes.BdevEcCreateRequest{
+		Name:         c.String("name"),
+		DataChunks:   uint32(c.Uint("data-chunks")),
+		ParityChunks: uint32(c.Uint("parity-chunks")),
+		StripSizeKB:  uint32(c.Uint("strip-size-kb")),
+		BaseBdevs:    c.StringSlice("base-bdevs"),
+	}
+	if err := spdkCli.ValidateECCreate(&req, 0); err != nil {
+		return err
+	}
+
+	bdevName, err := spdkCli.BdevEcCreate(req.Name, req.DataChunks, req.ParityChunks, req.StripSizeKB, req.BaseBdevs, c.Bool("salvage"))
 	if err != nil {
 		return err
 	}
@@ -427,7 +448,7 @@ func bdevEcUnmapStatus(c *cli.Context) error {
 func BdevEcScrubProgressCmd() cli.Command {
 	return cli.Command{
 		Name:  "scrub-progress",
-		Usage: "query startup scrub progress: scrub-progress <NAME>",
+		Usage: "query scrub progress: scrub-progress <NAME>",
 		Action: func(c *cli.Context) {
 			if err := bdevEcScrubProgress(c); err != nil {
 				logrus.WithError(err).Fatalf("Failed to run scrub-progress bdev ec command")
@@ -454,3 +475,545 @@ func bdevEcScrubProgress(c *cli.Context) error {
 
 	return util.PrintObject(progress)
 }
+
+func BdevEcScrubStartCmd() cli.Command {
+	return cli.Command{
+		Name:  "scrub-start",
+		Usage: "start background scrub: scrub-start [--dirty-only] <NAME>",
+		Flags: []cli.Flag{
+			cli.BoolFlag{
+				Name:  "dirty-only",
+				Usage: "Scrub only the regions marked dirty in the WIB",
+			},
+		},
+		Action: func(c *cli.Context) {
+			if err := bdevEcScrubStart(c); err != nil {
+				logrus.WithError(err).Fatalf("Failed to run scrub-start bdev ec command")
+			}
+		},
+	}
+}
+
+func bdevEcScrubStart(c *cli.Context) error {
+	name := c.Args().First()
+	if name == "" {
+		return fmt.Errorf("EC bdev name is required")
+	}
+
+	spdkCli, err := client.NewClient(context.Background())
+	if err != nil {
+		return err
+	}
+
+	resp, err := spdkCli.BdevEcStartScrub(name, c.Bool("dirty-only"))
+	if err != nil {
+		return err
+	}
+
+	return util.PrintObject(resp)
+}
+
+func BdevEcScrubStopCmd() cli.Command {
+	return cli.Command{
+		Name:  "scrub-stop",
+		Usage: "stop a running scrub: scrub-stop <NAME>",
+		Action: func(c *cli.Context) {
+			if err := bdevEcScrubStop(c); err != nil {
+				logrus.WithError(err).Fatalf("Failed to run scrub-stop bdev ec command")
+			}
+		},
+	}
+}
+
+func bdevEcScrubStop(c *cli.Context) error {
+	name := c.Args().First()
+	if name == "" {
+		return fmt.Errorf("EC bdev name is required")
+	}
+
+	spdkCli, err := client.NewClient(context.Background())
+	if err != nil {
+		return err
+	}
+
+	stopped, err := spdkCli.BdevEcStopScrub(name)
+	if err != nil {
+		return err
+	}
+
+	return util.PrintObject(stopped)
+}
+
+func BdevEcScrubQosSetCmd() cli.Command {
+	return cli.Command{
+		Name:  "scrub-qos-set",
+		Usage: "set scrub rate limit: scrub-qos-set --name <NAME> --max-stripes-per-sec <N> [--paused]",
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:     "name,n",
+				Usage:    "Name of the EC bdev",
+				Required: true,
+			},
+			cli.UintFlag{
+				Name:  "max-stripes-per-sec",
+				Usage: "Scrub rate limit in stripes/sec; 0 means unlimited",
+				Value: 0,
+			},
+			cli.BoolFlag{
+				Name:  "paused",
+				Usage: "Suspend the scrub poller without cancelling it",
+			},
+		},
+		Action: func(c *cli.Context) {
+			if err := bdevEcScrubQosSet(c); err != nil {
+				logrus.WithError(err).Fatalf("Failed to run scrub-qos-set bdev ec command")
+			}
+		},
+	}
+}
+
+func bdevEcScrubQosSet(c *cli.Context) error {
+	spdkCli, err := client.NewClient(context.Background())
+	if err != nil {
+		return err
+	}
+
+	set, err := spdkCli.BdevEcSetScrubQos(c.String("name"), uint32(c.Uint("max-stripes-per-sec")), c.Bool("paused"))
+	if err != nil {
+		return err
+	}
+
+	return util.PrintObject(set)
+}
+
+func BdevEcProvisionCmd() cli.Command {
+	return cli.Command{
+		Name:  "provision",
+		Usage: "provision an EC volume on local lvstores, each one hosting at most one shard. Rerun it with the same arguments to resume an interrupted provisioning: provision --name <NAME> --lvs-name <LVSTORE NAME> --volume-size-in-mib <SIZE> --data-chunks <DATA CHUNKS> --parity-chunks <PARITY CHUNKS> --strip-size-kb <KB> --backend-lvs <LVSTORE1> --backend-lvs <LVSTORE2> ...",
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:     "name,n",
+				Usage:    "Name for the new EC bdev",
+				Required: true,
+			},
+			cli.StringFlag{
+				Name:     "lvs-name",
+				Usage:    "Name for the lvstore created on the EC bdev",
+				Required: true,
+			},
+			cli.Uint64Flag{
+				Name:     "volume-size-in-mib",
+				Usage:    "Size of the volume the lvstore must hold, in MiB",
+				Required: true,
+			},
+			cli.UintFlag{
+				Name:     "data-chunks",
+				Usage:    "Number of data chunks per stripe",
+				Required: true,
+			},
+			cli.UintFlag{
+				Name:     "parity-chunks",
+				Usage:    "Number of parity chunks per stripe",
+				Required: true,
+			},
+			cli.UintFlag{
+				Name:     "strip-size-kb,s",
+				Usage:    "Chunk size in KiB (e.g. 64)",
+				Required: true,
+			},
+			cli.StringSliceFlag{
+				Name:     "backend-lvs",
+				Usage:    "Lvstores to create the shards on, each one its own failure domain, e.g. --backend-lvs lvs0 --backend-lvs lvs1",
+				Required: true,
+			},
+		},
+		Action: func(c *cli.Context) {
+			if err := bdevEcProvision(c); err != nil {
+				logrus.WithError(err).Fatalf("Failed to run provision bdev ec command")
+			}
+		},
+	}
+}
+
+func bdevEcProvision(c *cli.Context) error {
+	spdkCli, err := client.NewClient(context.Background())
+	if err != nil {
+		return err
+	}
+
+	name := c.String("name")
+	volumeSize := int64(c.Uint64("volume-size-in-mib") * types.MiB)
+	dataChunks := uint32(c.Uint("data-chunks"))
+	stripSizeKB := uint32(c.Uint("strip-size-kb"))
+	shardSize := uint64(spdktypes.ComputeShardSize(volumeSize, int(dataChunks), int(stripSizeKB)))
+
+	// The shards left by an interrupted provisioning are counted as free
+	// space, so that the placement is planned again as it was.
+	shardLvstores := map[string]string{}
+	backends := []spdktypes.EcPlacementBackend{}
+	for _, lvsName := range c.StringSlice("backend-lvs") {
+		lvstores, err := spdkCli.BdevLvolGetLvstore(lvsName, "")
+		if err != nil {
+			return err
+		}
+		if len(lvstores) != 1 {
+			return fmt.Errorf("cannot find lvstore %v", lvsName)
+		}
+		backend := spdktypes.NewEcPlacementBackend("", lvsName, &lvstores[0])
+
+		lvols, err := spdkCli.BdevLvolGetLvols(lvsName, "")
+		if err != nil {
+			return err
+		}
+		for _, lvol := range lvols {
+			if !strings.HasPrefix(lvol.Name, name+"-shard-") {
+				continue
+			}
+			shardLvstores[lvol.Name] = lvsName
+			if backend.ClusterSize > 0 {
+				backend.FreeBytes += (shardSize + backend.ClusterSize - 1) / backend.ClusterSize * backend.ClusterSize
+			} else {
+				backend.FreeBytes += shardSize
+			}
+		}
+		backends = append(backends, backend)
+	}
+
+	placement, err := spdktypes.PlanECPlacement(spdktypes.EcPlacementRequest{
+		Name:         name,
+		VolumeSize:   volumeSize,
+		DataChunks:   dataChunks,
+		ParityChunks: uint32(c.Uint("parity-chunks")),
+		StripSizeKB:  stripSizeKB,
+	}, backends)
+	if err != nil {
+		return err
+	}
+	for _, shard := range placement.Shards {
+		if lvsName, ok := shardLvstores[shard.LvolName]; ok && lvsName != shard.LvsName {
+			return fmt.Errorf("shard lvol %v is in lvstore %v, but the placement puts it in lvstore %v", shard.LvolName, lvsName, shard.LvsName)
+		}
+	}
+
+	volume, err := spdkCli.ProvisionECVolume(client.ECVolumeSpec{
+		Placement:  placement,
+		VolumeSize: volumeSize,
+		LvsName:    c.String("lvs-name"),
+	})
+	if err != nil {
+		return err
+	}
+
+	return util.PrintObject(volume)
+}
+
+func BdevEcMetricsCmd() cli.Command {
+	return cli.Command{
+		Name:  "metrics",
+		Usage: "export the EC bdev counters and their rates in the Prometheus text format: metrics [--listen <ADDRESS>] [--interval-sec <SECONDS>]",
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "listen",
+				Usage: "Serve the metrics over HTTP on this address, e.g. :9500. By default, the metrics are printed once after two samples",
+			},
+			cli.UintFlag{
+				Name:  "interval-sec",
+				Usage: "Interval between two samples",
+				Value: uint(client.DefaultEcMetricsInterval / time.Second),
+			},
+		},
+		Action: func(c *cli.Context) {
+			if err := bdevEcMetrics(c); err != nil {
+				logrus.WithError(err).Fatalf("Failed to run metrics bdev ec command")
+			}
+		},
+	}
+}
+
+func bdevEcMetrics(c *cli.Context) error {
+	spdkCli, err := client.NewClient(context.Background())
+	if err != nil {
+		return err
+	}
+
+	interval := time.Duration(c.Uint("interval-sec")) * time.Second
+	collector := client.NewEcMetricsCollector(spdkCli, client.EcMetricsCollectorOptions{Interval: interval})
+
+	if listen := c.String("listen"); listen != "" {
+		go collector.Run(context.Background())
+		return http.ListenAndServe(listen, collector)
+	}
+
+	if err := collector.Collect(); err != nil {
+		return err
+	}
+	time.Sleep(interval)
+	if err := collector.Collect(); err != nil {
+		return err
+	}
+	return collector.WriteMetrics(os.Stdout)
+}
+
+func BdevEcWatchCmd() cli.Command {
+	return cli.Command{
+		Name:  "watch",
+		Usage: "watch the state, rebuild and scrub progress of EC bdevs: watch [--name <EC NAME>] [--interval-sec <SECONDS>] [--count <COUNT>] [--json]",
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "name,n",
+				Usage: "Name of the EC bdev to watch. By default, all EC bdevs are watched",
+			},
+			cli.UintFlag{
+				Name:  "interval-sec",
+				Usage: "Interval between two refreshes",
+				Value: 2,
+			},
+			cli.UintFlag{
+				Name:  "count",
+				Usage: "Number of refreshes before exiting, 0 meaning forever",
+			},
+			cli.BoolFlag{
+				Name:  "json",
+				Usage: "Print one JSON object per EC bdev and refresh, instead of a table",
+			},
+		},
+		Action: func(c *cli.Context) {
+			if err := bdevEcWatch(c); err != nil {
+				logrus.WithError(err).Fatalf("Failed to run watch bdev ec command")
+			}
+		},
+	}
+}
+
+func bdevEcWatch(c *cli.Context) error {
+	spdkCli, err := client.NewClient(context.Background())
+	if err != nil {
+		return err
+	}
+
+	watcher := client.NewEcWatcher(spdkCli)
+	encoder := json.NewEncoder(os.Stdout)
+	interval := time.Duration(c.Uint("interval-sec")) * time.Second
+	for i := uint(0); c.Uint("count") == 0 || i < c.Uint("count"); i++ {
+		if i > 0 {
+			time.Sleep(interval)
+		}
+
+		rows, err := watcher.Poll(c.String("name"))
+		if err != nil {
+			return err
+		}
+		if c.Bool("json") {
+			for _, row := range rows {
+				if err := encoder.Encode(row); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		if err := printEcWatchTable(rows, interval); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// printEcWatchTable clears the terminal and prints the rows as a table.
+func printEcWatchTable(rows []client.EcWatchRow, interval time.Duration) error {
+	fmt.Print("\033[H\033[2J")
+	fmt.Printf("Every %v: %v\n\n", interval, time.Now().Format(time.RFC3339))
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tSTATE\tSLOTS\tREBUILD\tSCRUB\tDIRTY STRIPES\tWIB DIRTY\tERROR")
+	for _, row := range rows {
+		slots := []string{}
+		for _, slot := range row.Slots {
+			slots = append(slots, fmt.Sprintf("%d:%s=%s", slot.Slot, slot.Role, slot.State))
+		}
+		wib := "-"
+		if row.WibNumRegions > 0 {
+			wib = fmt.Sprintf("%d/%d", row.WibDirtyRegions, row.WibNumRegions)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%d\t%s\t%s\n", row.Name, row.State, strings.Join(slots, " "),
+			ecWatchProgress(row.RebuildPercent, row.RebuildEtaSec), ecWatchProgress(row.ScrubPercent, row.ScrubEtaSec),
+			row.DirtyStripes, wib, row.Error)
+	}
+	return w.Flush()
+}
+
+func ecWatchProgress(percent *uint32, etaSec *int64) string {
+	if percent == nil {
+		return "-"
+	}
+	if etaSec == nil {
+		return fmt.Sprintf("%d%%", *percent)
+	}
+	return fmt.Sprintf("%d%% ETA %v", *percent, time.Duration(*etaSec)*time.Second)
+}
+
+func BdevEcSalvageCmd() cli.Command {
+	return cli.Command{
+		Name:  "salvage",
+		Usage: "recreate an EC bdev whose unmapped bitmap failed to load with salvage, and report the bitmap SPDK loaded: salvage --name <NAME> --data-chunks <DATA CHUNKS> --parity-chunks <PARITY CHUNKS> --strip-size-kb <KB> --base-bdevs <BDEV1> --base-bdevs <BDEV2> ...",
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:     "name,n",
+				Usage:    "Name of the EC bdev to salvage",
+				Required: true,
+			},
+			cli.UintFlag{
+				Name:     "data-chunks",
+				Usage:    "Number of data chunks per stripe",
+				Required: true,
+			},
+			cli.UintFlag{
+				Name:     "parity-chunks",
+				Usage:    "Number of parity chunks per stripe",
+				Required: true,
+			},
+			cli.UintFlag{
+				Name:     "strip-size-kb,s",
+				Usage:    "Chunk size in KiB (e.g. 64)",
+				Required: true,
+			},
+			cli.StringSliceFlag{
+				Name:     "base-bdevs,b",
+				Usage:    "Ordered list of (data + parity) base bdev names, e.g. --base-bdevs bdev0 --base-bdevs bdev1",
+				Required: true,
+			},
+		},
+		Action: func(c *cli.Context) {
+			if err := bdevEcSalvage(c); err != nil {
+				logrus.WithError(err).Fatalf("Failed to run salvage bdev ec command")
+			}
+		},
+	}
+}
+
+func bdevEcSalvage(c *cli.Context) error {
+	spdkCli, err := client.NewClient(context.Background())
+	if err != nil {
+		return err
+	}
+
+	status, err := spdkCli.SalvageECBdev(spdktypes.BdevEcCreateRequest{
+		Name:         c.String("name"),
+		DataChunks:   uint32(c.Uint("data-chunks")),
+		ParityChunks: uint32(c.Uint("parity-chunks")),
+		StripSizeKB:  uint32(c.Uint("strip-size-kb")),
+		BaseBdevs:    c.StringSlice("base-bdevs"),
+	})
+	if status != nil {
+		if err := util.PrintObject(status); err != nil {
+			return err
+		}
+	}
+	return err
+}
+
+func BdevEcExpandCmd() cli.Command {
+	return cli.Command{
+		Name:  "expand",
+		Usage: "expand an EC volume provisioned on local lvstores, shards, EC bdev and lvstore: expand --name <EC NAME> --lvs-name <LVSTORE NAME> --volume-size-in-mib <CURRENT SIZE> --new-volume-size-in-mib <NEW SIZE> --creation-volume-size-in-mib <CREATION SIZE>",
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:     "name,n",
+				Usage:    "Name of the EC bdev",
+				Required: true,
+			},
+			cli.StringFlag{
+				Name:     "lvs-name",
+				Usage:    "Name of the lvstore on the EC bdev",
+				Required: true,
+			},
+			cli.Uint64Flag{
+				Name:     "volume-size-in-mib",
+				Usage:    "Current size of the volume the EC bdev was provisioned for",
+				Required: true,
+			},
+			cli.Uint64Flag{
+				Name:     "new-volume-size-in-mib",
+				Usage:    "New size of the volume",
+				Required: true,
+			},
+			cli.Uint64Flag{
+				Name:     "creation-volume-size-in-mib",
+				Usage:    "Size of the volume the EC bdev was provisioned for, which bounds the growth of its lvstore. It differs from the current size once the volume has been expanded",
+				Required: true,
+			},
+		},
+		Action: func(c *cli.Context) {
+			if err := bdevEcExpand(c); err != nil {
+				logrus.WithError(err).Fatalf("Failed to run expand bdev ec command")
+			}
+		},
+	}
+}
+
+func bdevEcExpand(c *cli.Context) error {
+	spdkCli, err := client.NewClient(context.Background())
+	if err != nil {
+		return err
+	}
+
+	infos, err := spdkCli.BdevEcGetBdevs(c.String("name"))
+	if err != nil {
+		return err
+	}
+	if len(infos) != 1 {
+		return fmt.Errorf("cannot find EC bdev %v", c.String("name"))
+	}
+	info := infos[0]
+
+	// Rebuild the placement of the local shards from the base bdevs.
+	volumeSize := int64(c.Uint64("volume-size-in-mib") * types.MiB)
+	placement := &spdktypes.EcPlacement{
+		ShardSize: uint64(spdktypes.ComputeShardSize(volumeSize, int(info.DataChunks), int(info.StripSizeKB))),
+		Create: spdktypes.BdevEcCreateRequest{
+			Name:         info.Name,
+			DataChunks:   info.DataChunks,
+			ParityChunks: info.ParityChunks,
+			StripSizeKB:  info.StripSizeKB,
+		},
+	}
+	for _, base := range info.BaseBdevs {
+		// The base bdev may be named by lvol UUID, so look up its alias.
+		bdevs, err := spdkCli.BdevGetBdevs(base.Name, 0)
+		if err != nil {
+			return err
+		}
+		if len(bdevs) != 1 || spdktypes.GetBdevType(&bdevs[0]) != spdktypes.BdevTypeLvol {
+			return fmt.Errorf("base bdev %v of EC bdev %v is not a local lvol", base.Name, info.Name)
+		}
+		alias := ""
+		for _, a := range bdevs[0].Aliases {
+			if spdktypes.GetLvsNameFromAlias(a) != "" {
+				alias = a
+				break
+			}
+		}
+		if alias == "" {
+			return fmt.Errorf("cannot find the alias of lvol %v, base bdev of EC bdev %v", base.Name, info.Name)
+		}
+		placement.Shards = append(placement.Shards, spdktypes.EcShardPlacement{
+			Slot:     base.Slot,
+			Role:     base.Role,
+			LvsName:  spdktypes.GetLvsNameFromAlias(alias),
+			LvolName: spdktypes.GetLvolNameFromAlias(alias),
+			BaseBdev: base.Name,
+		})
+		placement.Create.BaseBdevs = append(placement.Create.BaseBdevs, base.Name)
+	}
+
+	expansion, err := spdkCli.ExpandECVolume(client.ECVolumeSpec{
+		Placement:          placement,
+		VolumeSize:         volumeSize,
+		CreationVolumeSize: int64(c.Uint64("creation-volume-size-in-mib") * types.MiB),
+		LvsName:            c.String("lvs-name"),
+	}, int64(c.Uint64("new-volume-size-in-mib")*types.MiB))
+	if err != nil {
+		return err
+	}
+
+	return util.PrintObject(expansion)
+}
@@ -4,12 +4,17 @@ import (
 	"context"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/cockroachdb/errors"
 	"github.com/sirupsen/logrus"
 	"github.com/urfave/cli"
 
+	commontypes "github.com/longhorn/go-common-libs/types"
+
+	"github.com/longhorn/go-spdk-helper/pkg/initiator"
 	"github.com/longhorn/go-spdk-helper/pkg/spdk/client"
+	"github.com/longhorn/go-spdk-helper/pkg/types"
 	"github.com/longhorn/go-spdk-helper/pkg/util"
 
 	spdktypes "github.com/longhorn/go-spdk-helper/pkg/spdk/types"
@@ -23,12 +28,15 @@ func BdevLvolCmd() cli.Command {
 			BdevLvolCreateCmd(),
 			BdevLvolDeleteCmd(),
 			BdevLvolGetCmd(),
+			BdevLvolTreeCmd(),
 			BdevLvolSnapshotCmd(),
 			BdevLvolCloneCmd(),
 			BdevLvolCloneBdevCmd(),
 			BdevLvolSetParentCmd(),
 			BdevLvolDecoupleParentCmd(),
 			BdevLvolDetachParentCmd(),
+			BdevLvolSetReadOnlyCmd(),
+			BdevLvolInflateCmd(),
 			BdevLvolResizeCmd(),
 			BdevLvolStartShallowCopyCmd(),
 			BdevLvolStartRangeShallowCopyCmd(),
@@ -37,7 +45,11 @@ func BdevLvolCmd() cli.Command {
 			BdevLvolCheckDeepCopyCmd(),
 			BdevLvolSetXattrCmd(),
 			BdevLvolGetXattrCmd(),
+			BdevLvolGetXattrsCmd(),
 			BdevLvolGetFragmapCmd(),
+			BdevLvolDiffCmd(),
+			BdevLvolExportCmd(),
+			BdevLvolImportCmd(),
 			BdevLvolRenameCmd(),
 			BdevLvolRegisterSnapshotChecksumCmd(),
 			BdevLvolRegisterRangeChecksumsCmd(),
@@ -172,6 +184,88 @@ func bdevLvolGet(c *cli.Context) error {
 	return util.PrintObject(bdevLvolGetResp)
 }
 
+func BdevLvolTreeCmd() cli.Command {
+	return cli.Command{
+		Name: "tree",
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "lvs-name",
+				Usage: "Only show the lvols in the lvstore",
+			},
+			cli.BoolFlag{
+				Name:  "json",
+				Usage: "Print the tree as JSON",
+			},
+		},
+		Usage: "show the snapshot chains of all bdev lvols if the info is not specified: \"tree\", or \"tree <LVSTORE NAME>/<LVOL NAME>\", or \"tree <UUID>\"",
+		Action: func(c *cli.Context) {
+			if err := bdevLvolTree(c); err != nil {
+				logrus.WithError(err).Fatalf("Failed to run tree bdev lvol command")
+			}
+		},
+	}
+}
+
+func bdevLvolTree(c *cli.Context) error {
+	spdkCli, err := client.NewClient(context.Background())
+	if err != nil {
+		return err
+	}
+
+	tree, err := spdkCli.BdevLvolGetTree()
+	if err != nil {
+		return err
+	}
+
+	roots := tree.Roots
+	if name := c.Args().First(); name != "" {
+		node := tree.Get(name)
+		if node == nil {
+			return fmt.Errorf("cannot find bdev lvol %v", name)
+		}
+		roots = []*client.LvolTreeNode{node.Root()}
+	}
+	if lvsName := c.String("lvs-name"); lvsName != "" {
+		filtered := []*client.LvolTreeNode{}
+		for _, root := range roots {
+			if root.IsInLvstore(lvsName) {
+				filtered = append(filtered, root)
+			}
+		}
+		roots = filtered
+	}
+
+	if c.Bool("json") {
+		return util.PrintObject(roots)
+	}
+
+	for _, root := range roots {
+		printLvolTreeNode(root, "", "")
+	}
+	return nil
+}
+
+func printLvolTreeNode(node *client.LvolTreeNode, prefix, childPrefix string) {
+	kind := "lvol"
+	if node.Snapshot {
+		kind = "snapshot"
+	}
+	orphan := ""
+	if node.Parent == nil && node.BaseSnapshot != "" {
+		orphan = fmt.Sprintf(", missing base snapshot %v", node.BaseSnapshot)
+	}
+	fmt.Printf("%s%s (%s, %s, %d bytes, %d allocated clusters%s)\n",
+		prefix, node.Alias, node.UUID, kind, node.SizeBytes, node.NumAllocatedClusters, orphan)
+
+	for i, child := range node.Children {
+		if i == len(node.Children)-1 {
+			printLvolTreeNode(child, childPrefix+"└── ", childPrefix+"    ")
+		} else {
+			printLvolTreeNode(child, childPrefix+"├── ", childPrefix+"│   ")
+		}
+	}
+}
+
 func BdevLvolSnapshotCmd() cli.Command {
 	return cli.Command{
 		Name: "snapshot",
@@ -214,12 +308,25 @@ func bdevLvolSnapshot(c *cli.Context) error {
 		name = c.String("uuid")
 	}
 
+	xattrs, err := parseXattrs(c.StringSlice("xattr"))
+	if err != nil {
+		return err
+	}
+
+	uuid, err := spdkCli.BdevLvolSnapshot(name, c.String("snapshot-name"), xattrs)
+	if err != nil {
+		return err
+	}
+
+	return util.PrintObject(uuid)
+}
+
+func parseXattrs(args []string) ([]client.Xattr, error) {
 	var xattrs []client.Xattr
-	xattrs_args := c.StringSlice("xattr")
-	for _, s := range xattrs_args {
+	for _, s := range args {
 		parts := strings.Split(s, "=")
 		if len(parts) != 2 {
-			return errors.Errorf("xattr %q not in name=value format", s)
+			return nil, errors.Errorf("xattr %q not in name=value format", s)
 		}
 
 		xattr := client.Xattr{
@@ -228,13 +335,7 @@ func bdevLvolSnapshot(c *cli.Context) error {
 		}
 		xattrs = append(xattrs, xattr)
 	}
-
-	uuid, err := spdkCli.BdevLvolSnapshot(name, c.String("snapshot-name"), xattrs)
-	if err != nil {
-		return err
-	}
-
-	return util.PrintObject(uuid)
+	return xattrs, nil
 }
 
 func BdevLvolCloneCmd() cli.Command {
@@ -291,6 +392,10 @@ func BdevLvolCloneBdevCmd() cli.Command {
 				Usage:    "Name for the logical volume to create",
 				Required: true,
 			},
+			cli.BoolFlag{
+				Name:  "by-uuid",
+				Usage: "Reference the external snapshot by the UUID of the bdev instead of its name, e.g. for a bdev of a remote NVMe controller that may be reattached with another name",
+			},
 		},
 		Usage: "create a lvol based on an external snapshot bdev: \"clone-bdev --bdev <BDEV NAME or UUID> --lvs-name <LVSTORE NAME> --clone-name <CLONE NAME>\"",
 		Action: func(c *cli.Context) {
@@ -307,7 +412,12 @@ func bdevLvolCloneBdev(c *cli.Context) error {
 		return err
 	}
 
-	uuid, err := spdkCli.BdevLvolCloneBdev(c.String("bdev"), c.String("lvs-name"), c.String("clone-name"))
+	cloneBdev := spdkCli.BdevLvolCloneBdev
+	if c.Bool("by-uuid") {
+		cloneBdev = spdkCli.BdevLvolCloneEsnap
+	}
+
+	uuid, err := cloneBdev(c.String("bdev"), c.String("lvs-name"), c.String("clone-name"))
 	if err != nil {
 		return err
 	}
@@ -397,6 +507,88 @@ func bdevLvolDetachParent(c *cli.Context) error {
 	return util.PrintObject(decoupled)
 }
 
+func BdevLvolSetReadOnlyCmd() cli.Command {
+	return cli.Command{
+		Name: "set-read-only",
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "alias",
+				Usage: "The alias of a lvol is <LVSTORE NAME>/<LVOL NAME>. Specify this or uuid",
+			},
+			cli.StringFlag{
+				Name:  "uuid",
+				Usage: "Specify this or alias",
+			},
+		},
+		Usage: "mark a lvol as read only: \"set-read-only --alias <LVSTORE NAME>/<LVOL NAME>\", or \"set-read-only --uuid <LVOL UUID>\"",
+		Action: func(c *cli.Context) {
+			if err := bdevLvolSetReadOnly(c); err != nil {
+				logrus.WithError(err).Fatalf("Failed to run set read only bdev lvol command")
+			}
+		},
+	}
+}
+
+func bdevLvolSetReadOnly(c *cli.Context) error {
+	spdkCli, err := client.NewClient(context.Background())
+	if err != nil {
+		return err
+	}
+
+	name := c.String("alias")
+	if name == "" {
+		name = c.String("uuid")
+	}
+
+	set, err := spdkCli.BdevLvolSetReadOnly(name)
+	if err != nil {
+		return err
+	}
+
+	return util.PrintObject(set)
+}
+
+func BdevLvolInflateCmd() cli.Command {
+	return cli.Command{
+		Name: "inflate",
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "alias",
+				Usage: "The alias of a lvol is <LVSTORE NAME>/<LVOL NAME>. Specify this or uuid",
+			},
+			cli.StringFlag{
+				Name:  "uuid",
+				Usage: "Specify this or alias",
+			},
+		},
+		Usage: "allocate all clusters of a lvol, copying them from its parent, and remove the dependency on the parent: \"inflate --alias <LVSTORE NAME>/<LVOL NAME>\", or \"inflate --uuid <LVOL UUID>\"",
+		Action: func(c *cli.Context) {
+			if err := bdevLvolInflate(c); err != nil {
+				logrus.WithError(err).Fatalf("Failed to run inflate bdev lvol command")
+			}
+		},
+	}
+}
+
+func bdevLvolInflate(c *cli.Context) error {
+	spdkCli, err := client.NewClient(context.Background())
+	if err != nil {
+		return err
+	}
+
+	name := c.String("alias")
+	if name == "" {
+		name = c.String("uuid")
+	}
+
+	inflated, err := spdkCli.BdevLvolInflate(name)
+	if err != nil {
+		return err
+	}
+
+	return util.PrintObject(inflated)
+}
+
 func BdevLvolSetParentCmd() cli.Command {
 	return cli.Command{
 		Name: "set-parent",
@@ -497,6 +689,10 @@ func BdevLvolStartShallowCopyCmd() cli.Command {
 				Usage:    "Name of the bdev that acts as destination for the copy",
 				Required: true,
 			},
+			cli.BoolFlag{
+				Name:  "wait",
+				Usage: "Wait for the copy to finish, logging its progress, and print the final progress instead of the operation ID",
+			},
 		},
 		Usage: "start a copy of active clusters/data from a read-only logical volume to a bdev: \"shallow-copy-start --src-lvol-alias <LVSTORE NAME>/<LVOL NAME> --dst-bdev-name <BDEV NAME>\", or \"shallow-copy --uuid <LVOL UUID> --dst-bdev-name <BDEV NAME>\"",
 		Action: func(c *cli.Context) {
@@ -518,6 +714,12 @@ func bdevLvolStartShallowCopy(c *cli.Context) error {
 		srcLvolName = c.String("src-lvol-uuid")
 	}
 
+	if c.Bool("wait") {
+		return waitCopy(func(m *client.CopyManager) (*client.CopyOperation, error) {
+			return m.StartShallowCopy(srcLvolName, c.String("dst-bdev-name"))
+		}, spdkCli)
+	}
+
 	operationId, err := spdkCli.BdevLvolStartShallowCopy(srcLvolName, c.String("dst-bdev-name"))
 	if err != nil {
 		return err
@@ -547,6 +749,10 @@ func BdevLvolStartRangeShallowCopyCmd() cli.Command {
 				Name:  "cluster",
 				Usage: "Cluster index to copy/unmap",
 			},
+			cli.BoolFlag{
+				Name:  "wait",
+				Usage: "Wait for the copy to finish, logging its progress, and print the final progress instead of the operation ID",
+			},
 		},
 		Usage: "start a synchronization of clusters in the list from a read-only logical volume to a bdev, copying data of allocated clusters or unmapping data of unallocated clusters:" +
 			"\"shallow-copy-start --src-lvol-alias <LVSTORE NAME>/<LVOL NAME> --dst-bdev-name <BDEV NAME> --cluster <CLUSTER_INDEX_0> --cluster <CLUSTER_INDEX_1> ... \", or " +
@@ -576,6 +782,12 @@ func bdevLvolStartRangeShallowCopy(c *cli.Context) error {
 		clusters = append(clusters, uint64(s))
 	}
 
+	if c.Bool("wait") {
+		return waitCopy(func(m *client.CopyManager) (*client.CopyOperation, error) {
+			return m.StartRangeShallowCopy(srcLvolName, c.String("dst-bdev-name"), clusters)
+		}, spdkCli)
+	}
+
 	operationId, err := spdkCli.BdevLvolStartRangeShallowCopy(srcLvolName, c.String("dst-bdev-name"), clusters)
 	if err != nil {
 		return err
@@ -633,6 +845,10 @@ func BdevLvolStartDeepCopyCmd() cli.Command {
 				Usage:    "Name of the bdev that acts as destination for the copy",
 				Required: true,
 			},
+			cli.BoolFlag{
+				Name:  "wait",
+				Usage: "Wait for the copy to finish, logging its progress, and print the final progress instead of the operation ID",
+			},
 		},
 		Usage: "start a copy of allocated clusters from a read-only logical volume or its ancestors to a bdev: \"deep-copy-start --src-lvol <LVSTORE NAME>/<LVOL NAME> --dst-bdev <BDEV NAME>\"",
 		Action: func(c *cli.Context) {
@@ -651,6 +867,12 @@ func bdevLvolStartDeepCopy(c *cli.Context) error {
 
 	srcLvolName := c.String("src-lvol")
 
+	if c.Bool("wait") {
+		return waitCopy(func(m *client.CopyManager) (*client.CopyOperation, error) {
+			return m.StartDeepCopy(srcLvolName, c.String("dst-bdev"))
+		}, spdkCli)
+	}
+
 	operationId, err := spdkCli.BdevLvolStartDeepCopy(srcLvolName, c.String("dst-bdev"))
 	if err != nil {
 		return err
@@ -659,6 +881,28 @@ func bdevLvolStartDeepCopy(c *cli.Context) error {
 	return util.PrintObject(operationId)
 }
 
+// waitCopy starts a copy with a copy manager, logs its progress until it
+// finishes and prints the final progress.
+func waitCopy(start func(*client.CopyManager) (*client.CopyOperation, error), spdkCli *client.Client) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	m := client.NewCopyManager(ctx, spdkCli, client.DefaultCopyCheckInterval, func(p client.CopyProgress) {
+		logrus.Infof("Copy operation %v from lvol %v to bdev %v: %v, %d/%d clusters, %.1f clusters/s, ETA %v",
+			p.OperationID, p.SrcLvolName, p.DstBdevName, p.State, p.ProcessedClusters, p.TotalClusters, p.ClustersPerSecond, p.ETA.Round(time.Second))
+	})
+	op, err := start(m)
+	if err != nil {
+		return err
+	}
+
+	progress, err := op.Wait(ctx)
+	if printErr := util.PrintObject(progress); printErr != nil {
+		return printErr
+	}
+	return err
+}
+
 func BdevLvolCheckDeepCopyCmd() cli.Command {
 	return cli.Command{
 		Name: "deep-copy-check",
@@ -788,6 +1032,59 @@ func bdevLvolGetXattr(c *cli.Context) error {
 	return util.PrintObject(bdevLvolGetResp)
 }
 
+func BdevLvolGetXattrsCmd() cli.Command {
+	return cli.Command{
+		Name: "get-xattrs",
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "alias",
+				Usage: "The alias of a lvol is <LVSTORE NAME>/<LVOL NAME>. Specify this or uuid",
+			},
+			cli.StringFlag{
+				Name:  "uuid",
+				Usage: "Specify this or alias",
+			},
+			cli.BoolFlag{
+				Name:  "snapshot-metadata",
+				Usage: "Print the xattrs parsed as snapshot metadata",
+			},
+		},
+		Usage: "get the xattrs of the snapshot metadata schema of a lvol: \"get-xattrs --alias <LVSTORE NAME>/<LVOL NAME>\", or \"get-xattrs --uuid <LVOL UUID>\"",
+		Action: func(c *cli.Context) {
+			if err := bdevLvolGetXattrs(c); err != nil {
+				logrus.WithError(err).Fatalf("Failed to run get bdev lvol xattrs command")
+			}
+		},
+	}
+}
+
+func bdevLvolGetXattrs(c *cli.Context) error {
+	spdkCli, err := client.NewClient(context.Background())
+	if err != nil {
+		return err
+	}
+
+	name := c.String("alias")
+	if name == "" {
+		name = c.String("uuid")
+	}
+
+	if c.Bool("snapshot-metadata") {
+		metadata, err := spdkCli.BdevLvolGetSnapshotMetadata(name)
+		if err != nil {
+			return err
+		}
+		return util.PrintObject(metadata)
+	}
+
+	xattrs, err := spdkCli.BdevLvolGetXattrs(name)
+	if err != nil {
+		return err
+	}
+
+	return util.PrintObject(xattrs)
+}
+
 func BdevLvolGetFragmapCmd() cli.Command {
 	return cli.Command{
 		Name: "get-fragmap",
@@ -841,6 +1138,221 @@ func bdevLvolGetFragmap(c *cli.Context) error {
 	return util.PrintObject(output)
 }
 
+func BdevLvolDiffCmd() cli.Command {
+	return cli.Command{
+		Name: "diff",
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "from",
+				Usage: "The alias or uuid of the older snapshot. All the clusters of the chain of the newer lvol are listed if it is not specified",
+			},
+			cli.StringFlag{
+				Name:     "to",
+				Usage:    "The alias or uuid of the newer lvol, based on the older snapshot",
+				Required: true,
+			},
+			cli.BoolFlag{
+				Name:  "use-checksums",
+				Usage: "Drop the clusters rewritten with the same data by comparing the registered range checksums",
+			},
+		},
+		Usage: "get the clusters changed between two lvols of a chain: \"diff --from <LVSTORE NAME>/<SNAPSHOT NAME> --to <LVSTORE NAME>/<LVOL NAME>\"",
+		Action: func(c *cli.Context) {
+			if err := bdevLvolDiff(c); err != nil {
+				logrus.WithError(err).Fatalf("Failed to run diff bdev lvol command")
+			}
+		},
+	}
+}
+
+func bdevLvolDiff(c *cli.Context) error {
+	spdkCli, err := client.NewClient(context.Background())
+	if err != nil {
+		return err
+	}
+
+	diff, err := spdkCli.BdevLvolGetDiff(c.String("from"), c.String("to"), client.LvolDiffOptions{
+		UseChecksums: c.Bool("use-checksums"),
+	})
+	if err != nil {
+		return err
+	}
+
+	return util.PrintObject(diff)
+}
+
+func BdevLvolExportCmd() cli.Command {
+	return cli.Command{
+		Name: "export",
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:     "lvol",
+				Usage:    "The alias or uuid of the lvol or snapshot to export",
+				Required: true,
+			},
+			cli.StringFlag{
+				Name:     "file",
+				Usage:    "The path of the sparse raw file to write",
+				Required: true,
+			},
+			cli.StringFlag{
+				Name:  "frontend",
+				Usage: fmt.Sprintf("How the lvol is exposed on the host: %v or %v", types.FrontendSPDKUblk, types.FrontendSPDKTCPBlockdev),
+				Value: types.FrontendSPDKUblk,
+			},
+			cli.StringFlag{
+				Name:  "port",
+				Usage: "The NVMe/TCP loopback port when the frontend is " + types.FrontendSPDKTCPBlockdev,
+				Value: initiator.DefaultLvolTransferPort,
+			},
+			cli.BoolFlag{
+				Name:  "shallow",
+				Usage: "Only export the clusters allocated to the lvol itself, not the ones of its ancestors",
+			},
+			cli.StringFlag{
+				Name:  "since",
+				Usage: "The alias or uuid of an older snapshot in the chain of the lvol. Only export the clusters changed since this snapshot",
+			},
+			cli.StringFlag{
+				Name:  "host-proc",
+				Usage: fmt.Sprintf("The host proc path of namespace executor. By default %v", commontypes.ProcDirectory),
+				Value: commontypes.ProcDirectory,
+			},
+		},
+		Usage: "export the allocated clusters of a lvol into a sparse raw file: \"export --lvol <LVSTORE NAME>/<LVOL NAME> --file <PATH>\"",
+		Action: func(c *cli.Context) {
+			if err := bdevLvolExport(c); err != nil {
+				logrus.WithError(err).Fatalf("Failed to run export bdev lvol command")
+			}
+		},
+	}
+}
+
+func bdevLvolExport(c *cli.Context) error {
+	spdkCli, err := client.NewClient(context.Background())
+	if err != nil {
+		return err
+	}
+
+	opts := initiator.LvolExportOptions{
+		LvolTransferOptions: initiator.LvolTransferOptions{
+			Frontend:   c.String("frontend"),
+			HostProc:   c.String("host-proc"),
+			Port:       c.String("port"),
+			OnProgress: logLvolTransferProgress(),
+		},
+		Shallow: c.Bool("shallow"),
+	}
+	if since := c.String("since"); since != "" {
+		diff, err := spdkCli.BdevLvolGetDiff(since, c.String("lvol"), client.LvolDiffOptions{})
+		if err != nil {
+			return err
+		}
+		opts.Ranges = diff.Ranges
+	}
+
+	result, err := initiator.ExportLvol(spdkCli, c.String("lvol"), c.String("file"), opts)
+	if err != nil {
+		return err
+	}
+
+	return util.PrintObject(result)
+}
+
+func BdevLvolImportCmd() cli.Command {
+	return cli.Command{
+		Name: "import",
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:     "file",
+				Usage:    "The path of the raw or sparse image to import",
+				Required: true,
+			},
+			cli.StringFlag{
+				Name:     "lvs-name",
+				Usage:    "The name of the lvstore to create the lvol in",
+				Required: true,
+			},
+			cli.StringFlag{
+				Name:     "lvol-name",
+				Usage:    "The name of the lvol to create",
+				Required: true,
+			},
+			cli.StringFlag{
+				Name:  "snapshot-name",
+				Usage: "Take a snapshot with this name once the image is written. Optional",
+			},
+			cli.StringSliceFlag{
+				Name:  "xattr",
+				Usage: "Xattr for the snapshot in the format name=value. Optional",
+			},
+			cli.StringFlag{
+				Name:  "frontend",
+				Usage: fmt.Sprintf("How the lvol is exposed on the host: %v or %v", types.FrontendSPDKUblk, types.FrontendSPDKTCPBlockdev),
+				Value: types.FrontendSPDKUblk,
+			},
+			cli.StringFlag{
+				Name:  "port",
+				Usage: "The NVMe/TCP loopback port when the frontend is " + types.FrontendSPDKTCPBlockdev,
+				Value: initiator.DefaultLvolTransferPort,
+			},
+			cli.StringFlag{
+				Name:  "host-proc",
+				Usage: fmt.Sprintf("The host proc path of namespace executor. By default %v", commontypes.ProcDirectory),
+				Value: commontypes.ProcDirectory,
+			},
+		},
+		Usage: "import a raw or sparse image into a new thin provisioned lvol: \"import --file <PATH> --lvs-name <LVSTORE NAME> --lvol-name <LVOL NAME>\"",
+		Action: func(c *cli.Context) {
+			if err := bdevLvolImport(c); err != nil {
+				logrus.WithError(err).Fatalf("Failed to run import bdev lvol command")
+			}
+		},
+	}
+}
+
+func bdevLvolImport(c *cli.Context) error {
+	xattrs, err := parseXattrs(c.StringSlice("xattr"))
+	if err != nil {
+		return err
+	}
+
+	spdkCli, err := client.NewClient(context.Background())
+	if err != nil {
+		return err
+	}
+
+	result, err := initiator.ImportLvol(spdkCli, c.String("file"), c.String("lvs-name"), c.String("lvol-name"), initiator.LvolImportOptions{
+		LvolTransferOptions: initiator.LvolTransferOptions{
+			Frontend:   c.String("frontend"),
+			HostProc:   c.String("host-proc"),
+			Port:       c.String("port"),
+			OnProgress: logLvolTransferProgress(),
+		},
+		SnapshotName:   c.String("snapshot-name"),
+		SnapshotXattrs: xattrs,
+	})
+	if err != nil {
+		return err
+	}
+
+	return util.PrintObject(result)
+}
+
+// logLvolTransferProgress returns a progress callback that logs every 10%.
+func logLvolTransferProgress() func(done, total uint64) {
+	lastPercent := uint64(0)
+	return func(done, total uint64) {
+		if total == 0 {
+			return
+		}
+		if percent := done * 100 / total; percent >= lastPercent+10 || done == total {
+			lastPercent = percent
+			logrus.Infof("Transferred %d of %d bytes (%d%%)", done, total, percent)
+		}
+	}
+}
+
 func BdevLvolRenameCmd() cli.Command {
 	return cli.Command{
 		Name: "rename",
@@ -24,6 +24,10 @@ func BdevNvmeCmd() cli.Command {
 			BdevNvmeGetControllersCmd(),
 			BdevNvmeGetCmd(),
 			BdevNvmeSetOptionsCmd(),
+			BdevNvmeSetMultipathPolicyCmd(),
+			BdevNvmeGetIOPathsCmd(),
+			BdevNvmeSetPreferredPathCmd(),
+			BdevNvmeGetTransportStatisticsCmd(),
 		},
 	}
 }
@@ -278,3 +282,140 @@ func bdevNvmeSetOptions(c *cli.Context) error {
 
 	return util.PrintObject(result)
 }
+
+func BdevNvmeSetMultipathPolicyCmd() cli.Command {
+	return cli.Command{
+		Name:  "multipath-policy-set",
+		Usage: "set the multipath policy of a nvme bdev: multipath-policy-set --policy <POLICY> <NVME BDEV NAME>",
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "policy",
+				Usage: "Multipath policy: active_passive or active_active",
+				Value: spdktypes.BdevNvmeMultipathPolicyActivePassive,
+			},
+			cli.StringFlag{
+				Name:  "selector",
+				Usage: "Path selector of the active_active policy: round_robin or queue_depth",
+			},
+			cli.UintFlag{
+				Name:  "rr-min-io",
+				Usage: "Number of I/Os sent on a path before switching to the next one with the round_robin selector",
+			},
+		},
+		Action: func(c *cli.Context) {
+			if err := bdevNvmeSetMultipathPolicy(c); err != nil {
+				logrus.WithError(err).Fatalf("Failed to run set nvme multipath policy command")
+			}
+		},
+	}
+}
+
+func bdevNvmeSetMultipathPolicy(c *cli.Context) error {
+	name := c.Args().First()
+	if name == "" {
+		return fmt.Errorf("nvme bdev name is required")
+	}
+
+	spdkCli, err := client.NewClient(context.Background())
+	if err != nil {
+		return err
+	}
+
+	result, err := spdkCli.BdevNvmeSetMultipathPolicy(name, spdktypes.BdevNvmeMultipathPolicy(c.String("policy")),
+		spdktypes.BdevNvmeMultipathSelector(c.String("selector")), uint32(c.Uint("rr-min-io")))
+	if err != nil {
+		return err
+	}
+
+	return util.PrintObject(result)
+}
+
+func BdevNvmeGetIOPathsCmd() cli.Command {
+	return cli.Command{
+		Name:  "io-path-get",
+		Usage: "get the I/O paths of all nvme bdevs if the name is not specified: io-path-get <NVME BDEV NAME>",
+		Action: func(c *cli.Context) {
+			if err := bdevNvmeGetIOPaths(c); err != nil {
+				logrus.WithError(err).Fatalf("Failed to run get nvme I/O paths command")
+			}
+		},
+	}
+}
+
+func bdevNvmeGetIOPaths(c *cli.Context) error {
+	spdkCli, err := client.NewClient(context.Background())
+	if err != nil {
+		return err
+	}
+
+	ioPaths, err := spdkCli.BdevNvmeGetIOPaths(c.Args().First())
+	if err != nil {
+		return err
+	}
+
+	return util.PrintObject(ioPaths)
+}
+
+func BdevNvmeSetPreferredPathCmd() cli.Command {
+	return cli.Command{
+		Name:  "preferred-path-set",
+		Usage: "set the preferred path of a nvme bdev: preferred-path-set --cntlid <CNTLID> <NVME BDEV NAME>",
+		Flags: []cli.Flag{
+			cli.UintFlag{
+				Name:     "cntlid",
+				Usage:    "Controller ID of the preferred path",
+				Required: true,
+			},
+		},
+		Action: func(c *cli.Context) {
+			if err := bdevNvmeSetPreferredPath(c); err != nil {
+				logrus.WithError(err).Fatalf("Failed to run set nvme preferred path command")
+			}
+		},
+	}
+}
+
+func bdevNvmeSetPreferredPath(c *cli.Context) error {
+	name := c.Args().First()
+	if name == "" {
+		return fmt.Errorf("nvme bdev name is required")
+	}
+
+	spdkCli, err := client.NewClient(context.Background())
+	if err != nil {
+		return err
+	}
+
+	result, err := spdkCli.BdevNvmeSetPreferredPath(name, uint16(c.Uint("cntlid")))
+	if err != nil {
+		return err
+	}
+
+	return util.PrintObject(result)
+}
+
+func BdevNvmeGetTransportStatisticsCmd() cli.Command {
+	return cli.Command{
+		Name:  "transport-stats-get",
+		Usage: "get the transport statistics of all nvme bdev poll groups: transport-stats-get",
+		Action: func(c *cli.Context) {
+			if err := bdevNvmeGetTransportStatistics(c); err != nil {
+				logrus.WithError(err).Fatalf("Failed to run get nvme transport statistics command")
+			}
+		},
+	}
+}
+
+func bdevNvmeGetTransportStatistics(c *cli.Context) error {
+	spdkCli, err := client.NewClient(context.Background())
+	if err != nil {
+		return err
+	}
+
+	stats, err := spdkCli.BdevNvmeGetTransportStatistics()
+	if err != nil {
+		return err
+	}
+
+	return util.PrintObject(stats)
+}
@@ -0,0 +1,274 @@
+package initiator
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/cockroachdb/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/longhorn/go-spdk-helper/pkg/spdk/client"
+	spdktypes "github.com/longhorn/go-spdk-helper/pkg/spdk/types"
+	"github.com/longhorn/go-spdk-helper/pkg/types"
+)
+
+const (
+	DefaultLvolTransferPort = "4421"
+
+	lvolTransferBufferSize = 4 * types.MiB
+)
+
+// LvolTransferOptions configures how a lvol is made available on the host
+// for an export or an import.
+type LvolTransferOptions struct {
+	// Frontend is types.FrontendSPDKUblk or types.FrontendSPDKTCPBlockdev.
+	// The latter exposes the lvol over a local NVMe/TCP loopback.
+	Frontend string
+	// HostProc is the host proc directory used by the initiator.
+	HostProc string
+	// Port is the NVMe/TCP loopback port. DefaultLvolTransferPort is used if it is empty.
+	Port string
+	// OnProgress is called after each chunk with the bytes transferred so far
+	// and the bytes to transfer in total. It is optional.
+	OnProgress func(done, total uint64)
+}
+
+// LvolExportOptions configures ExportLvol.
+type LvolExportOptions struct {
+	LvolTransferOptions
+
+	// Shallow exports only the clusters allocated to the lvol itself instead
+	// of the clusters allocated to the lvol or any of its ancestors.
+	Shallow bool
+	// Ranges, if not nil, are the only clusters exported, e.g. the ranges of
+	// a client.LvolDiff for an incremental export. Shallow is then ignored.
+	Ranges []spdktypes.ClusterRange
+}
+
+// LvolExportResult describes an exported lvol.
+type LvolExportResult struct {
+	SizeBytes         uint64 `json:"size_bytes"`
+	ClusterSize       uint64 `json:"cluster_size"`
+	AllocatedClusters uint64 `json:"allocated_clusters"`
+	WrittenBytes      uint64 `json:"written_bytes"`
+}
+
+// ExportLvol writes a lvol or snapshot into a sparse raw file at dstPath. The
+// file is as large as the lvol, and only the clusters allocated to the lvol or
+// its ancestors are read and written; the rest of the file, and the chunks of
+// allocated clusters that read as zeroes, are left as holes.
+//
+// The lvol is exposed on the host via ublk or a local NVMe/TCP loopback for
+// the duration of the export.
+func ExportLvol(spdkClient *client.Client, lvolName, dstPath string, opts LvolExportOptions) (result *LvolExportResult, err error) {
+	bdevs, err := spdkClient.BdevGetBdevs(lvolName, 0)
+	if err != nil {
+		return nil, err
+	}
+	if len(bdevs) != 1 || spdktypes.GetBdevType(&bdevs[0]) != spdktypes.BdevTypeLvol {
+		return nil, fmt.Errorf("cannot find lvol %v", lvolName)
+	}
+	lvol := bdevs[0]
+
+	lvolNames := []string{lvol.Name}
+	if !opts.Shallow && opts.Ranges == nil {
+		tree, err := spdkClient.BdevLvolGetTree()
+		if err != nil {
+			return nil, err
+		}
+		if node := tree.Get(lvol.Name); node != nil {
+			for _, ancestor := range node.Ancestors() {
+				lvolNames = append(lvolNames, ancestor.UUID)
+			}
+		}
+	}
+
+	clusterSize := uint64(0)
+	bitmaps := [][]byte{}
+	for _, name := range lvolNames {
+		fragmap, err := spdkClient.BdevLvolGetFragmap(name, 0, 0)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to get fragmap of lvol %v", name)
+		}
+		bitmap, err := fragmap.Bitmap()
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to decode fragmap of lvol %v", name)
+		}
+		clusterSize = fragmap.ClusterSize
+		bitmaps = append(bitmaps, bitmap)
+	}
+	if clusterSize == 0 {
+		return nil, fmt.Errorf("invalid cluster size 0 of lvol %v", lvolName)
+	}
+
+	size := uint64(lvol.BlockSize) * lvol.NumBlocks
+	numClusters := (size + clusterSize - 1) / clusterSize
+	ranges := opts.Ranges
+	if ranges == nil {
+		ranges = spdktypes.ClusterRangesFromBitmap(spdktypes.MergeBitmaps(bitmaps...), numClusters)
+	}
+
+	devicePath, stop, err := startLvolTransfer(spdkClient, lvol.Name, opts.LvolTransferOptions)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if stopErr := stop(); stopErr != nil {
+			if err == nil {
+				err = stopErr
+			} else {
+				logrus.WithError(stopErr).Warnf("Failed to stop exposing lvol %v after export failure", lvolName)
+			}
+		}
+	}()
+
+	src, err := os.Open(devicePath)
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(dstPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	defer dst.Close()
+
+	if err := dst.Truncate(int64(size)); err != nil {
+		return nil, err
+	}
+
+	logrus.Infof("Exporting %d cluster ranges of lvol %v to %v", len(ranges), lvolName, dstPath)
+	written, err := copyExtents(dst, src, clusterRangesToExtents(ranges, clusterSize, size), opts.OnProgress)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to export lvol %v to %v", lvolName, dstPath)
+	}
+	if err := dst.Sync(); err != nil {
+		return nil, err
+	}
+
+	result = &LvolExportResult{
+		SizeBytes:    size,
+		ClusterSize:  clusterSize,
+		WrittenBytes: written,
+	}
+	for _, r := range ranges {
+		result.AllocatedClusters += r.Count
+	}
+	return result, nil
+}
+
+// byteExtent is a region of a device or file.
+type byteExtent struct {
+	offset uint64
+	length uint64
+}
+
+// clusterRangesToExtents converts cluster ranges to byte extents clipped to size.
+func clusterRangesToExtents(ranges []spdktypes.ClusterRange, clusterSize, size uint64) []byteExtent {
+	extents := []byteExtent{}
+	for _, r := range ranges {
+		start, end := r.Start*clusterSize, (r.Start+r.Count)*clusterSize
+		if end > size {
+			end = size
+		}
+		if start >= end {
+			continue
+		}
+		extents = append(extents, byteExtent{offset: start, length: end - start})
+	}
+	return extents
+}
+
+// copyExtents copies the extents from src to the same offsets in dst,
+// skipping the chunks that are all zeroes. It returns the number of bytes
+// written.
+func copyExtents(dst io.WriterAt, src io.ReaderAt, extents []byteExtent, onProgress func(done, total uint64)) (uint64, error) {
+	total := uint64(0)
+	for _, e := range extents {
+		total += e.length
+	}
+
+	buf := make([]byte, lvolTransferBufferSize)
+	zero := make([]byte, lvolTransferBufferSize)
+	done, written := uint64(0), uint64(0)
+	for _, e := range extents {
+		for offset, end := e.offset, e.offset+e.length; offset < end; {
+			n := end - offset
+			if n > uint64(len(buf)) {
+				n = uint64(len(buf))
+			}
+			chunk := buf[:n]
+			if _, err := src.ReadAt(chunk, int64(offset)); err != nil {
+				return written, err
+			}
+			if !bytes.Equal(chunk, zero[:n]) {
+				if _, err := dst.WriteAt(chunk, int64(offset)); err != nil {
+					return written, err
+				}
+				written += n
+			}
+			offset += n
+			done += n
+			if onProgress != nil {
+				onProgress(done, total)
+			}
+		}
+	}
+
+	return written, nil
+}
+
+// startLvolTransfer exposes the lvol on the host with the frontend of the
+// options and returns the device path and a function that stops exposing it.
+func startLvolTransfer(spdkClient *client.Client, lvolUUID string, opts LvolTransferOptions) (devicePath string, stop func() error, err error) {
+	name := "transfer-" + lvolUUID
+
+	switch opts.Frontend {
+	case types.FrontendSPDKUblk:
+		i, err := NewInitiator(name, opts.HostProc, nil, &UblkInfo{BdevName: lvolUUID, UblkID: UnInitializedUblkId})
+		if err != nil {
+			return "", nil, err
+		}
+		stop = func() error {
+			_, err := i.Stop(spdkClient, true, false, true)
+			return err
+		}
+		if _, err := i.StartUblkInitiator(spdkClient, true); err != nil {
+			if stopErr := stop(); stopErr != nil {
+				logrus.WithError(stopErr).Warnf("Failed to clean up ublk initiator %v", name)
+			}
+			return "", nil, err
+		}
+		return i.Endpoint, stop, nil
+
+	case types.FrontendSPDKTCPBlockdev:
+		port := opts.Port
+		if port == "" {
+			port = DefaultLvolTransferPort
+		}
+		nqn := types.GetNQN(name)
+		if err := spdkClient.StartExposeBdev(nqn, lvolUUID, "", types.LocalIP, port); err != nil {
+			return "", nil, err
+		}
+		i, err := NewInitiator(name, opts.HostProc, &NVMeTCPInfo{SubsystemNQN: nqn}, nil)
+		if err != nil {
+			return "", nil, errors.CombineErrors(err, spdkClient.StopExposeBdev(nqn))
+		}
+		stop = func() error {
+			_, err := i.Stop(nil, true, false, true)
+			return errors.CombineErrors(err, spdkClient.StopExposeBdev(nqn))
+		}
+		if _, err := i.StartNvmeTCPInitiator(types.LocalIP, port, true, false); err != nil {
+			if stopErr := stop(); stopErr != nil {
+				logrus.WithError(stopErr).Warnf("Failed to clean up NVMe/TCP initiator %v", name)
+			}
+			return "", nil, err
+		}
+		return i.Endpoint, stop, nil
+	}
+
+	return "", nil, fmt.Errorf("unsupported frontend %v for lvol transfer", opts.Frontend)
+}
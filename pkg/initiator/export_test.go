@@ -0,0 +1,52 @@
+package initiator
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	spdktypes "github.com/longhorn/go-spdk-helper/pkg/spdk/types"
+)
+
+func TestCopyExtents(t *testing.T) {
+	const clusterSize = 4
+
+	src := bytes.NewReader([]byte("aaaa\x00\x00\x00\x00ccccdddde"))
+	size := uint64(src.Len())
+
+	dstPath := filepath.Join(t.TempDir(), "export.raw")
+	dst, err := os.Create(dstPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dst.Close()
+	if err := dst.Truncate(int64(size)); err != nil {
+		t.Fatal(err)
+	}
+
+	ranges := []spdktypes.ClusterRange{{Start: 0, Count: 1}, {Start: 1, Count: 1}, {Start: 3, Count: 2}}
+	var lastDone, lastTotal uint64
+	written, err := copyExtents(dst, src, clusterRangesToExtents(ranges, clusterSize, size), func(done, total uint64) {
+		lastDone, lastTotal = done, total
+	})
+	if err != nil {
+		t.Fatalf("copyExtents failed: %v", err)
+	}
+
+	// The zero cluster 1 is not written and the last range is clipped to the size.
+	if written != 9 {
+		t.Fatalf("expected 9 bytes written, got %d", written)
+	}
+	if lastDone != 13 || lastTotal != 13 {
+		t.Fatalf("unexpected progress %d/%d", lastDone, lastTotal)
+	}
+
+	got, err := os.ReadFile(dstPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []byte("aaaa\x00\x00\x00\x00\x00\x00\x00\x00dddde"); !bytes.Equal(got, want) {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,156 @@
+package initiator
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/cockroachdb/errors"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+
+	"github.com/longhorn/go-spdk-helper/pkg/jsonrpc"
+	"github.com/longhorn/go-spdk-helper/pkg/spdk/client"
+	"github.com/longhorn/go-spdk-helper/pkg/types"
+)
+
+// LvolImportOptions configures ImportLvol.
+type LvolImportOptions struct {
+	LvolTransferOptions
+
+	// SnapshotName is the name of the snapshot taken of the lvol once the
+	// image is written. No snapshot is taken if it is empty.
+	SnapshotName string
+	// SnapshotXattrs are set on the snapshot.
+	SnapshotXattrs []client.Xattr
+}
+
+// LvolImportResult describes an imported lvol.
+type LvolImportResult struct {
+	LvolUUID     string `json:"lvol_uuid"`
+	SnapshotUUID string `json:"snapshot_uuid,omitempty"`
+	SizeBytes    uint64 `json:"size_bytes"`
+	WrittenBytes uint64 `json:"written_bytes"`
+}
+
+// ImportLvol creates a thin provisioned lvol in the lvstore that is large
+// enough for the raw or sparse image at srcPath, and writes the image into it.
+// Only the data extents of the file are read, and the chunks that are all
+// zeroes are not written, so the lvol stays as sparse as the image.
+//
+// The lvol is exposed on the host via ublk or a local NVMe/TCP loopback for
+// the duration of the import. If the import fails, the lvol is deleted.
+func ImportLvol(spdkClient *client.Client, srcPath, lvsName, lvolName string, opts LvolImportOptions) (result *LvolImportResult, err error) {
+	src, err := os.Open(srcPath)
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+
+	info, err := src.Stat()
+	if err != nil {
+		return nil, err
+	}
+	size := uint64(info.Size())
+	if size == 0 {
+		return nil, fmt.Errorf("image %v is empty", srcPath)
+	}
+
+	extents, err := fileDataExtents(src, size)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to find the data extents of image %v", srcPath)
+	}
+
+	sizeInMib := (size + types.MiB - 1) / types.MiB
+	lvolUUID, err := spdkClient.BdevLvolCreate(lvsName, "", lvolName, sizeInMib, "", true)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err == nil {
+			return
+		}
+		if _, deleteErr := spdkClient.BdevLvolDelete(lvolUUID); deleteErr != nil && !jsonrpc.IsJSONRPCRespErrorNoSuchDevice(deleteErr) {
+			logrus.WithError(deleteErr).Warnf("Failed to delete lvol %v after import failure", lvolUUID)
+		}
+	}()
+
+	written, err := writeImage(spdkClient, lvolUUID, src, extents, opts.LvolTransferOptions)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to import image %v into lvol %v", srcPath, lvolName)
+	}
+
+	result = &LvolImportResult{
+		LvolUUID:     lvolUUID,
+		SizeBytes:    size,
+		WrittenBytes: written,
+	}
+
+	if opts.SnapshotName != "" {
+		snapshotUUID, err := spdkClient.BdevLvolSnapshot(lvolUUID, opts.SnapshotName, opts.SnapshotXattrs)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to snapshot imported lvol %v", lvolName)
+		}
+		result.SnapshotUUID = snapshotUUID
+	}
+
+	return result, nil
+}
+
+func writeImage(spdkClient *client.Client, lvolUUID string, src io.ReaderAt, extents []byteExtent, opts LvolTransferOptions) (written uint64, err error) {
+	devicePath, stop, err := startLvolTransfer(spdkClient, lvolUUID, opts)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		if stopErr := stop(); stopErr != nil {
+			err = errors.CombineErrors(err, stopErr)
+		}
+	}()
+
+	dst, err := os.OpenFile(devicePath, os.O_WRONLY, 0)
+	if err != nil {
+		return 0, err
+	}
+	defer dst.Close()
+
+	logrus.Infof("Importing %d data extents into lvol %v", len(extents), lvolUUID)
+	if written, err = copyExtents(dst, src, extents, opts.OnProgress); err != nil {
+		return written, err
+	}
+	return written, dst.Sync()
+}
+
+// fileDataExtents returns the data extents of the file by skipping its holes.
+// The whole file is one extent if the filesystem cannot report holes.
+func fileDataExtents(f *os.File, size uint64) ([]byteExtent, error) {
+	extents := []byteExtent{}
+	fd := int(f.Fd())
+
+	for offset := int64(0); uint64(offset) < size; {
+		data, err := unix.Seek(fd, offset, unix.SEEK_DATA)
+		if err != nil {
+			if errors.Is(err, unix.ENXIO) {
+				// No data after the offset.
+				break
+			}
+			if errors.Is(err, unix.EINVAL) || errors.Is(err, unix.EOPNOTSUPP) {
+				return []byteExtent{{offset: 0, length: size}}, nil
+			}
+			return nil, err
+		}
+		hole, err := unix.Seek(fd, data, unix.SEEK_HOLE)
+		if err != nil {
+			return nil, err
+		}
+		if uint64(hole) > size {
+			hole = int64(size)
+		}
+		if hole > data {
+			extents = append(extents, byteExtent{offset: uint64(data), length: uint64(hole - data)})
+		}
+		offset = hole
+	}
+
+	return extents, nil
+}
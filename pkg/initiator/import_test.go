@@ -0,0 +1,73 @@
+package initiator
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/longhorn/go-spdk-helper/pkg/types"
+)
+
+func TestFileDataExtentsCoverData(t *testing.T) {
+	dir := t.TempDir()
+	size := uint64(16 * types.MiB)
+
+	src, err := os.Create(filepath.Join(dir, "image.raw"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+	if err := src.Truncate(int64(size)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := src.WriteAt([]byte("head"), 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := src.WriteAt([]byte("tail"), 8*types.MiB); err != nil {
+		t.Fatal(err)
+	}
+
+	extents, err := fileDataExtents(src, size)
+	if err != nil {
+		t.Fatalf("fileDataExtents failed: %v", err)
+	}
+	covered := uint64(0)
+	for _, e := range extents {
+		if e.offset+e.length > size {
+			t.Fatalf("extent %+v is beyond the file size", e)
+		}
+		covered += e.length
+	}
+	if covered == 0 || covered > size {
+		t.Fatalf("unexpected extents %+v", extents)
+	}
+
+	dst, err := os.Create(filepath.Join(dir, "lvol.raw"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dst.Close()
+	if err := dst.Truncate(int64(size)); err != nil {
+		t.Fatal(err)
+	}
+	written, err := copyExtents(dst, src, extents, nil)
+	if err != nil {
+		t.Fatalf("copyExtents failed: %v", err)
+	}
+	if written == 0 || written > covered {
+		t.Fatalf("unexpected written bytes %d for %d covered bytes", written, covered)
+	}
+
+	want, err := os.ReadFile(src.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(dst.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatal("the copied image differs from the source")
+	}
+}
@@ -1,8 +1,10 @@
 package client
 
 import (
+	"fmt"
 	"net"
 	"path/filepath"
+	"strings"
 
 	"github.com/sirupsen/logrus"
 
@@ -80,98 +82,570 @@ func DetectAddressFamily(ip string) spdktypes.NvmeAddressFamily {
 // StartExposeBdev exposes the bdev with the given nqn, bdevName, nguid, ip, and port.
 // If allowedHostNQNs is not empty, only those hosts can connect and the subsystem is
 // hidden from other hosts' discovery log pages.
+// The call is idempotent: an existing subsystem is converged to the requested
+// hosts, namespace and listener, and on failure the changes made so far are
+// rolled back, so it is always safe to retry. The subsystem exposes only the
+// bdev: an existing subsystem with any other namespace is left as it is and an
+// error is returned, since removing a live namespace drops its I/O.
 func (c *Client) StartExposeBdev(nqn, bdevName, nguid, ip, port string, allowedHostNQNs ...string) error {
 	ip = spdkutil.NormalizeNvmeAddr(ip)
 
 	logrus.Infof("Exposing bdev with nqn %v, bdevName %v, nguid %v, ip %v, port %v, allowedHostNQNs %v", nqn, bdevName, nguid, ip, port, allowedHostNQNs)
 
-	nvmfTransportList, err := c.NvmfGetTransports("", "")
+	return c.exposeBdev(exposeBdevSpec{
+		nqn:             nqn,
+		bdevName:        bdevName,
+		nguid:           nguid,
+		allowedHostNQNs: allowedHostNQNs,
+		listeners: normalizeNvmfListeners([]spdktypes.NvmfSubsystemListener{
+			{Address: spdktypes.NvmfSubsystemListenAddress{Traddr: ip, Trsvcid: port}},
+		}),
+	})
+}
+
+// StartExposeBdevWithANAState exposes the bdev with the given nqn, bdevName,
+// nguid, nsUUID, ip, port, initial ANA state, and optional CNTLID range.
+// nsUUID sets a stable namespace UUID so the Linux kernel can aggregate
+// controllers into the same NVMe multipath group. minCntlid/maxCntlid assign
+// a unique controller-ID range per engine to avoid "Duplicate cntlid" errors
+// when multiple targets share one subsystem NQN. Pass 0 for defaults.
+// Like StartExposeBdev, the call is idempotent and rolls back on failure.
+// ANA reporting is only enabled when the subsystem is created, see exposeBdev.
+func (c *Client) StartExposeBdevWithANAState(nqn, bdevName, nguid, nsUUID, ip, port string, anaState spdktypes.NvmfSubsystemListenerAnaState, minCntlid, maxCntlid uint16) error {
+	ip = spdkutil.NormalizeNvmeAddr(ip)
+
+	logrus.Infof("Exposing bdev with nqn %v, bdevName %v, nguid %v, nsUUID %v, ip %v, port %v, anaState %v, minCntlid %v, maxCntlid %v",
+		nqn, bdevName, nguid, nsUUID, ip, port, anaState, minCntlid, maxCntlid)
+
+	return c.exposeBdev(exposeBdevSpec{
+		nqn:          nqn,
+		bdevName:     bdevName,
+		nguid:        nguid,
+		nsUUID:       nsUUID,
+		anaReporting: true,
+		minCntlid:    minCntlid,
+		maxCntlid:    maxCntlid,
+		listeners: normalizeNvmfListeners([]spdktypes.NvmfSubsystemListener{
+			{Address: spdktypes.NvmfSubsystemListenAddress{Traddr: ip, Trsvcid: port}, AnaState: anaState},
+		}),
+	})
+}
+
+// StartExposeBdevWithListeners exposes the bdev with the given nqn, bdevName,
+// nguid, and nsUUID on every listener in listeners, so one subsystem can be
+// reached over several networks at once. Listeners may mix IPv4 and IPv6 as
+// well as TCP and RDMA, and each carries its own ANA state. An empty Trtype
+// defaults to TCP, an empty Adrfam is detected from Traddr, and an empty
+// AnaState keeps the SPDK default. minCntlid/maxCntlid behave as in
+// StartExposeBdevWithANAState.
+// Like StartExposeBdev, the call is idempotent and rolls back on failure.
+// ANA reporting is only enabled when the subsystem is created, see exposeBdev.
+func (c *Client) StartExposeBdevWithListeners(nqn, bdevName, nguid, nsUUID string, listeners []spdktypes.NvmfSubsystemListener, minCntlid, maxCntlid uint16) error {
+	if len(listeners) == 0 {
+		return fmt.Errorf("no listener specified for exposing bdev %v with nqn %v", bdevName, nqn)
+	}
+	listeners = normalizeNvmfListeners(listeners)
+
+	logrus.Infof("Exposing bdev with nqn %v, bdevName %v, nguid %v, nsUUID %v, listeners %+v, minCntlid %v, maxCntlid %v",
+		nqn, bdevName, nguid, nsUUID, listeners, minCntlid, maxCntlid)
+
+	return c.exposeBdev(exposeBdevSpec{
+		nqn:          nqn,
+		bdevName:     bdevName,
+		nguid:        nguid,
+		nsUUID:       nsUUID,
+		anaReporting: true,
+		minCntlid:    minCntlid,
+		maxCntlid:    maxCntlid,
+		listeners:    listeners,
+	})
+}
+
+// exposeBdevSpec is the desired state of a subsystem exposing a single bdev.
+type exposeBdevSpec struct {
+	nqn      string
+	bdevName string
+	nguid    string
+	nsUUID   string
+
+	// allowedHostNQNs restricts the hosts that can connect. Empty means any host.
+	allowedHostNQNs []string
+
+	anaReporting bool
+	minCntlid    uint16
+	maxCntlid    uint16
+
+	listeners []spdktypes.NvmfSubsystemListener
+}
+
+// exposeBdev converges the subsystem described by spec, creating it if it
+// does not exist yet. Every change is paired with an undo step, and on error
+// the steps already done are undone in reverse order. When the subsystem
+// itself was created by this call, deleting it is the only undo needed.
+//
+// ana_reporting can only be set by nvmf_create_subsystem and is not reported
+// by nvmf_get_subsystems, so an existing subsystem is not checked for it. One
+// created without ANA reporting, e.g. by StartExposeBdev, keeps ignoring the
+// listener ANA states until it is stopped and exposed again.
+func (c *Client) exposeBdev(spec exposeBdevSpec) (err error) {
+	created := false
+	rollback := rollbackSteps{}
+	record := func(undo func() error) {
+		if !created {
+			rollback.push(undo)
+		}
+	}
+	defer func() {
+		if err != nil {
+			logrus.WithError(err).Warnf("Rolling back exposing bdev %v with nqn %v", spec.bdevName, spec.nqn)
+			rollback.run(fmt.Sprintf("exposing bdev %v with nqn %v", spec.bdevName, spec.nqn))
+		}
+	}()
+
+	if err := c.ensureNvmfTransports(spec.listeners); err != nil {
+		return err
+	}
+
+	subsystem, err := c.getNvmfSubsystem(spec.nqn)
 	if err != nil {
 		return err
 	}
-	if nvmfTransportList != nil && len(nvmfTransportList) == 0 {
-		logrus.Infof("Creating transport with type %v", spdktypes.NvmeTransportTypeTCP)
-		if _, err := c.NvmfCreateTransport(spdktypes.NvmeTransportTypeTCP); err != nil && !jsonrpc.IsJSONRPCRespErrorTransportTypeAlreadyExists(err) {
+	if subsystem == nil {
+		if subsystem, err = c.createExposeSubsystem(spec); err != nil {
+			return err
+		}
+		rollback.push(func() error {
+			logrus.Infof("Deleting subsystem with nqn %v", spec.nqn)
+			_, err := c.NvmfDeleteSubsystem(spec.nqn, "")
 			return err
+		})
+		created = true
+	} else {
+		logrus.Infof("Subsystem with nqn %v already exists, converging it to the requested state", spec.nqn)
+		if (spec.minCntlid > 0 && subsystem.MinCntlid != spec.minCntlid) || (spec.maxCntlid > 0 && subsystem.MaxCntlid != spec.maxCntlid) {
+			return fmt.Errorf("existing subsystem with nqn %v has cntlid range [%v, %v] instead of the requested [%v, %v], it must be stopped first",
+				spec.nqn, subsystem.MinCntlid, subsystem.MaxCntlid, spec.minCntlid, spec.maxCntlid)
 		}
 	}
 
-	logrus.Infof("Creating subsystem with nqn %v", nqn)
-	if _, err := c.NvmfCreateSubsystem(nqn, len(allowedHostNQNs) == 0); err != nil {
+	if err := c.convergeExposeHosts(spec, subsystem, record); err != nil {
+		return err
+	}
+	if err := c.convergeExposeNamespace(spec, subsystem, record); err != nil {
 		return err
 	}
+	return c.convergeExposeListeners(spec, created, record)
+}
+
+// getNvmfSubsystem returns the subsystem with the given nqn, or nil if it does
+// not exist. Listing all subsystems avoids the "Invalid parameters" error SPDK
+// returns when a specific but non-existing nqn is queried.
+func (c *Client) getNvmfSubsystem(nqn string) (*spdktypes.NvmfSubsystem, error) {
+	subsystemList, err := c.NvmfGetSubsystems("", "")
+	if err != nil {
+		return nil, err
+	}
+	for i := range subsystemList {
+		if subsystemList[i].Nqn == nqn {
+			return &subsystemList[i], nil
+		}
+	}
+	return nil, nil
+}
 
-	for _, hostNQN := range allowedHostNQNs {
+func (c *Client) createExposeSubsystem(spec exposeBdevSpec) (*spdktypes.NvmfSubsystem, error) {
+	subsystem := &spdktypes.NvmfSubsystem{
+		Nqn:       spec.nqn,
+		MinCntlid: spec.minCntlid,
+		MaxCntlid: spec.maxCntlid,
+	}
+
+	if spec.anaReporting {
+		logrus.Infof("Creating subsystem with nqn %v, minCntlid %v, maxCntlid %v", spec.nqn, spec.minCntlid, spec.maxCntlid)
+		if _, err := c.NvmfCreateSubsystemWithCntlid(spec.nqn, spec.minCntlid, spec.maxCntlid); err != nil {
+			return nil, err
+		}
+		subsystem.AllowAnyHost = true
+		return subsystem, nil
+	}
+
+	logrus.Infof("Creating subsystem with nqn %v", spec.nqn)
+	subsystem.AllowAnyHost = len(spec.allowedHostNQNs) == 0
+	if _, err := c.NvmfCreateSubsystem(spec.nqn, subsystem.AllowAnyHost); err != nil {
+		return nil, err
+	}
+	return subsystem, nil
+}
+
+// convergeExposeHosts adds the missing allowed hosts before restricting access
+// and removes the unwanted ones last, so permitted hosts never lose access
+// while the subsystem converges.
+func (c *Client) convergeExposeHosts(spec exposeBdevSpec, subsystem *spdktypes.NvmfSubsystem, record func(func() error)) error {
+	nqn := spec.nqn
+
+	existingHosts := map[string]bool{}
+	for _, h := range subsystem.Hosts {
+		existingHosts[h.Nqn] = true
+	}
+	desiredHosts := map[string]bool{}
+	for _, hostNQN := range spec.allowedHostNQNs {
+		desiredHosts[hostNQN] = true
+		if existingHosts[hostNQN] {
+			continue
+		}
 		logrus.Infof("Adding allowed host %v to subsystem with nqn %v", hostNQN, nqn)
 		if _, err := c.NvmfSubsystemAddHost(nqn, hostNQN); err != nil {
 			return err
 		}
+		existingHosts[hostNQN] = true
+		record(func() error {
+			_, err := c.NvmfSubsystemRemoveHost(nqn, hostNQN)
+			return err
+		})
 	}
 
-	logrus.Infof("Adding NVMe namespace with bdev name %v and nguid %v to subsystem with nqn %v", bdevName, nguid, nqn)
-	if _, err := c.NvmfSubsystemAddNs(nqn, bdevName, nguid); err != nil {
-		return err
+	allowAnyHost := len(spec.allowedHostNQNs) == 0
+	if subsystem.AllowAnyHost != allowAnyHost {
+		logrus.Infof("Setting allow any host to %v for subsystem with nqn %v", allowAnyHost, nqn)
+		if _, err := c.NvmfSubsystemAllowAnyHost(nqn, allowAnyHost); err != nil {
+			return err
+		}
+		record(func() error {
+			_, err := c.NvmfSubsystemAllowAnyHost(nqn, !allowAnyHost)
+			return err
+		})
+	}
+
+	for _, h := range subsystem.Hosts {
+		hostNQN := h.Nqn
+		if desiredHosts[hostNQN] {
+			continue
+		}
+		logrus.Infof("Removing host %v from subsystem with nqn %v", hostNQN, nqn)
+		if _, err := c.NvmfSubsystemRemoveHost(nqn, hostNQN); err != nil {
+			return err
+		}
+		record(func() error {
+			_, err := c.NvmfSubsystemAddHost(nqn, hostNQN)
+			return err
+		})
 	}
 
-	adrfam := DetectAddressFamily(ip)
+	return nil
+}
 
-	logrus.Infof("Adding listener with transport address %v, transport service id %v, transport type %v, address family %v to subsystem with nqn %v", ip, port, spdktypes.NvmeTransportTypeTCP, adrfam, nqn)
-	if _, err := c.NvmfSubsystemAddListener(nqn, ip, port, spdktypes.NvmeTransportTypeTCP, adrfam); err != nil {
+// convergeExposeNamespace adds the requested bdev as the only namespace of
+// the subsystem, unless it is already its namespace. SPDK reports the bdev of
+// a namespace by its name, e.g. the UUID of a lvol, so the requested bdev is
+// matched by name and aliases. A namespace of an existing subsystem that is
+// not the requested one, including one backed by the bdev with a different
+// nguid or UUID, which cannot be changed in place, is an error: the subsystem
+// must be stopped first.
+func (c *Client) convergeExposeNamespace(spec exposeBdevSpec, subsystem *spdktypes.NvmfSubsystem, record func(func() error)) error {
+	nqn := spec.nqn
+
+	if len(subsystem.Namespaces) > 0 {
+		bdevNames, err := c.getBdevNames(spec.bdevName)
+		if err != nil {
+			return err
+		}
+		for _, ns := range subsystem.Namespaces {
+			if !bdevNames[ns.BdevName] {
+				return fmt.Errorf("existing subsystem with nqn %v has namespace %v with bdev %v instead of the requested bdev %v, it must be stopped first",
+					nqn, ns.Nsid, ns.BdevName, spec.bdevName)
+			}
+			if !namespaceIdentityMatches(ns, spec.nguid, spec.nsUUID) {
+				return fmt.Errorf("existing subsystem with nqn %v has namespace %v with nguid %v and uuid %v instead of the requested %v and %v, it must be stopped first",
+					nqn, ns.Nsid, ns.Nguid, ns.UUID, spec.nguid, spec.nsUUID)
+			}
+		}
+		return nil
+	}
+
+	logrus.Infof("Adding NVMe namespace with bdev name %v, nguid %v, uuid %v to subsystem with nqn %v", spec.bdevName, spec.nguid, spec.nsUUID, nqn)
+	nsid, err := c.NvmfSubsystemAddNsWithUUID(nqn, spec.bdevName, spec.nguid, spec.nsUUID)
+	if err != nil {
 		return err
 	}
+	record(func() error {
+		_, err := c.NvmfSubsystemRemoveNs(nqn, nsid)
+		return err
+	})
 
 	return nil
 }
 
-// StartExposeBdevWithANAState exposes the bdev with the given nqn, bdevName,
-// nguid, nsUUID, ip, port, initial ANA state, and optional CNTLID range.
-// nsUUID sets a stable namespace UUID so the Linux kernel can aggregate
-// controllers into the same NVMe multipath group. minCntlid/maxCntlid assign
-// a unique controller-ID range per engine to avoid "Duplicate cntlid" errors
-// when multiple targets share one subsystem NQN. Pass 0 for defaults.
-func (c *Client) StartExposeBdevWithANAState(nqn, bdevName, nguid, nsUUID, ip, port string, anaState spdktypes.NvmfSubsystemListenerAnaState, minCntlid, maxCntlid uint16) error {
-	ip = spdkutil.NormalizeNvmeAddr(ip)
+// getBdevNames returns the name and the aliases of a bdev, as well as the
+// given name. Only the given name is returned if the bdev does not exist.
+func (c *Client) getBdevNames(name string) (map[string]bool, error) {
+	names := map[string]bool{name: true}
+	bdevs, err := c.BdevGetBdevs(name, 0)
+	if err != nil {
+		if jsonrpc.IsJSONRPCRespErrorNoSuchDevice(err) {
+			return names, nil
+		}
+		return nil, err
+	}
+	for _, bdev := range bdevs {
+		names[bdev.Name] = true
+		for _, alias := range bdev.Aliases {
+			names[alias] = true
+		}
+	}
+	return names, nil
+}
 
-	logrus.Infof("Exposing bdev with nqn %v, bdevName %v, nguid %v, nsUUID %v, ip %v, port %v, anaState %v, minCntlid %v, maxCntlid %v",
-		nqn, bdevName, nguid, nsUUID, ip, port, anaState, minCntlid, maxCntlid)
+// namespaceIdentityMatches reports whether the namespace has the requested
+// nguid and UUID. Empty values are not compared, and SPDK may report them in
+// a different case or without dashes.
+func namespaceIdentityMatches(ns spdktypes.NvmfSubsystemNamespace, nguid, nsUUID string) bool {
+	normalize := func(id string) string {
+		return strings.ToLower(strings.ReplaceAll(id, "-", ""))
+	}
+	if nguid != "" && normalize(ns.Nguid) != normalize(nguid) {
+		return false
+	}
+	if nsUUID != "" && normalize(ns.UUID) != normalize(nsUUID) {
+		return false
+	}
+	return true
+}
 
-	nvmfTransportList, err := c.NvmfGetTransports("", "")
-	if err != nil {
-		return err
+// convergeExposeListeners adds the missing listeners and updates ANA states
+// before removing the unwanted listeners, so hosts keep a path to the
+// subsystem while it converges.
+func (c *Client) convergeExposeListeners(spec exposeBdevSpec, created bool, record func(func() error)) error {
+	nqn := spec.nqn
+
+	current := []spdktypes.NvmfSubsystemListener{}
+	if !created {
+		var err error
+		if current, err = c.NvmfSubsystemGetListeners(nqn, ""); err != nil {
+			return err
+		}
 	}
-	if nvmfTransportList != nil && len(nvmfTransportList) == 0 {
-		logrus.Infof("Creating transport with type %v", spdktypes.NvmeTransportTypeTCP)
-		if _, err := c.NvmfCreateTransport(spdktypes.NvmeTransportTypeTCP); err != nil && !jsonrpc.IsJSONRPCRespErrorTransportTypeAlreadyExists(err) {
+	toAdd, toRemove, toUpdate := diffNvmfListeners(current, spec.listeners)
+
+	for _, l := range toAdd {
+		added := l
+		if err := c.addNvmfListener(nqn, added); err != nil {
 			return err
 		}
+		record(func() error {
+			_, err := c.NvmfSubsystemRemoveListener(nqn, added.Address.Traddr, added.Address.Trsvcid, added.Address.Trtype, added.Address.Adrfam)
+			return err
+		})
 	}
 
-	logrus.Infof("Creating subsystem with nqn %v, minCntlid %v, maxCntlid %v", nqn, minCntlid, maxCntlid)
-	if _, err := c.NvmfCreateSubsystemWithCntlid(nqn, minCntlid, maxCntlid); err != nil {
+	for _, l := range toUpdate {
+		updated := l
+		previousState := currentNvmfListenerAnaState(current, updated.Address)
+		logrus.Infof("Setting listener ANA state to %v for transport address %v, transport service id %v of subsystem with nqn %v",
+			updated.AnaState, updated.Address.Traddr, updated.Address.Trsvcid, nqn)
+		if _, err := c.NvmfSubsystemListenerSetANAState(nqn, updated.Address.Traddr, updated.Address.Trsvcid, updated.Address.Trtype,
+			updated.Address.Adrfam, updated.AnaState, spdktypes.DefaultNvmfANAGroupID); err != nil {
+			return err
+		}
+		if previousState == "" {
+			continue
+		}
+		record(func() error {
+			_, err := c.NvmfSubsystemListenerSetANAState(nqn, updated.Address.Traddr, updated.Address.Trsvcid, updated.Address.Trtype,
+				updated.Address.Adrfam, previousState, spdktypes.DefaultNvmfANAGroupID)
+			return err
+		})
+	}
+
+	for _, l := range toRemove {
+		removed := l
+		logrus.Infof("Removing listener with transport address %v, transport service id %v, transport type %v, address family %v from subsystem with nqn %v",
+			removed.Address.Traddr, removed.Address.Trsvcid, removed.Address.Trtype, removed.Address.Adrfam, nqn)
+		if _, err := c.NvmfSubsystemRemoveListener(nqn, removed.Address.Traddr, removed.Address.Trsvcid, removed.Address.Trtype, removed.Address.Adrfam); err != nil {
+			return err
+		}
+		if !spec.anaReporting {
+			removed.AnaState = ""
+		}
+		record(func() error {
+			return c.addNvmfListener(nqn, removed)
+		})
+	}
+
+	return nil
+}
+
+func currentNvmfListenerAnaState(current []spdktypes.NvmfSubsystemListener, address spdktypes.NvmfSubsystemListenAddress) spdktypes.NvmfSubsystemListenerAnaState {
+	key := nvmfListenAddressKey(address)
+	for _, l := range current {
+		if nvmfListenAddressKey(l.Address) == key {
+			return l.AnaState
+		}
+	}
+	return ""
+}
+
+// ReconcileExposeListeners converges the listeners of the subsystem with the
+// given nqn to the desired set. Missing listeners are added and existing ones
+// whose ANA state differs are updated before the listeners not in the set are
+// removed. Listeners are normalized as in StartExposeBdevWithListeners. On
+// failure the changes made so far are rolled back.
+func (c *Client) ReconcileExposeListeners(nqn string, listeners []spdktypes.NvmfSubsystemListener) (err error) {
+	listeners = normalizeNvmfListeners(listeners)
+
+	rollback := rollbackSteps{}
+	defer func() {
+		if err != nil {
+			rollback.run(fmt.Sprintf("reconciling listeners of subsystem with nqn %v", nqn))
+		}
+	}()
+
+	if err := c.ensureNvmfTransports(listeners); err != nil {
 		return err
 	}
 
-	logrus.Infof("Adding NVMe namespace with bdev name %v, nguid %v, uuid %v to subsystem with nqn %v", bdevName, nguid, nsUUID, nqn)
-	if _, err := c.NvmfSubsystemAddNsWithUUID(nqn, bdevName, nguid, nsUUID); err != nil {
+	spec := exposeBdevSpec{
+		nqn:          nqn,
+		anaReporting: true,
+		listeners:    listeners,
+	}
+	return c.convergeExposeListeners(spec, false, rollback.push)
+}
+
+// rollbackSteps collects the undo steps of a multi-step operation so they can
+// be run in reverse order when a later step fails.
+type rollbackSteps []func() error
+
+func (r *rollbackSteps) push(undo func() error) {
+	*r = append(*r, undo)
+}
+
+// run executes the undo steps in reverse order. It is best effort: failures
+// are logged and do not stop the remaining steps.
+func (r rollbackSteps) run(operation string) {
+	for i := len(r) - 1; i >= 0; i-- {
+		if err := r[i](); err != nil {
+			logrus.WithError(err).Warnf("Failed to roll back a step of %v", operation)
+		}
+	}
+}
+
+// ensureNvmfTransports creates the NVMe-oF transports the listeners need
+// unless they already exist.
+func (c *Client) ensureNvmfTransports(listeners []spdktypes.NvmfSubsystemListener) error {
+	if len(listeners) == 0 {
+		return nil
+	}
+
+	nvmfTransportList, err := c.NvmfGetTransports("", "")
+	if err != nil {
 		return err
 	}
+	existing := map[string]bool{}
+	for _, t := range nvmfTransportList {
+		existing[strings.ToLower(string(t.Trtype))] = true
+	}
+
+	for _, l := range listeners {
+		trtype := strings.ToLower(string(l.Address.Trtype))
+		if existing[trtype] {
+			continue
+		}
+		logrus.Infof("Creating transport with type %v", l.Address.Trtype)
+		if _, err := c.NvmfCreateTransport(l.Address.Trtype); err != nil && !jsonrpc.IsJSONRPCRespErrorTransportTypeAlreadyExists(err) {
+			return err
+		}
+		existing[trtype] = true
+	}
 
-	adrfam := DetectAddressFamily(ip)
+	return nil
+}
 
-	logrus.Infof("Adding listener with transport address %v, transport service id %v, transport type %v, address family %v to subsystem with nqn %v", ip, port, spdktypes.NvmeTransportTypeTCP, adrfam, nqn)
-	if _, err := c.NvmfSubsystemAddListener(nqn, ip, port, spdktypes.NvmeTransportTypeTCP, adrfam); err != nil {
+// addNvmfListener adds the listener to the subsystem and applies its ANA state if set.
+func (c *Client) addNvmfListener(nqn string, l spdktypes.NvmfSubsystemListener) error {
+	logrus.Infof("Adding listener with transport address %v, transport service id %v, transport type %v, address family %v to subsystem with nqn %v",
+		l.Address.Traddr, l.Address.Trsvcid, l.Address.Trtype, l.Address.Adrfam, nqn)
+	if _, err := c.NvmfSubsystemAddListener(nqn, l.Address.Traddr, l.Address.Trsvcid, l.Address.Trtype, l.Address.Adrfam); err != nil {
 		return err
 	}
 
-	logrus.Infof("Setting listener ANA state to %v for subsystem with nqn %v", anaState, nqn)
-	if _, err := c.NvmfSubsystemListenerSetANAState(nqn, ip, port, spdktypes.NvmeTransportTypeTCP,
-		adrfam, anaState, spdktypes.DefaultNvmfANAGroupID); err != nil {
+	if l.AnaState == "" {
+		return nil
+	}
+
+	logrus.Infof("Setting listener ANA state to %v for transport address %v, transport service id %v of subsystem with nqn %v",
+		l.AnaState, l.Address.Traddr, l.Address.Trsvcid, nqn)
+	if _, err := c.NvmfSubsystemListenerSetANAState(nqn, l.Address.Traddr, l.Address.Trsvcid, l.Address.Trtype,
+		l.Address.Adrfam, l.AnaState, spdktypes.DefaultNvmfANAGroupID); err != nil {
 		return err
 	}
 
 	return nil
 }
 
+// normalizeNvmfListeners fills in the defaults of the listeners: bare IPv6
+// addresses, TCP transport, and the address family detected from the address.
+func normalizeNvmfListeners(listeners []spdktypes.NvmfSubsystemListener) []spdktypes.NvmfSubsystemListener {
+	normalized := make([]spdktypes.NvmfSubsystemListener, 0, len(listeners))
+	for _, l := range listeners {
+		l.Address.Traddr = spdkutil.NormalizeNvmeAddr(l.Address.Traddr)
+		if l.Address.Trtype == "" {
+			l.Address.Trtype = spdktypes.NvmeTransportTypeTCP
+		}
+		if l.Address.Adrfam == "" {
+			l.Address.Adrfam = DetectAddressFamily(l.Address.Traddr)
+		}
+		normalized = append(normalized, l)
+	}
+	return normalized
+}
+
+// nvmfListenAddressKey identifies a listen address regardless of how SPDK
+// spells it. nvmf_subsystem_get_listeners reports trtype and adrfam in upper
+// case (e.g. "TCP", "IPv4"), and IPv6 addresses may be written differently.
+func nvmfListenAddressKey(a spdktypes.NvmfSubsystemListenAddress) string {
+	traddr := spdkutil.NormalizeNvmeAddr(a.Traddr)
+	if ip := net.ParseIP(traddr); ip != nil {
+		traddr = ip.String()
+	}
+	return strings.ToLower(fmt.Sprintf("%s/%s/%s/%s", a.Trtype, a.Adrfam, traddr, a.Trsvcid))
+}
+
+// diffNvmfListeners compares the current listeners of a subsystem with the
+// desired ones. toUpdate holds the desired listeners that already exist but
+// whose ANA state differs; a desired listener without ANA state never needs an update.
+func diffNvmfListeners(current, desired []spdktypes.NvmfSubsystemListener) (toAdd, toRemove, toUpdate []spdktypes.NvmfSubsystemListener) {
+	currentMap := map[string]spdktypes.NvmfSubsystemListener{}
+	for _, l := range current {
+		currentMap[nvmfListenAddressKey(l.Address)] = l
+	}
+
+	desiredKeys := map[string]bool{}
+	for _, l := range desired {
+		key := nvmfListenAddressKey(l.Address)
+		if desiredKeys[key] {
+			continue
+		}
+		desiredKeys[key] = true
+
+		existing, ok := currentMap[key]
+		if !ok {
+			toAdd = append(toAdd, l)
+			continue
+		}
+		if l.AnaState != "" && !strings.EqualFold(string(existing.AnaState), string(l.AnaState)) {
+			toUpdate = append(toUpdate, l)
+		}
+	}
+
+	for _, l := range current {
+		if !desiredKeys[nvmfListenAddressKey(l.Address)] {
+			toRemove = append(toRemove, l)
+		}
+	}
+
+	return toAdd, toRemove, toUpdate
+}
+
 // StopExposeBdev stops exposing the bdev with the given nqn.
 func (c *Client) StopExposeBdev(nqn string) error {
 	logrus.Infof("Stopping exposing bdev with nqn %v", nqn)
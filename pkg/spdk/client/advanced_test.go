@@ -3,6 +3,8 @@ package client
 import (
 	"testing"
 
+	"github.com/longhorn/go-spdk-helper/pkg/jsonrpc"
+
 	spdktypes "github.com/longhorn/go-spdk-helper/pkg/spdk/types"
 )
 
@@ -30,3 +32,238 @@ func TestDetectAddressFamily(t *testing.T) {
 		})
 	}
 }
+
+func testListener(trtype spdktypes.NvmeTransportType, adrfam spdktypes.NvmeAddressFamily, traddr, trsvcid string, anaState spdktypes.NvmfSubsystemListenerAnaState) spdktypes.NvmfSubsystemListener {
+	return spdktypes.NvmfSubsystemListener{
+		Address: spdktypes.NvmfSubsystemListenAddress{
+			Trtype:  trtype,
+			Adrfam:  adrfam,
+			Traddr:  traddr,
+			Trsvcid: trsvcid,
+		},
+		AnaState: anaState,
+	}
+}
+
+func TestDiffNvmfListeners(t *testing.T) {
+	// nvmf_subsystem_get_listeners reports trtype and adrfam in upper case.
+	current := []spdktypes.NvmfSubsystemListener{
+		testListener("TCP", "IPv4", "10.0.0.1", "4420", spdktypes.NvmfSubsystemListenerAnaStateOptimized),
+		testListener("TCP", "IPv6", "fd00::1", "4420", spdktypes.NvmfSubsystemListenerAnaStateOptimized),
+		testListener("TCP", "IPv4", "10.0.0.9", "4420", spdktypes.NvmfSubsystemListenerAnaStateOptimized),
+	}
+	desired := normalizeNvmfListeners([]spdktypes.NvmfSubsystemListener{
+		testListener("", "", "10.0.0.1", "4420", ""),
+		testListener("", "", "[fd00:0::1]", "4420", spdktypes.NvmfSubsystemListenerAnaStateInaccessible),
+		testListener(spdktypes.NvmeTransportTypeRDMA, "", "192.168.0.1", "4420", spdktypes.NvmfSubsystemListenerAnaStateNonOptimized),
+	})
+
+	toAdd, toRemove, toUpdate := diffNvmfListeners(current, desired)
+
+	if len(toAdd) != 1 || toAdd[0].Address.Traddr != "192.168.0.1" || toAdd[0].Address.Adrfam != spdktypes.NvmeAddressFamilyIPv4 {
+		t.Fatalf("unexpected listeners to add %+v", toAdd)
+	}
+	if len(toRemove) != 1 || toRemove[0].Address.Traddr != "10.0.0.9" {
+		t.Fatalf("unexpected listeners to remove %+v", toRemove)
+	}
+	if len(toUpdate) != 1 || toUpdate[0].Address.Traddr != "fd00:0::1" || toUpdate[0].AnaState != spdktypes.NvmfSubsystemListenerAnaStateInaccessible {
+		t.Fatalf("unexpected listeners to update %+v", toUpdate)
+	}
+}
+
+func TestReconcileExposeListeners(t *testing.T) {
+	listenAddress := func(trtype, adrfam, traddr string) map[string]interface{} {
+		return map[string]interface{}{
+			"trtype":  trtype,
+			"adrfam":  adrfam,
+			"traddr":  traddr,
+			"trsvcid": "4420",
+		}
+	}
+
+	steps := []jsonRPCScriptStep{
+		{
+			method: "nvmf_get_transports",
+			result: []spdktypes.NvmfTransport{{Trtype: "TCP"}},
+		},
+		{
+			method: "nvmf_create_transport",
+			params: map[string]interface{}{"trtype": "rdma"},
+			result: true,
+		},
+		{
+			method: "nvmf_subsystem_get_listeners",
+			params: map[string]interface{}{"nqn": "nqn.test"},
+			result: []spdktypes.NvmfSubsystemListener{
+				testListener("TCP", "IPv4", "10.0.0.1", "4420", spdktypes.NvmfSubsystemListenerAnaStateOptimized),
+				testListener("TCP", "IPv4", "10.0.0.9", "4420", spdktypes.NvmfSubsystemListenerAnaStateOptimized),
+			},
+		},
+		{
+			method: "nvmf_subsystem_add_listener",
+			params: map[string]interface{}{"nqn": "nqn.test", "listen_address": listenAddress("rdma", "ipv6", "fd00::1")},
+			result: true,
+		},
+		{
+			method: "nvmf_subsystem_listener_set_ana_state",
+			params: map[string]interface{}{
+				"nqn":            "nqn.test",
+				"listen_address": listenAddress("rdma", "ipv6", "fd00::1"),
+				"ana_state":      "non_optimized",
+				"anagrpid":       float64(spdktypes.DefaultNvmfANAGroupID),
+			},
+			result: true,
+		},
+		{
+			method: "nvmf_subsystem_remove_listener",
+			params: map[string]interface{}{"nqn": "nqn.test", "listen_address": listenAddress("TCP", "IPv4", "10.0.0.9")},
+			result: true,
+		},
+	}
+
+	runJSONRPCScriptTest(t, steps, func(cli *Client) {
+		err := cli.ReconcileExposeListeners("nqn.test", []spdktypes.NvmfSubsystemListener{
+			testListener("", "", "10.0.0.1", "4420", ""),
+			testListener(spdktypes.NvmeTransportTypeRDMA, "", "[fd00::1]", "4420", spdktypes.NvmfSubsystemListenerAnaStateNonOptimized),
+		})
+		if err != nil {
+			t.Fatalf("ReconcileExposeListeners failed: %v", err)
+		}
+	})
+}
+
+func TestStartExposeBdev(t *testing.T) {
+	tcpListenAddress := map[string]interface{}{
+		"trtype":  "tcp",
+		"adrfam":  "ipv4",
+		"traddr":  "10.0.0.1",
+		"trsvcid": "4420",
+	}
+	transportsStep := jsonRPCScriptStep{
+		method: "nvmf_get_transports",
+		result: []spdktypes.NvmfTransport{{Trtype: "TCP"}},
+	}
+	addListenerStep := func(responseError *jsonrpc.ResponseError) jsonRPCScriptStep {
+		return jsonRPCScriptStep{
+			method:        "nvmf_subsystem_add_listener",
+			params:        map[string]interface{}{"nqn": "nqn.test", "listen_address": tcpListenAddress},
+			result:        true,
+			responseError: responseError,
+		}
+	}
+	existingSubsystem := spdktypes.NvmfSubsystem{
+		Nqn:          "nqn.test",
+		AllowAnyHost: true,
+		Namespaces:   []spdktypes.NvmfSubsystemNamespace{{Nsid: 1, BdevName: "bdev0"}},
+	}
+
+	bdev0 := spdktypes.BdevInfo{BdevInfoBasic: spdktypes.BdevInfoBasic{Name: "bdev0"}}
+	getBdev0Step := jsonRPCScriptStep{method: "bdev_get_bdevs", params: map[string]interface{}{"name": "bdev0"}, result: []spdktypes.BdevInfo{bdev0}}
+
+	tests := []struct {
+		name     string
+		bdevName string
+		hosts    []string
+		steps    []jsonRPCScriptStep
+		wantErr  bool
+	}{
+		{
+			name: "creates a new subsystem",
+			steps: []jsonRPCScriptStep{
+				transportsStep,
+				{method: "nvmf_get_subsystems", result: []spdktypes.NvmfSubsystem{}},
+				{method: "nvmf_create_subsystem", params: map[string]interface{}{"nqn": "nqn.test", "allow_any_host": true}, result: true},
+				{method: "nvmf_subsystem_add_ns", params: map[string]interface{}{"nqn": "nqn.test", "namespace": map[string]interface{}{"bdev_name": "bdev0"}}, result: 1},
+				addListenerStep(nil),
+			},
+		},
+		{
+			name: "deletes the created subsystem on failure",
+			steps: []jsonRPCScriptStep{
+				transportsStep,
+				{method: "nvmf_get_subsystems", result: []spdktypes.NvmfSubsystem{}},
+				{method: "nvmf_create_subsystem", params: map[string]interface{}{"nqn": "nqn.test", "allow_any_host": true}, result: true},
+				{method: "nvmf_subsystem_add_ns", params: map[string]interface{}{"nqn": "nqn.test", "namespace": map[string]interface{}{"bdev_name": "bdev0"}}, result: 1},
+				addListenerStep(&jsonrpc.ResponseError{Code: jsonrpc.RespErrorCodeInternalError, Message: "Invalid parameters"}),
+				{method: "nvmf_delete_subsystem", params: map[string]interface{}{"nqn": "nqn.test"}, result: true},
+			},
+			wantErr: true,
+		},
+		{
+			name: "converges a half-configured subsystem",
+			steps: []jsonRPCScriptStep{
+				transportsStep,
+				{method: "nvmf_get_subsystems", result: []spdktypes.NvmfSubsystem{existingSubsystem}},
+				getBdev0Step,
+				{method: "nvmf_subsystem_get_listeners", params: map[string]interface{}{"nqn": "nqn.test"}, result: []spdktypes.NvmfSubsystemListener{}},
+				addListenerStep(nil),
+			},
+		},
+		{
+			name:     "matches the namespace of an existing subsystem by bdev alias",
+			bdevName: "lvs0/lvol0",
+			steps: []jsonRPCScriptStep{
+				transportsStep,
+				{method: "nvmf_get_subsystems", result: []spdktypes.NvmfSubsystem{{
+					Nqn:          "nqn.test",
+					AllowAnyHost: true,
+					Namespaces:   []spdktypes.NvmfSubsystemNamespace{{Nsid: 1, BdevName: "lvol0-uuid"}},
+				}}},
+				{
+					method: "bdev_get_bdevs",
+					params: map[string]interface{}{"name": "lvs0/lvol0"},
+					result: []spdktypes.BdevInfo{{BdevInfoBasic: spdktypes.BdevInfoBasic{Name: "lvol0-uuid", Aliases: []string{"lvs0/lvol0"}}}},
+				},
+				{method: "nvmf_subsystem_get_listeners", params: map[string]interface{}{"nqn": "nqn.test"}, result: []spdktypes.NvmfSubsystemListener{}},
+				addListenerStep(nil),
+			},
+		},
+		{
+			name: "refuses to remove the other namespaces of an existing subsystem",
+			steps: []jsonRPCScriptStep{
+				transportsStep,
+				{method: "nvmf_get_subsystems", result: []spdktypes.NvmfSubsystem{{
+					Nqn:          "nqn.test",
+					AllowAnyHost: true,
+					Namespaces:   []spdktypes.NvmfSubsystemNamespace{{Nsid: 1, BdevName: "bdev0"}, {Nsid: 2, BdevName: "bdev1"}},
+				}}},
+				getBdev0Step,
+			},
+			wantErr: true,
+		},
+		{
+			name:  "keeps an existing subsystem on failure",
+			hosts: []string{"nqn.host"},
+			steps: []jsonRPCScriptStep{
+				transportsStep,
+				{method: "nvmf_get_subsystems", result: []spdktypes.NvmfSubsystem{existingSubsystem}},
+				{method: "nvmf_subsystem_add_host", params: map[string]interface{}{"nqn": "nqn.test", "host": "nqn.host"}, result: true},
+				{
+					method:        "nvmf_subsystem_allow_any_host",
+					params:        map[string]interface{}{"nqn": "nqn.test", "allow_any_host": false},
+					responseError: &jsonrpc.ResponseError{Code: jsonrpc.RespErrorCodeInternalError, Message: "Internal error"},
+				},
+				{method: "nvmf_subsystem_remove_host", params: map[string]interface{}{"nqn": "nqn.test", "host": "nqn.host"}, result: true},
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			runJSONRPCScriptTest(t, test.steps, func(cli *Client) {
+				bdevName := test.bdevName
+				if bdevName == "" {
+					bdevName = "bdev0"
+				}
+				err := cli.StartExposeBdev("nqn.test", bdevName, "", "10.0.0.1", "4420", test.hosts...)
+				if test.wantErr && err == nil {
+					t.Fatal("StartExposeBdev unexpectedly succeeded")
+				}
+				if !test.wantErr && err != nil {
+					t.Fatalf("StartExposeBdev failed: %v", err)
+				}
+			})
+		})
+	}
+}
@@ -0,0 +1,172 @@
+package client
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+
+	spdktypes "github.com/longhorn/go-spdk-helper/pkg/spdk/types"
+)
+
+// FailoverANA makes the target named primary the optimized path of the
+// subsystem with the given nqn and demotes the other targets to non-optimized.
+// Every target exposes the same nqn, e.g. via StartExposeBdevWithANAState with
+// distinct cntlid ranges, and all listeners of the subsystem on a target are
+// moved together.
+//
+// The states are moved in a safe order so that no two targets are ever
+// optimized at the same time:
+//
+//  1. The other targets are made inaccessible.
+//  2. The primary is made optimized. Between step 1 and 2 the hosts see the
+//     ANA change and wait for the new optimized path.
+//  3. The other targets are made non-optimized.
+//
+// SPDK rejects "change" as a listener ANA state since it is the transitional
+// state the target reports by itself, so it is not set explicitly. Each step is
+// verified with nvmf_subsystem_get_listeners. On failure the listeners are
+// rolled back to the states they had before the failover in the same safe
+// order: the other targets are made inaccessible, then the primary and
+// finally the other targets get their original states back. Only the targets
+// whose states were changed are rolled back.
+func FailoverANA(nqn, primary string, targets map[string]*Client) (err error) {
+	if _, ok := targets[primary]; !ok {
+		return fmt.Errorf("primary %v is not one of the targets of subsystem with nqn %v", primary, nqn)
+	}
+
+	names := make([]string, 0, len(targets))
+	for name := range targets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	original := map[string][]spdktypes.NvmfSubsystemListener{}
+	for _, name := range names {
+		listeners, err := targets[name].NvmfSubsystemGetListeners(nqn, "")
+		if err != nil {
+			return fmt.Errorf("failed to get listeners of subsystem with nqn %v on target %v: %w", nqn, name, err)
+		}
+		if len(listeners) == 0 {
+			return fmt.Errorf("subsystem with nqn %v has no listeners on target %v", nqn, name)
+		}
+		original[name] = listeners
+	}
+
+	standbys := []string{}
+	for _, name := range names {
+		if name != primary {
+			standbys = append(standbys, name)
+		}
+	}
+
+	changed := map[string]bool{}
+	defer func() {
+		if err != nil {
+			rollbackFailoverANA(nqn, primary, targets, standbys, original, changed)
+		}
+	}()
+
+	logrus.Infof("Failing over subsystem with nqn %v to target %v", nqn, primary)
+
+	if err := setTargetsANAState(nqn, targets, standbys, original, spdktypes.NvmfSubsystemListenerAnaStateInaccessible, changed); err != nil {
+		return err
+	}
+	if err := setTargetsANAState(nqn, targets, []string{primary}, original, spdktypes.NvmfSubsystemListenerAnaStateOptimized, changed); err != nil {
+		return err
+	}
+	if err := setTargetsANAState(nqn, targets, standbys, original, spdktypes.NvmfSubsystemListenerAnaStateNonOptimized, changed); err != nil {
+		return err
+	}
+
+	logrus.Infof("Failed over subsystem with nqn %v to target %v", nqn, primary)
+
+	return nil
+}
+
+// rollbackFailoverANA restores the ANA states of the changed targets without
+// two targets ever being optimized at the same time. It is best effort:
+// failures are logged and do not stop the remaining steps.
+func rollbackFailoverANA(nqn, primary string, targets map[string]*Client, standbys []string, original map[string][]spdktypes.NvmfSubsystemListener, changed map[string]bool) {
+	operation := fmt.Sprintf("ANA failover of subsystem with nqn %v to target %v", nqn, primary)
+	logrus.Warnf("Rolling back %v", operation)
+
+	set := func(name string, l spdktypes.NvmfSubsystemListener, anaState spdktypes.NvmfSubsystemListenerAnaState) {
+		if _, err := targets[name].NvmfSubsystemListenerSetANAState(nqn, l.Address.Traddr, l.Address.Trsvcid, l.Address.Trtype,
+			l.Address.Adrfam, anaState, spdktypes.DefaultNvmfANAGroupID); err != nil {
+			logrus.WithError(err).Warnf("Failed to roll back the ANA state of target %v in %v", name, operation)
+		}
+	}
+	restore := func(name string) {
+		for _, l := range original[name] {
+			if l.AnaState != "" {
+				set(name, l, l.AnaState)
+			}
+		}
+	}
+
+	changedStandbys := []string{}
+	for _, name := range standbys {
+		if changed[name] {
+			changedStandbys = append(changedStandbys, name)
+		}
+	}
+	for _, name := range changedStandbys {
+		for _, l := range original[name] {
+			set(name, l, spdktypes.NvmfSubsystemListenerAnaStateInaccessible)
+		}
+	}
+	if changed[primary] {
+		restore(primary)
+	}
+	for _, name := range changedStandbys {
+		restore(name)
+	}
+}
+
+// setTargetsANAState sets the ANA state of all listeners of the subsystem on
+// the named targets and then verifies the states the targets report. The
+// targets are marked in changed before their states are set.
+func setTargetsANAState(nqn string, targets map[string]*Client, names []string, original map[string][]spdktypes.NvmfSubsystemListener,
+	anaState spdktypes.NvmfSubsystemListenerAnaState, changed map[string]bool) error {
+	for _, name := range names {
+		c := targets[name]
+		changed[name] = true
+		for _, l := range original[name] {
+			logrus.Infof("Setting listener ANA state to %v for transport address %v, transport service id %v of subsystem with nqn %v on target %v",
+				anaState, l.Address.Traddr, l.Address.Trsvcid, nqn, name)
+			if _, err := c.NvmfSubsystemListenerSetANAState(nqn, l.Address.Traddr, l.Address.Trsvcid, l.Address.Trtype,
+				l.Address.Adrfam, anaState, spdktypes.DefaultNvmfANAGroupID); err != nil {
+				return fmt.Errorf("failed to set ANA state %v of subsystem with nqn %v on target %v: %w", anaState, nqn, name, err)
+			}
+		}
+	}
+
+	for _, name := range names {
+		if err := verifyTargetANAState(nqn, name, targets[name], original[name], anaState); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// verifyTargetANAState checks that every listener in expected is reported
+// with the given ANA state by the target.
+func verifyTargetANAState(nqn, name string, c *Client, expected []spdktypes.NvmfSubsystemListener, anaState spdktypes.NvmfSubsystemListenerAnaState) error {
+	current, err := c.NvmfSubsystemGetListeners(nqn, "")
+	if err != nil {
+		return fmt.Errorf("failed to get listeners of subsystem with nqn %v on target %v: %w", nqn, name, err)
+	}
+
+	for _, l := range expected {
+		got := currentNvmfListenerAnaState(current, l.Address)
+		if !strings.EqualFold(string(got), string(anaState)) {
+			return fmt.Errorf("listener with transport address %v, transport service id %v of subsystem with nqn %v on target %v reports ANA state %q, expected %q",
+				l.Address.Traddr, l.Address.Trsvcid, nqn, name, got, anaState)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,118 @@
+package client
+
+import (
+	"testing"
+
+	"github.com/longhorn/go-spdk-helper/pkg/jsonrpc"
+
+	spdktypes "github.com/longhorn/go-spdk-helper/pkg/spdk/types"
+)
+
+func TestFailoverANA(t *testing.T) {
+	addressA := map[string]interface{}{"trtype": "TCP", "adrfam": "IPv4", "traddr": "10.0.0.1", "trsvcid": "4420"}
+	addressB := map[string]interface{}{"trtype": "TCP", "adrfam": "IPv4", "traddr": "10.0.0.2", "trsvcid": "4420"}
+
+	getListenersStep := func(traddr string, anaState spdktypes.NvmfSubsystemListenerAnaState) jsonRPCScriptStep {
+		return jsonRPCScriptStep{
+			method: "nvmf_subsystem_get_listeners",
+			params: map[string]interface{}{"nqn": "nqn.test"},
+			result: []spdktypes.NvmfSubsystemListener{testListener("TCP", "IPv4", traddr, "4420", anaState)},
+		}
+	}
+	setANAStateStep := func(address map[string]interface{}, anaState string, responseError *jsonrpc.ResponseError) jsonRPCScriptStep {
+		return jsonRPCScriptStep{
+			method: "nvmf_subsystem_listener_set_ana_state",
+			params: map[string]interface{}{
+				"nqn":            "nqn.test",
+				"listen_address": address,
+				"ana_state":      anaState,
+				"anagrpid":       float64(spdktypes.DefaultNvmfANAGroupID),
+			},
+			result:        true,
+			responseError: responseError,
+		}
+	}
+
+	tests := []struct {
+		name    string
+		steps   []jsonRPCScriptStep
+		wantErr bool
+	}{
+		{
+			name: "moves states in a safe order",
+			steps: []jsonRPCScriptStep{
+				getListenersStep("10.0.0.1", spdktypes.NvmfSubsystemListenerAnaStateOptimized),
+				getListenersStep("10.0.0.2", spdktypes.NvmfSubsystemListenerAnaStateNonOptimized),
+				setANAStateStep(addressA, "inaccessible", nil),
+				getListenersStep("10.0.0.1", spdktypes.NvmfSubsystemListenerAnaStateInaccessible),
+				setANAStateStep(addressB, "optimized", nil),
+				getListenersStep("10.0.0.2", spdktypes.NvmfSubsystemListenerAnaStateOptimized),
+				setANAStateStep(addressA, "non_optimized", nil),
+				getListenersStep("10.0.0.1", spdktypes.NvmfSubsystemListenerAnaStateNonOptimized),
+			},
+		},
+		{
+			name: "rolls back when the primary cannot be promoted",
+			steps: []jsonRPCScriptStep{
+				getListenersStep("10.0.0.1", spdktypes.NvmfSubsystemListenerAnaStateOptimized),
+				getListenersStep("10.0.0.2", spdktypes.NvmfSubsystemListenerAnaStateNonOptimized),
+				setANAStateStep(addressA, "inaccessible", nil),
+				getListenersStep("10.0.0.1", spdktypes.NvmfSubsystemListenerAnaStateInaccessible),
+				setANAStateStep(addressB, "optimized", &jsonrpc.ResponseError{Code: jsonrpc.RespErrorCodeInternalError, Message: "Internal error"}),
+				setANAStateStep(addressA, "inaccessible", nil),
+				setANAStateStep(addressB, "non_optimized", nil),
+				setANAStateStep(addressA, "optimized", nil),
+			},
+			wantErr: true,
+		},
+		{
+			name: "demotes the new primary before restoring the old one",
+			steps: []jsonRPCScriptStep{
+				getListenersStep("10.0.0.1", spdktypes.NvmfSubsystemListenerAnaStateOptimized),
+				getListenersStep("10.0.0.2", spdktypes.NvmfSubsystemListenerAnaStateNonOptimized),
+				setANAStateStep(addressA, "inaccessible", nil),
+				getListenersStep("10.0.0.1", spdktypes.NvmfSubsystemListenerAnaStateInaccessible),
+				setANAStateStep(addressB, "optimized", nil),
+				getListenersStep("10.0.0.2", spdktypes.NvmfSubsystemListenerAnaStateOptimized),
+				setANAStateStep(addressA, "non_optimized", &jsonrpc.ResponseError{Code: jsonrpc.RespErrorCodeInternalError, Message: "Internal error"}),
+				setANAStateStep(addressA, "inaccessible", nil),
+				setANAStateStep(addressB, "non_optimized", nil),
+				setANAStateStep(addressA, "optimized", nil),
+			},
+			wantErr: true,
+		},
+		{
+			name: "rolls back when verification fails",
+			steps: []jsonRPCScriptStep{
+				getListenersStep("10.0.0.1", spdktypes.NvmfSubsystemListenerAnaStateOptimized),
+				getListenersStep("10.0.0.2", spdktypes.NvmfSubsystemListenerAnaStateNonOptimized),
+				setANAStateStep(addressA, "inaccessible", nil),
+				getListenersStep("10.0.0.1", spdktypes.NvmfSubsystemListenerAnaStateOptimized),
+				setANAStateStep(addressA, "inaccessible", nil),
+				setANAStateStep(addressA, "optimized", nil),
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			runJSONRPCScriptTest(t, test.steps, func(cli *Client) {
+				// Both targets share the scripted server; the calls are sequential.
+				err := FailoverANA("nqn.test", "b", map[string]*Client{"a": cli, "b": cli})
+				if test.wantErr && err == nil {
+					t.Fatal("FailoverANA unexpectedly succeeded")
+				}
+				if !test.wantErr && err != nil {
+					t.Fatalf("FailoverANA failed: %v", err)
+				}
+			})
+		})
+	}
+
+	t.Run("rejects an unknown primary", func(t *testing.T) {
+		if err := FailoverANA("nqn.test", "c", map[string]*Client{"a": nil, "b": nil}); err == nil {
+			t.Fatal("FailoverANA unexpectedly succeeded")
+		}
+	})
+}
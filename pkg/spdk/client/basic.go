@@ -445,6 +445,30 @@ func (c *Client) BdevLvolCloneBdev(bdev, lvsName, cloneName string) (uuid string
 	return uuid, json.Unmarshal(cmdOutput, &uuid)
 }
 
+// BdevLvolCloneEsnap creates a logical volume based on an external snapshot bdev referenced by its UUID.
+// The lvstore records the UUID instead of the bdev name, so the external snapshot is found again when the bdev
+// is recreated with another name, e.g. when the NVMe controller of a remote snapshot is reattached.
+//
+//	"bdev": Required. Name or UUID for bdev that acts as the external snapshot. The bdev must report a UUID.
+//
+//	"lvsName": Required. logical volume store name of the newly created lvol.
+//
+//	"cloneName": Required. name for the newly created lvol.
+func (c *Client) BdevLvolCloneEsnap(bdev, lvsName, cloneName string) (uuid string, err error) {
+	bdevs, err := c.BdevGetBdevs(bdev, 0)
+	if err != nil {
+		return "", err
+	}
+	if len(bdevs) != 1 {
+		return "", fmt.Errorf("cannot find external snapshot bdev %v", bdev)
+	}
+	if bdevs[0].UUID == "" {
+		return "", fmt.Errorf("external snapshot bdev %v does not report a UUID", bdev)
+	}
+
+	return c.BdevLvolCloneBdev(bdevs[0].UUID, lvsName, cloneName)
+}
+
 // BdevLvolDecoupleParent decouples the parent of a logical volume.
 // For unallocated clusters which is allocated in the parent, they are allocated and copied from the parent,
 // but for unallocated clusters which is thin provisioned in the parent, they are kept thin provisioned. Then all dependencies on the parent are removed.
@@ -481,6 +505,39 @@ func (c *Client) BdevLvolDetachParent(name string) (decoupled bool, err error) {
 	return decoupled, json.Unmarshal(cmdOutput, &decoupled)
 }
 
+// BdevLvolSetReadOnly marks a logical volume as read only.
+//
+//	"name": Required. UUID or alias of the logical volume to mark as read only. The alias of a lvol is <LVSTORE NAME>/<LVOL NAME>.
+func (c *Client) BdevLvolSetReadOnly(name string) (set bool, err error) {
+	req := spdktypes.BdevLvolSetReadOnlyRequest{
+		Name: name,
+	}
+
+	cmdOutput, err := c.jsonCli.SendCommand("bdev_lvol_set_read_only", req)
+	if err != nil {
+		return false, err
+	}
+
+	return set, json.Unmarshal(cmdOutput, &set)
+}
+
+// BdevLvolInflate inflates a logical volume.
+// All unallocated clusters are allocated and copied from the parent or zero filled if not allocated in the parent. Then all dependencies on the parent are removed.
+//
+//	"name": Required. UUID or alias of the logical volume to inflate. The alias of a lvol is <LVSTORE NAME>/<LVOL NAME>.
+func (c *Client) BdevLvolInflate(name string) (inflated bool, err error) {
+	req := spdktypes.BdevLvolInflateRequest{
+		Name: name,
+	}
+
+	cmdOutput, err := c.jsonCli.SendCommandWithLongTimeout("bdev_lvol_inflate", req)
+	if err != nil {
+		return false, err
+	}
+
+	return inflated, json.Unmarshal(cmdOutput, &inflated)
+}
+
 // BdevLvolSetParent sets a snapshot as the parent of a lvol, making the lvol a clone/child of this snapshot.
 // The previous parent of the lvol can be another snapshot or an external snapshot, if the lvol is not a clone must be thin-provisioned.
 // Lvol and parent snapshot must have the same size and must belong to the same lvol store.
@@ -1098,6 +1155,79 @@ func (c *Client) BdevNvmeGetControllerHealthInfo(name string) (healthInfo spdkty
 	return healthInfo, nil
 }
 
+// BdevNvmeSetMultipathPolicy sets the multipath policy of an NVMe bdev attached
+// with multipath enabled.
+//
+//	"name": Required. Name of the NVMe bdev. e.g., "Nvme0n1"
+//
+//	"policy": Required. Multipath policy: active_passive or active_active
+//
+//	"selector": Optional. Path selector of the active_active policy: round_robin or queue_depth
+//
+//	"rrMinIo": Optional. Number of I/Os sent on a path before switching to the next one with the round_robin selector. 0 means use default
+func (c *Client) BdevNvmeSetMultipathPolicy(name string, policy spdktypes.BdevNvmeMultipathPolicy, selector spdktypes.BdevNvmeMultipathSelector, rrMinIo uint32) (result bool, err error) {
+	req := spdktypes.BdevNvmeSetMultipathPolicyRequest{
+		Name:     name,
+		Policy:   policy,
+		Selector: selector,
+		RrMinIo:  rrMinIo,
+	}
+
+	cmdOutput, err := c.jsonCli.SendCommand("bdev_nvme_set_multipath_policy", req)
+	if err != nil {
+		return false, err
+	}
+
+	return result, json.Unmarshal(cmdOutput, &result)
+}
+
+// BdevNvmeGetIOPaths gets the I/O paths of NVMe bdevs, grouped by poll group.
+//
+//	"name": Optional. Name of the NVMe bdev. If this is not specified, the function will list the I/O paths of all NVMe bdevs.
+func (c *Client) BdevNvmeGetIOPaths(name string) (ioPaths spdktypes.BdevNvmeIOPaths, err error) {
+	req := spdktypes.BdevNvmeGetIOPathsRequest{
+		Name: name,
+	}
+
+	cmdOutput, err := c.jsonCli.SendCommand("bdev_nvme_get_io_paths", req)
+	if err != nil {
+		return ioPaths, err
+	}
+
+	return ioPaths, json.Unmarshal(cmdOutput, &ioPaths)
+}
+
+// BdevNvmeSetPreferredPath sets the path of an NVMe bdev that I/O is sent to
+// first with the active_passive policy.
+//
+//	"name": Required. Name of the NVMe bdev. e.g., "Nvme0n1"
+//
+//	"cntlid": Required. Controller ID of the preferred path. It is reported by BdevNvmeGetIOPaths
+func (c *Client) BdevNvmeSetPreferredPath(name string, cntlid uint16) (result bool, err error) {
+	req := spdktypes.BdevNvmeSetPreferredPathRequest{
+		Name:   name,
+		Cntlid: cntlid,
+	}
+
+	cmdOutput, err := c.jsonCli.SendCommand("bdev_nvme_set_preferred_path", req)
+	if err != nil {
+		return false, err
+	}
+
+	return result, json.Unmarshal(cmdOutput, &result)
+}
+
+// BdevNvmeGetTransportStatistics gets the transport statistics of all NVMe
+// bdev poll groups.
+func (c *Client) BdevNvmeGetTransportStatistics() (stats spdktypes.BdevNvmeTransportStatistics, err error) {
+	cmdOutput, err := c.jsonCli.SendCommand("bdev_nvme_get_transport_statistics", struct{}{})
+	if err != nil {
+		return stats, err
+	}
+
+	return stats, json.Unmarshal(cmdOutput, &stats)
+}
+
 // BdevNvmeSetOptions sets global parameters for all bdev NVMe.
 // This RPC may only be called before SPDK subsystems have been initialized or any bdev NVMe
 // has been created.
@@ -1264,6 +1394,44 @@ func (c *Client) NvmfSubsystemAddHost(nqn, hostNQN string) (added bool, err erro
 	return added, json.Unmarshal(cmdOutput, &added)
 }
 
+// NvmfSubsystemRemoveHost removes a host NQN from the allowed list of an NVMe-oF target subsystem.
+//
+//	"nqn": Required. Subsystem NQN.
+//
+//	"hostNQN": Required. The host NQN to remove from the allowed list.
+func (c *Client) NvmfSubsystemRemoveHost(nqn, hostNQN string) (removed bool, err error) {
+	req := spdktypes.NvmfSubsystemRemoveHostRequest{
+		Nqn:  nqn,
+		Host: hostNQN,
+	}
+
+	cmdOutput, err := c.jsonCli.SendCommand("nvmf_subsystem_remove_host", req)
+	if err != nil {
+		return false, err
+	}
+
+	return removed, json.Unmarshal(cmdOutput, &removed)
+}
+
+// NvmfSubsystemAllowAnyHost controls whether any host can connect to an NVMe-oF target subsystem.
+//
+//	"nqn": Required. Subsystem NQN.
+//
+//	"allowAnyHost": Required. If false, only host NQNs added via NvmfSubsystemAddHost can connect.
+func (c *Client) NvmfSubsystemAllowAnyHost(nqn string, allowAnyHost bool) (set bool, err error) {
+	req := spdktypes.NvmfSubsystemAllowAnyHostRequest{
+		Nqn:          nqn,
+		AllowAnyHost: allowAnyHost,
+	}
+
+	cmdOutput, err := c.jsonCli.SendCommand("nvmf_subsystem_allow_any_host", req)
+	if err != nil {
+		return false, err
+	}
+
+	return set, json.Unmarshal(cmdOutput, &set)
+}
+
 // NvmfDeleteSubsystem constructs an NVMe over Fabrics target subsystem..
 //
 //	"nqn": Required. Subsystem NQN.
@@ -36,6 +36,10 @@ func runJSONRPCRequestTest(t *testing.T, fn func(*Client) error, verify func(t *
 			serverErrCh <- err
 			return
 		}
+		if err := consumeRequestNewline(decoder, serverConn); err != nil {
+			serverErrCh <- err
+			return
+		}
 
 		var params map[string]interface{}
 		if msg.Params != nil {
@@ -72,11 +76,26 @@ func runJSONRPCRequestTest(t *testing.T, fn func(*Client) error, verify func(t *
 	}
 }
 
+// consumeRequestNewline reads the newline that the client encoder writes after
+// a request when the decoder returned before reading it. Writes on a net.Pipe
+// block until they are fully read, so the client could not send or dispatch
+// anything else until then. Reading from Buffered does not consume the data
+// buffered by the decoder.
+func consumeRequestNewline(decoder *json.Decoder, conn net.Conn) error {
+	if n, _ := decoder.Buffered().Read(make([]byte, 1)); n > 0 {
+		return nil
+	}
+	_, err := conn.Read(make([]byte, 1))
+	return err
+}
+
 type jsonRPCScriptStep struct {
 	method        string
 	params        map[string]interface{}
 	result        interface{}
 	responseError *jsonrpc.ResponseError
+	// served is closed once the response is sent, if it is set.
+	served chan struct{}
 }
 
 func runJSONRPCScriptTest(t *testing.T, steps []jsonRPCScriptStep, fn func(*Client)) {
@@ -109,6 +128,10 @@ func runJSONRPCScriptTest(t *testing.T, steps []jsonRPCScriptStep, fn func(*Clie
 				serverErrCh <- fmt.Errorf("step %d: failed to decode request: %w", i, err)
 				return
 			}
+			if err := consumeRequestNewline(decoder, serverConn); err != nil {
+				serverErrCh <- fmt.Errorf("step %d: failed to read request: %w", i, err)
+				return
+			}
 			if msg.Method != step.method {
 				serverErrCh <- fmt.Errorf("step %d: got method %q, want %q", i, msg.Method, step.method)
 				return
@@ -138,6 +161,9 @@ func runJSONRPCScriptTest(t *testing.T, steps []jsonRPCScriptStep, fn func(*Clie
 				serverErrCh <- fmt.Errorf("step %d: failed to encode response: %w", i, err)
 				return
 			}
+			if step.served != nil {
+				close(step.served)
+			}
 		}
 
 		scriptFailed = false
@@ -395,6 +421,142 @@ func TestBdevNvmeResetControllerSendsCorrectMethod(t *testing.T) {
 	)
 }
 
+func TestBdevNvmeMultipathRPCRequests(t *testing.T) {
+	cases := []struct {
+		name       string
+		method     string
+		call       func(*Client) error
+		expectKeys map[string]any
+		absentKeys []string
+		result     any
+	}{
+		{
+			name:   "BdevNvmeSetMultipathPolicy active_passive",
+			method: "bdev_nvme_set_multipath_policy",
+			call: func(cli *Client) error {
+				_, err := cli.BdevNvmeSetMultipathPolicy("Nvme0n1", spdktypes.BdevNvmeMultipathPolicyActivePassive, "", 0)
+				return err
+			},
+			expectKeys: map[string]any{"name": "Nvme0n1", "policy": "active_passive"},
+			absentKeys: []string{"selector", "rr_min_io"},
+			result:     true,
+		},
+		{
+			name:   "BdevNvmeSetMultipathPolicy active_active round_robin",
+			method: "bdev_nvme_set_multipath_policy",
+			call: func(cli *Client) error {
+				_, err := cli.BdevNvmeSetMultipathPolicy("Nvme0n1", spdktypes.BdevNvmeMultipathPolicyActiveActive,
+					spdktypes.BdevNvmeMultipathSelectorRoundRobin, 8)
+				return err
+			},
+			expectKeys: map[string]any{
+				"name":      "Nvme0n1",
+				"policy":    "active_active",
+				"selector":  "round_robin",
+				"rr_min_io": float64(8),
+			},
+			result: true,
+		},
+		{
+			name:   "BdevNvmeGetIOPaths without name",
+			method: "bdev_nvme_get_io_paths",
+			call: func(cli *Client) error {
+				_, err := cli.BdevNvmeGetIOPaths("")
+				return err
+			},
+			absentKeys: []string{"name"},
+			result:     map[string]any{"poll_groups": []any{}},
+		},
+		{
+			name:   "BdevNvmeSetPreferredPath",
+			method: "bdev_nvme_set_preferred_path",
+			call: func(cli *Client) error {
+				_, err := cli.BdevNvmeSetPreferredPath("Nvme0n1", 2)
+				return err
+			},
+			expectKeys: map[string]any{"name": "Nvme0n1", "cntlid": float64(2)},
+			result:     true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			runJSONRPCRequestTest(t,
+				tc.call,
+				func(t *testing.T, method string, params map[string]any) {
+					t.Helper()
+					if method != tc.method {
+						t.Fatalf("unexpected method %s, want %s", method, tc.method)
+					}
+					for k, want := range tc.expectKeys {
+						if got := params[k]; !reflect.DeepEqual(got, want) {
+							t.Fatalf("key %q: got %#v, want %#v", k, got, want)
+						}
+					}
+					for _, k := range tc.absentKeys {
+						if v, exists := params[k]; exists {
+							t.Fatalf("expected key %q absent, got %#v", k, v)
+						}
+					}
+				},
+				tc.result,
+			)
+		})
+	}
+}
+
+func TestBdevNvmeGetIOPathsDecodesPaths(t *testing.T) {
+	result := map[string]any{
+		"poll_groups": []any{
+			map[string]any{
+				"thread": "nvmf_tgt_poll_group_000",
+				"io_paths": []any{
+					map[string]any{
+						"bdev_name":  "Nvme0n1",
+						"cntlid":     1,
+						"current":    true,
+						"connected":  true,
+						"accessible": true,
+						"transport": map[string]any{
+							"trtype":  "TCP",
+							"adrfam":  "IPv4",
+							"traddr":  "10.0.0.1",
+							"trsvcid": "4420",
+							"subnqn":  "nqn.test",
+						},
+					},
+				},
+			},
+		},
+	}
+
+	var ioPaths spdktypes.BdevNvmeIOPaths
+	runJSONRPCRequestTest(t,
+		func(cli *Client) (err error) {
+			ioPaths, err = cli.BdevNvmeGetIOPaths("Nvme0n1")
+			return err
+		},
+		func(t *testing.T, method string, params map[string]any) {
+			t.Helper()
+			if params["name"] != "Nvme0n1" {
+				t.Fatalf("expected name Nvme0n1, got %#v", params["name"])
+			}
+		},
+		result,
+	)
+
+	if len(ioPaths.PollGroups) != 1 || len(ioPaths.PollGroups[0].IOPaths) != 1 {
+		t.Fatalf("unexpected I/O paths %+v", ioPaths)
+	}
+	path := ioPaths.PollGroups[0].IOPaths[0]
+	if path.BdevName != "Nvme0n1" || path.Cntlid != 1 || !path.Current || !path.Connected || !path.Accessible {
+		t.Fatalf("unexpected I/O path %+v", path)
+	}
+	if path.Transport.Traddr != "10.0.0.1" || path.Transport.Subnqn != "nqn.test" {
+		t.Fatalf("unexpected I/O path transport %+v", path.Transport)
+	}
+}
+
 func TestBdevLvolGrowLvstoreRPCRequests(t *testing.T) {
 	cases := []struct {
 		name       string
@@ -515,3 +677,140 @@ func TestBdevLvolCreateLvstoreRPCRequests(t *testing.T) {
 		})
 	}
 }
+
+func TestBdevLvolReadOnlyInflateRPCRequests(t *testing.T) {
+	cases := []struct {
+		name   string
+		method string
+		call   func(*Client) error
+	}{
+		{
+			name:   "BdevLvolSetReadOnly",
+			method: "bdev_lvol_set_read_only",
+			call: func(cli *Client) error {
+				_, err := cli.BdevLvolSetReadOnly("lvs0/vol")
+				return err
+			},
+		},
+		{
+			name:   "BdevLvolInflate",
+			method: "bdev_lvol_inflate",
+			call: func(cli *Client) error {
+				_, err := cli.BdevLvolInflate("lvs0/vol")
+				return err
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			runJSONRPCRequestTest(t,
+				tc.call,
+				func(t *testing.T, method string, params map[string]any) {
+					t.Helper()
+					if method != tc.method {
+						t.Fatalf("unexpected method %s, want %s", method, tc.method)
+					}
+					if want := map[string]any{"name": "lvs0/vol"}; !reflect.DeepEqual(params, want) {
+						t.Fatalf("got params %#v, want %#v", params, want)
+					}
+				},
+				true,
+			)
+		})
+	}
+}
+
+func TestBdevLvolCloneEsnapUsesBdevUUID(t *testing.T) {
+	esnap := spdktypes.BdevInfo{
+		BdevInfoBasic: spdktypes.BdevInfoBasic{
+			Name:        "remote-snapn1",
+			UUID:        "5c6e2e2a-8a0d-4d3c-9d9e-0d4b7d7a1c11",
+			ProductName: spdktypes.BdevProductNameNvme,
+		},
+	}
+	steps := []jsonRPCScriptStep{
+		{
+			method: "bdev_get_bdevs",
+			params: map[string]interface{}{"name": "remote-snapn1"},
+			result: []spdktypes.BdevInfo{esnap},
+		},
+		{
+			method: "bdev_lvol_clone_bdev",
+			params: map[string]interface{}{"bdev": esnap.UUID, "lvs_name": "lvs0", "clone_name": "rebuilt"},
+			result: "clone-uuid",
+		},
+		{
+			method: "bdev_get_bdevs",
+			params: map[string]interface{}{"name": "no-uuid"},
+			result: []spdktypes.BdevInfo{{BdevInfoBasic: spdktypes.BdevInfoBasic{Name: "no-uuid"}}},
+		},
+	}
+
+	runJSONRPCScriptTest(t, steps, func(cli *Client) {
+		uuid, err := cli.BdevLvolCloneEsnap("remote-snapn1", "lvs0", "rebuilt")
+		if err != nil {
+			t.Fatalf("failed to clone esnap: %v", err)
+		}
+		if uuid != "clone-uuid" {
+			t.Fatalf("got uuid %v, want clone-uuid", uuid)
+		}
+
+		if _, err := cli.BdevLvolCloneEsnap("no-uuid", "lvs0", "rebuilt"); err == nil {
+			t.Fatal("expected an error for a bdev without UUID")
+		}
+	})
+}
+
+func TestBdevLvolEsnapCloneInfo(t *testing.T) {
+	steps := []jsonRPCScriptStep{
+		{
+			method: "bdev_lvol_get_lvols",
+			params: map[string]interface{}{"lvs_name": "lvs0"},
+			result: []map[string]any{{
+				"alias":                  "lvs0/rebuilt",
+				"uuid":                   "clone-uuid",
+				"name":                   "rebuilt",
+				"is_esnap_clone":         true,
+				"is_degraded":            true,
+				"external_snapshot_name": "5c6e2e2a-8a0d-4d3c-9d9e-0d4b7d7a1c11",
+			}},
+		},
+		{
+			method: "bdev_get_bdevs",
+			params: map[string]interface{}{"name": "clone-uuid"},
+			result: []map[string]any{{
+				"name":         "clone-uuid",
+				"product_name": spdktypes.BdevProductNameLvol,
+				"driver_specific": map[string]any{"lvol": map[string]any{
+					"esnap_clone":            true,
+					"external_snapshot_name": "5c6e2e2a-8a0d-4d3c-9d9e-0d4b7d7a1c11",
+					"degraded":               true,
+				}},
+			}},
+		},
+	}
+
+	runJSONRPCScriptTest(t, steps, func(cli *Client) {
+		lvols, err := cli.BdevLvolGetLvols("lvs0", "")
+		if err != nil {
+			t.Fatalf("failed to get lvols: %v", err)
+		}
+		if len(lvols) != 1 || !lvols[0].IsEsnapClone || !lvols[0].IsDegraded ||
+			lvols[0].ExternalSnapshotName != "5c6e2e2a-8a0d-4d3c-9d9e-0d4b7d7a1c11" {
+			t.Fatalf("unexpected lvols %+v", lvols)
+		}
+
+		bdevs, err := cli.BdevGetBdevs("clone-uuid", 0)
+		if err != nil {
+			t.Fatalf("failed to get bdevs: %v", err)
+		}
+		if len(bdevs) != 1 || bdevs[0].DriverSpecific == nil || bdevs[0].DriverSpecific.Lvol == nil {
+			t.Fatalf("unexpected bdevs %+v", bdevs)
+		}
+		if lvol := bdevs[0].DriverSpecific.Lvol; !lvol.EsnapClone || !lvol.Degraded ||
+			lvol.ExternalSnapshotName != "5c6e2e2a-8a0d-4d3c-9d9e-0d4b7d7a1c11" {
+			t.Fatalf("unexpected lvol driver specific info %+v", lvol)
+		}
+	})
+}
@@ -0,0 +1,524 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/longhorn/go-spdk-helper/pkg/jsonrpc"
+	spdktypes "github.com/longhorn/go-spdk-helper/pkg/spdk/types"
+)
+
+const (
+	// SnapshotChecksumResult is the xattr in which the ChecksumScheduler
+	// persists the result of a checksum job.
+	SnapshotChecksumResult = "snapshot_checksum_result"
+
+	DefaultChecksumConcurrency         = 2
+	DefaultChecksumCheckInterval       = 3 * time.Second
+	DefaultChecksumMaxRetries          = 3
+	DefaultChecksumRegistrationTimeout = time.Hour
+)
+
+type ChecksumJobState string
+
+const (
+	ChecksumJobStateQueued   = ChecksumJobState("queued")
+	ChecksumJobStateRunning  = ChecksumJobState("running")
+	ChecksumJobStateComplete = ChecksumJobState("complete")
+	ChecksumJobStateError    = ChecksumJobState("error")
+	ChecksumJobStateCanceled = ChecksumJobState("canceled")
+)
+
+// ChecksumJob is the state of the checksum registration of a snapshot.
+type ChecksumJob struct {
+	// Snapshot is the alias of the snapshot, <LVSTORE NAME>/<SNAPSHOT NAME>.
+	Snapshot string           `json:"snapshot"`
+	LvsName  string           `json:"lvs_name"`
+	State    ChecksumJobState `json:"state"`
+	Checksum string           `json:"checksum,omitempty"`
+	Attempts int              `json:"attempts"`
+	Error    string           `json:"error,omitempty"`
+
+	QueuedAt    time.Time `json:"queued_at"`
+	StartedAt   time.Time `json:"started_at,omitempty"`
+	CompletedAt time.Time `json:"completed_at,omitempty"`
+}
+
+// Done reports whether the job finished, successfully or not.
+func (j ChecksumJob) Done() bool {
+	return j.State == ChecksumJobStateComplete || j.State == ChecksumJobStateError || j.State == ChecksumJobStateCanceled
+}
+
+type ChecksumSchedulerOptions struct {
+	// Concurrency is the maximum number of registrations running at once
+	// across all lvstores. DefaultChecksumConcurrency is used if it is 0.
+	Concurrency int
+	// CheckInterval is the interval between checksum polls and between
+	// retries. DefaultChecksumCheckInterval is used if it is 0.
+	CheckInterval time.Duration
+	// MaxRetries is the number of consecutive transient errors after which a
+	// job fails. DefaultChecksumMaxRetries is used if it is 0.
+	MaxRetries int
+	// RegistrationTimeout is how long the checksum of a registration is
+	// polled before the registration fails with a transient error.
+	// DefaultChecksumRegistrationTimeout is used if it is 0.
+	RegistrationTimeout time.Duration
+	// OnUpdate is called whenever the state of a job changes. It is optional
+	// and is called from the scheduler goroutines with the scheduler locked,
+	// so it must be safe for concurrent use and must not call the scheduler.
+	OnUpdate func(ChecksumJob)
+}
+
+// checksumRecord is the value of the SnapshotChecksumResult xattr.
+type checksumRecord struct {
+	Checksum    string    `json:"checksum"`
+	CompletedAt time.Time `json:"completed_at"`
+}
+
+type checksumJob struct {
+	ChecksumJob
+	canceled bool
+	// stop is closed when a running job is canceled.
+	stop chan struct{}
+	done chan struct{}
+}
+
+// ChecksumScheduler queues snapshot checksum registrations and runs a limited
+// number of them at once. Queued jobs are picked round-robin across lvstores
+// so that a long queue on one lvstore does not starve the others. Results are
+// persisted in the SnapshotChecksumResult xattr of the snapshot, and a
+// snapshot with a persisted result is not registered again unless forced.
+type ChecksumScheduler struct {
+	cli  *Client
+	opts ChecksumSchedulerOptions
+	now  func() time.Time
+
+	lock    sync.Mutex
+	jobs    map[string]*checksumJob
+	queues  map[string][]*checksumJob
+	lvsRing []string
+	running int
+	wakeup  chan struct{}
+}
+
+func NewChecksumScheduler(cli *Client, opts ChecksumSchedulerOptions) *ChecksumScheduler {
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = DefaultChecksumConcurrency
+	}
+	if opts.CheckInterval == 0 {
+		opts.CheckInterval = DefaultChecksumCheckInterval
+	}
+	if opts.MaxRetries <= 0 {
+		opts.MaxRetries = DefaultChecksumMaxRetries
+	}
+	if opts.RegistrationTimeout == 0 {
+		opts.RegistrationTimeout = DefaultChecksumRegistrationTimeout
+	}
+	return &ChecksumScheduler{
+		cli:    cli,
+		opts:   opts,
+		now:    time.Now,
+		jobs:   map[string]*checksumJob{},
+		queues: map[string][]*checksumJob{},
+		wakeup: make(chan struct{}, 1),
+	}
+}
+
+// Run starts the queued jobs until the context is done. Running jobs are
+// stopped with the context.
+func (s *ChecksumScheduler) Run(ctx context.Context) {
+	for {
+		s.dispatch(ctx)
+
+		select {
+		case <-s.wakeup:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Enqueue queues the checksum registration of a snapshot given by UUID or
+// alias. A snapshot that is already queued or running is not queued again. A
+// snapshot whose result is persisted completes immediately with the persisted
+// checksum, unless force is set.
+func (s *ChecksumScheduler) Enqueue(snapshot string, force bool) (ChecksumJob, error) {
+	alias, err := s.resolveAlias(snapshot)
+	if err != nil {
+		return ChecksumJob{}, err
+	}
+
+	var record *checksumRecord
+	if !force {
+		if record, err = s.getRecord(alias); err != nil {
+			return ChecksumJob{}, err
+		}
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if job, ok := s.jobs[alias]; ok && !job.Done() {
+		return job.ChecksumJob, nil
+	}
+
+	job := &checksumJob{
+		ChecksumJob: ChecksumJob{
+			Snapshot: alias,
+			LvsName:  spdktypes.GetLvsNameFromAlias(alias),
+			State:    ChecksumJobStateQueued,
+			QueuedAt: s.now(),
+		},
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	s.jobs[alias] = job
+
+	if record != nil {
+		job.State = ChecksumJobStateComplete
+		job.Checksum = record.Checksum
+		job.CompletedAt = record.CompletedAt
+		close(job.done)
+		s.notify(job.ChecksumJob)
+		return job.ChecksumJob, nil
+	}
+
+	if len(s.queues[job.LvsName]) == 0 {
+		s.lvsRing = append(s.lvsRing, job.LvsName)
+	}
+	s.queues[job.LvsName] = append(s.queues[job.LvsName], job)
+	s.notify(job.ChecksumJob)
+	s.wake()
+
+	return job.ChecksumJob, nil
+}
+
+// Cancel removes a queued job from the queue, or stops a running one with
+// BdevLvolStopSnapshotChecksum. The snapshot is given by UUID or alias.
+func (s *ChecksumScheduler) Cancel(snapshot string) error {
+	snapshot, err := s.resolveAlias(snapshot)
+	if err != nil {
+		return err
+	}
+
+	s.lock.Lock()
+	job, ok := s.jobs[snapshot]
+	if !ok || job.Done() {
+		s.lock.Unlock()
+		return nil
+	}
+	if !job.canceled {
+		job.canceled = true
+		close(job.stop)
+	}
+	state := job.State
+	if state == ChecksumJobStateQueued {
+		s.removeQueued(job)
+		s.finish(job, ChecksumJobStateCanceled, "", "")
+	}
+	s.lock.Unlock()
+
+	if state != ChecksumJobStateRunning {
+		return nil
+	}
+	if _, err := s.cli.BdevLvolStopSnapshotChecksum(snapshot); err != nil && !jsonrpc.IsJSONRPCRespErrorNoSuchDevice(err) {
+		return err
+	}
+	return nil
+}
+
+// Get returns the job of a snapshot given by UUID or alias.
+func (s *ChecksumScheduler) Get(snapshot string) (ChecksumJob, bool, error) {
+	snapshot, err := s.resolveAlias(snapshot)
+	if err != nil {
+		return ChecksumJob{}, false, err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	job, ok := s.jobs[snapshot]
+	if !ok {
+		return ChecksumJob{}, false, nil
+	}
+	return job.ChecksumJob, true, nil
+}
+
+// List returns all jobs.
+func (s *ChecksumScheduler) List() []ChecksumJob {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	list := make([]ChecksumJob, 0, len(s.jobs))
+	for _, job := range s.jobs {
+		list = append(list, job.ChecksumJob)
+	}
+	return list
+}
+
+// Wait waits until the job of a snapshot given by UUID or alias finishes or
+// the context is done. It returns an error if the job failed or was canceled.
+func (s *ChecksumScheduler) Wait(ctx context.Context, snapshot string) (ChecksumJob, error) {
+	snapshot, err := s.resolveAlias(snapshot)
+	if err != nil {
+		return ChecksumJob{}, err
+	}
+
+	s.lock.Lock()
+	job, ok := s.jobs[snapshot]
+	s.lock.Unlock()
+	if !ok {
+		return ChecksumJob{}, fmt.Errorf("cannot find checksum job of snapshot %v", snapshot)
+	}
+
+	select {
+	case <-job.done:
+	case <-ctx.Done():
+		return s.snapshotOf(job), ctx.Err()
+	}
+
+	j := s.snapshotOf(job)
+	switch j.State {
+	case ChecksumJobStateError:
+		return j, fmt.Errorf("failed to register checksum of snapshot %v: %v", snapshot, j.Error)
+	case ChecksumJobStateCanceled:
+		return j, fmt.Errorf("checksum registration of snapshot %v was canceled", snapshot)
+	}
+	return j, nil
+}
+
+func (s *ChecksumScheduler) snapshotOf(job *checksumJob) ChecksumJob {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return job.ChecksumJob
+}
+
+func (s *ChecksumScheduler) resolveAlias(snapshot string) (string, error) {
+	if strings.Contains(snapshot, "/") {
+		return snapshot, nil
+	}
+
+	bdevs, err := s.cli.BdevGetBdevs(snapshot, 0)
+	if err != nil {
+		return "", err
+	}
+	if len(bdevs) != 1 || len(bdevs[0].Aliases) == 0 || spdktypes.GetBdevType(&bdevs[0]) != spdktypes.BdevTypeLvol {
+		return "", fmt.Errorf("cannot find snapshot %v", snapshot)
+	}
+	if !bdevs[0].DriverSpecific.Lvol.Snapshot {
+		return "", fmt.Errorf("lvol %v is not a snapshot", snapshot)
+	}
+	return bdevs[0].Aliases[0], nil
+}
+
+func (s *ChecksumScheduler) getRecord(snapshot string) (*checksumRecord, error) {
+	value, err := s.cli.BdevLvolGetXattr(snapshot, SnapshotChecksumResult)
+	if err != nil {
+		if jsonrpc.IsJSONRPCRespErrorNoSuchFileOrDirectory(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	record := &checksumRecord{}
+	if err := json.Unmarshal([]byte(value), record); err != nil || record.Checksum == "" {
+		logrus.WithError(err).Warnf("Ignoring invalid checksum result %q of snapshot %v", value, snapshot)
+		return nil, nil
+	}
+	return record, nil
+}
+
+// dispatch starts queued jobs, one lvstore after the other, until the
+// concurrency limit is reached.
+func (s *ChecksumScheduler) dispatch(ctx context.Context) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for s.running < s.opts.Concurrency && len(s.lvsRing) > 0 {
+		lvsName := s.lvsRing[0]
+		s.lvsRing = s.lvsRing[1:]
+
+		queue := s.queues[lvsName]
+		job := queue[0]
+		if len(queue) > 1 {
+			s.queues[lvsName] = queue[1:]
+			s.lvsRing = append(s.lvsRing, lvsName)
+		} else {
+			delete(s.queues, lvsName)
+		}
+
+		job.State = ChecksumJobStateRunning
+		job.StartedAt = s.now()
+		s.running++
+		s.notify(job.ChecksumJob)
+
+		go s.run(ctx, job)
+	}
+}
+
+func (s *ChecksumScheduler) removeQueued(job *checksumJob) {
+	queue := s.queues[job.LvsName]
+	for i := range queue {
+		if queue[i] == job {
+			queue = append(queue[:i], queue[i+1:]...)
+			break
+		}
+	}
+	if len(queue) > 0 {
+		s.queues[job.LvsName] = queue
+		return
+	}
+
+	delete(s.queues, job.LvsName)
+	for i, lvsName := range s.lvsRing {
+		if lvsName == job.LvsName {
+			s.lvsRing = append(s.lvsRing[:i], s.lvsRing[i+1:]...)
+			break
+		}
+	}
+}
+
+func (s *ChecksumScheduler) run(ctx context.Context, job *checksumJob) {
+	snapshot := job.Snapshot
+
+	ticker := time.NewTicker(s.opts.CheckInterval)
+	defer ticker.Stop()
+
+	failures := 0
+	for {
+		s.lock.Lock()
+		canceled := job.canceled
+		job.Attempts++
+		s.lock.Unlock()
+		if canceled {
+			s.complete(job, ChecksumJobStateCanceled, "", "")
+			return
+		}
+
+		checksum, permanent, err := s.register(ctx, job, ticker)
+		if err == nil {
+			s.complete(job, ChecksumJobStateComplete, checksum, "")
+			return
+		}
+
+		s.lock.Lock()
+		canceled = job.canceled
+		s.lock.Unlock()
+		switch {
+		case canceled:
+			s.complete(job, ChecksumJobStateCanceled, "", "")
+			return
+		case ctx.Err() != nil:
+			s.complete(job, ChecksumJobStateError, "", ctx.Err().Error())
+			return
+		}
+
+		failures++
+		if permanent || failures >= s.opts.MaxRetries {
+			s.complete(job, ChecksumJobStateError, "", err.Error())
+			return
+		}
+		logrus.WithError(err).Warnf("Failed to register checksum of snapshot %v, attempt %d", snapshot, failures)
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			s.complete(job, ChecksumJobStateError, "", ctx.Err().Error())
+			return
+		}
+	}
+}
+
+// register registers the checksum of the snapshot of the job, polls until it
+// is available and persists it. It returns as soon as the job is canceled. A
+// missing snapshot, including one deleted while it is polled, is a permanent
+// error, and a checksum still not available after RegistrationTimeout is a
+// transient one.
+func (s *ChecksumScheduler) register(ctx context.Context, job *checksumJob, ticker *time.Ticker) (checksum string, permanent bool, err error) {
+	snapshot := job.Snapshot
+	if _, err := s.cli.BdevLvolRegisterSnapshotChecksum(snapshot); err != nil {
+		return "", jsonrpc.IsJSONRPCRespErrorNoSuchDevice(err), err
+	}
+
+	deadline := s.now().Add(s.opts.RegistrationTimeout)
+	for {
+		s.lock.Lock()
+		canceled := job.canceled
+		s.lock.Unlock()
+		if canceled {
+			return "", false, fmt.Errorf("checksum registration of snapshot %v was canceled", snapshot)
+		}
+
+		checksum, err = s.cli.BdevLvolGetSnapshotChecksum(snapshot)
+		if err == nil {
+			break
+		}
+		if !jsonrpc.IsJSONRPCRespErrorNoSuchDevice(err) {
+			return "", false, err
+		}
+		// The checksum is not available until the registration completes,
+		// which SPDK reports like a missing snapshot.
+		if _, err := s.cli.BdevGetBdevs(snapshot, 0); err != nil {
+			if jsonrpc.IsJSONRPCRespErrorNoSuchDevice(err) {
+				return "", true, fmt.Errorf("snapshot %v was deleted during the checksum registration", snapshot)
+			}
+			return "", false, err
+		}
+		if !s.now().Before(deadline) {
+			return "", false, fmt.Errorf("checksum of snapshot %v is not available after %v", snapshot, s.opts.RegistrationTimeout)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-job.stop:
+		case <-ctx.Done():
+			return "", false, ctx.Err()
+		}
+	}
+
+	record, err := json.Marshal(checksumRecord{Checksum: checksum, CompletedAt: s.now().UTC()})
+	if err != nil {
+		return "", true, err
+	}
+	if _, err := s.cli.BdevLvolSetXattr(snapshot, SnapshotChecksumResult, string(record)); err != nil {
+		return "", false, err
+	}
+	return checksum, false, nil
+}
+
+func (s *ChecksumScheduler) complete(job *checksumJob, state ChecksumJobState, checksum, errMsg string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.running--
+	s.finish(job, state, checksum, errMsg)
+	s.wake()
+}
+
+func (s *ChecksumScheduler) finish(job *checksumJob, state ChecksumJobState, checksum, errMsg string) {
+	job.State = state
+	job.Checksum = checksum
+	job.Error = errMsg
+	job.CompletedAt = s.now()
+	close(job.done)
+	s.notify(job.ChecksumJob)
+}
+
+func (s *ChecksumScheduler) wake() {
+	select {
+	case s.wakeup <- struct{}{}:
+	default:
+	}
+}
+
+func (s *ChecksumScheduler) notify(job ChecksumJob) {
+	if s.opts.OnUpdate != nil {
+		s.opts.OnUpdate(job)
+	}
+}
@@ -0,0 +1,254 @@
+package client
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/longhorn/go-spdk-helper/pkg/jsonrpc"
+	spdktypes "github.com/longhorn/go-spdk-helper/pkg/spdk/types"
+)
+
+func checksumResultXattrStep(snapshot, result string, responseError *jsonrpc.ResponseError) jsonRPCScriptStep {
+	return getXattrStep(snapshot, SnapshotChecksumResult, result, responseError)
+}
+
+func registerSnapshotChecksumStep(snapshot string, responseError *jsonrpc.ResponseError) jsonRPCScriptStep {
+	return jsonRPCScriptStep{
+		method:        "bdev_lvol_register_snapshot_checksum",
+		params:        map[string]interface{}{"name": snapshot},
+		result:        true,
+		responseError: responseError,
+	}
+}
+
+func setChecksumResultStep(snapshot, record string) jsonRPCScriptStep {
+	return jsonRPCScriptStep{
+		method: "bdev_lvol_set_xattr",
+		params: map[string]interface{}{"name": snapshot, "xattr_name": SnapshotChecksumResult, "xattr_value": record},
+		result: true,
+	}
+}
+
+func snapshotExistsStep(snapshot string, responseError *jsonrpc.ResponseError) jsonRPCScriptStep {
+	return jsonRPCScriptStep{
+		method:        "bdev_get_bdevs",
+		params:        map[string]interface{}{"name": snapshot},
+		result:        []spdktypes.BdevInfo{testLvol(snapshot, true)},
+		responseError: responseError,
+	}
+}
+
+func TestChecksumScheduler(t *testing.T) {
+	noXattr := &jsonrpc.ResponseError{Code: jsonrpc.RespErrorCodeInternalError, Message: jsonrpc.RespErrorMsgNoSuchFileOrDirectory}
+	noDevice := &jsonrpc.ResponseError{Code: jsonrpc.RespErrorCodeNoSuchDevice, Message: "No such device"}
+	transient := &jsonrpc.ResponseError{Code: jsonrpc.RespErrorCodeInternalError, Message: "Input/output error"}
+
+	now := time.Date(2026, 7, 16, 4, 13, 30, 0, time.UTC)
+	record := func(checksum string) string {
+		return `{"checksum":"` + checksum + `","completed_at":"2026-07-16T04:13:30Z"}`
+	}
+
+	steps := []jsonRPCScriptStep{
+		checksumResultXattrStep("lvs0/a", "", noXattr),
+		checksumResultXattrStep("lvs0/b", "", noXattr),
+		checksumResultXattrStep("lvs1/c", "", noXattr),
+		checksumResultXattrStep("lvs1/d", record("4"), nil),
+
+		// a is retried after a transient error and polled until the checksum is available.
+		registerSnapshotChecksumStep("lvs0/a", transient),
+		registerSnapshotChecksumStep("lvs0/a", nil),
+		getSnapshotChecksumStep("lvs0/a", noDevice),
+		snapshotExistsStep("lvs0/a", nil),
+		{method: "bdev_lvol_get_snapshot_checksum", params: map[string]interface{}{"name": "lvs0/a"}, result: spdktypes.BdevLvolSnapshotChecksum{Checksum: 1}},
+		setChecksumResultStep("lvs0/a", record("1")),
+
+		// c of lvs1 runs before b, the second job of lvs0.
+		registerSnapshotChecksumStep("lvs1/c", nil),
+		{method: "bdev_lvol_get_snapshot_checksum", params: map[string]interface{}{"name": "lvs1/c"}, result: spdktypes.BdevLvolSnapshotChecksum{Checksum: 3}},
+		setChecksumResultStep("lvs1/c", record("3")),
+
+		// A missing snapshot is not retried.
+		registerSnapshotChecksumStep("lvs0/b", noDevice),
+	}
+
+	runJSONRPCScriptTest(t, steps, func(cli *Client) {
+		s := NewChecksumScheduler(cli, ChecksumSchedulerOptions{Concurrency: 1, CheckInterval: time.Millisecond})
+		s.now = func() time.Time { return now }
+
+		for _, snapshot := range []string{"lvs0/a", "lvs0/b", "lvs1/c"} {
+			job, err := s.Enqueue(snapshot, false)
+			if err != nil {
+				t.Fatalf("failed to enqueue %v: %v", snapshot, err)
+			}
+			if job.State != ChecksumJobStateQueued || job.LvsName != strings.Split(snapshot, "/")[0] {
+				t.Fatalf("unexpected job %+v", job)
+			}
+		}
+		persisted, err := s.Enqueue("lvs1/d", false)
+		if err != nil {
+			t.Fatalf("failed to enqueue lvs1/d: %v", err)
+		}
+		if persisted.State != ChecksumJobStateComplete || persisted.Checksum != "4" {
+			t.Fatalf("expected the persisted result to be used, got %+v", persisted)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		go s.Run(ctx)
+
+		for snapshot, checksum := range map[string]string{"lvs0/a": "1", "lvs1/c": "3"} {
+			job, err := s.Wait(ctx, snapshot)
+			if err != nil {
+				t.Fatalf("failed to wait for %v: %v", snapshot, err)
+			}
+			if job.Checksum != checksum {
+				t.Fatalf("got checksum %v of %v, want %v", job.Checksum, snapshot, checksum)
+			}
+		}
+
+		job, err := s.Wait(ctx, "lvs0/b")
+		if err == nil || job.State != ChecksumJobStateError || job.Attempts != 1 {
+			t.Fatalf("expected lvs0/b to fail after one attempt, got %+v, %v", job, err)
+		}
+		if a, _, _ := s.Get("lvs0/a"); a.Attempts != 2 {
+			t.Fatalf("expected lvs0/a to be attempted twice, got %d", a.Attempts)
+		}
+	})
+}
+
+func TestChecksumSchedulerCancelQueued(t *testing.T) {
+	noXattr := &jsonrpc.ResponseError{Code: jsonrpc.RespErrorCodeInternalError, Message: jsonrpc.RespErrorMsgNoSuchFileOrDirectory}
+
+	runJSONRPCScriptTest(t, []jsonRPCScriptStep{checksumResultXattrStep("lvs0/a", "", noXattr)}, func(cli *Client) {
+		s := NewChecksumScheduler(cli, ChecksumSchedulerOptions{})
+		if _, err := s.Enqueue("lvs0/a", false); err != nil {
+			t.Fatalf("failed to enqueue: %v", err)
+		}
+		if err := s.Cancel("lvs0/a"); err != nil {
+			t.Fatalf("failed to cancel: %v", err)
+		}
+
+		job, err := s.Wait(context.Background(), "lvs0/a")
+		if err == nil || job.State != ChecksumJobStateCanceled {
+			t.Fatalf("expected the job to be canceled, got %+v, %v", job, err)
+		}
+		if len(s.lvsRing) != 0 || len(s.queues) != 0 {
+			t.Fatalf("expected the queue to be empty, got %v %v", s.lvsRing, s.queues)
+		}
+	})
+}
+
+func TestChecksumSchedulerResolvesUUID(t *testing.T) {
+	noXattr := &jsonrpc.ResponseError{Code: jsonrpc.RespErrorCodeInternalError, Message: jsonrpc.RespErrorMsgNoSuchFileOrDirectory}
+
+	snapshot := testLvol("uuid-a", true)
+	snapshot.Aliases = []string{"lvs0/a"}
+	resolveStep := jsonRPCScriptStep{
+		method: "bdev_get_bdevs",
+		params: map[string]interface{}{"name": "uuid-a"},
+		result: []spdktypes.BdevInfo{snapshot},
+	}
+
+	steps := []jsonRPCScriptStep{
+		resolveStep, checksumResultXattrStep("lvs0/a", "", noXattr),
+		resolveStep, resolveStep, resolveStep,
+	}
+	runJSONRPCScriptTest(t, steps, func(cli *Client) {
+		s := NewChecksumScheduler(cli, ChecksumSchedulerOptions{})
+		if _, err := s.Enqueue("uuid-a", false); err != nil {
+			t.Fatalf("failed to enqueue: %v", err)
+		}
+		if job, ok, err := s.Get("uuid-a"); err != nil || !ok || job.Snapshot != "lvs0/a" {
+			t.Fatalf("expected the job of lvs0/a, got %+v, %v, %v", job, ok, err)
+		}
+		if err := s.Cancel("uuid-a"); err != nil {
+			t.Fatalf("failed to cancel: %v", err)
+		}
+		if job, err := s.Wait(context.Background(), "uuid-a"); err == nil || job.State != ChecksumJobStateCanceled {
+			t.Fatalf("expected the job to be canceled, got %+v, %v", job, err)
+		}
+	})
+}
+
+func TestChecksumSchedulerStopsPolling(t *testing.T) {
+	noXattr := &jsonrpc.ResponseError{Code: jsonrpc.RespErrorCodeInternalError, Message: jsonrpc.RespErrorMsgNoSuchFileOrDirectory}
+	noDevice := &jsonrpc.ResponseError{Code: jsonrpc.RespErrorCodeNoSuchDevice, Message: "No such device"}
+
+	run := func(t *testing.T, steps []jsonRPCScriptStep, opts ChecksumSchedulerOptions, fn func(s *ChecksumScheduler, ctx context.Context)) {
+		runJSONRPCScriptTest(t, steps, func(cli *Client) {
+			s := NewChecksumScheduler(cli, opts)
+			// Every call to now is a minute later.
+			var lock sync.Mutex
+			now := time.Date(2026, 7, 16, 4, 13, 30, 0, time.UTC)
+			s.now = func() time.Time {
+				lock.Lock()
+				defer lock.Unlock()
+				now = now.Add(time.Minute)
+				return now
+			}
+			if _, err := s.Enqueue("lvs0/a", false); err != nil {
+				t.Fatalf("failed to enqueue: %v", err)
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			go s.Run(ctx)
+			fn(s, ctx)
+		})
+	}
+
+	t.Run("canceled while polling", func(t *testing.T) {
+		polled := snapshotExistsStep("lvs0/a", nil)
+		polled.served = make(chan struct{})
+		steps := []jsonRPCScriptStep{
+			checksumResultXattrStep("lvs0/a", "", noXattr),
+			registerSnapshotChecksumStep("lvs0/a", nil),
+			getSnapshotChecksumStep("lvs0/a", noDevice),
+			polled,
+			{method: "bdev_lvol_stop_snapshot_checksum", params: map[string]interface{}{"name": "lvs0/a"}, result: true},
+		}
+		run(t, steps, ChecksumSchedulerOptions{CheckInterval: time.Hour}, func(s *ChecksumScheduler, ctx context.Context) {
+			<-polled.served
+			if err := s.Cancel("lvs0/a"); err != nil {
+				t.Fatalf("failed to cancel: %v", err)
+			}
+			if job, err := s.Wait(ctx, "lvs0/a"); err == nil || job.State != ChecksumJobStateCanceled {
+				t.Fatalf("expected the job to be canceled, got %+v, %v", job, err)
+			}
+		})
+	})
+
+	t.Run("snapshot deleted while polling", func(t *testing.T) {
+		steps := []jsonRPCScriptStep{
+			checksumResultXattrStep("lvs0/a", "", noXattr),
+			registerSnapshotChecksumStep("lvs0/a", nil),
+			getSnapshotChecksumStep("lvs0/a", noDevice),
+			snapshotExistsStep("lvs0/a", noDevice),
+		}
+		run(t, steps, ChecksumSchedulerOptions{CheckInterval: time.Hour}, func(s *ChecksumScheduler, ctx context.Context) {
+			job, err := s.Wait(ctx, "lvs0/a")
+			if err == nil || job.State != ChecksumJobStateError || job.Attempts != 1 || !strings.Contains(job.Error, "was deleted") {
+				t.Fatalf("expected the job to fail after one attempt, got %+v, %v", job, err)
+			}
+		})
+	})
+
+	t.Run("checksum not available in time", func(t *testing.T) {
+		steps := []jsonRPCScriptStep{
+			checksumResultXattrStep("lvs0/a", "", noXattr),
+			registerSnapshotChecksumStep("lvs0/a", nil),
+			getSnapshotChecksumStep("lvs0/a", noDevice),
+			snapshotExistsStep("lvs0/a", nil),
+		}
+		opts := ChecksumSchedulerOptions{CheckInterval: time.Hour, MaxRetries: 1, RegistrationTimeout: time.Minute}
+		run(t, steps, opts, func(s *ChecksumScheduler, ctx context.Context) {
+			job, err := s.Wait(ctx, "lvs0/a")
+			if err == nil || job.State != ChecksumJobStateError || !strings.Contains(job.Error, "is not available after") {
+				t.Fatalf("expected the job to time out, got %+v, %v", job, err)
+			}
+		})
+	})
+}
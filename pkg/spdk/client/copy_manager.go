@@ -0,0 +1,307 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/longhorn/go-spdk-helper/pkg/types"
+)
+
+const (
+	DefaultCopyCheckInterval = time.Second
+
+	// copyCheckMaxRetries is the number of consecutive failed status checks
+	// after which an operation is considered failed.
+	copyCheckMaxRetries = 3
+)
+
+type CopyKind string
+
+const (
+	CopyKindShallow = CopyKind("shallow")
+	CopyKindDeep    = CopyKind("deep")
+)
+
+// CopyProgress is a snapshot of the progress of a copy operation.
+type CopyProgress struct {
+	OperationID uint32   `json:"operation_id"`
+	Kind        CopyKind `json:"kind"`
+	SrcLvolName string   `json:"src_lvol_name"`
+	DstBdevName string   `json:"dst_bdev_name"`
+
+	State string `json:"state"`
+	// ProcessedClusters counts the copied clusters, plus the unmapped ones for
+	// a range shallow copy.
+	ProcessedClusters uint64 `json:"processed_clusters"`
+	TotalClusters     uint64 `json:"total_clusters"`
+
+	// ClustersPerSecond is the average throughput since the operation was
+	// started, or since its first check if it was already running when
+	// tracked, and ETA the time left at that throughput. ETA is 0 until the
+	// throughput is known.
+	ClustersPerSecond float64       `json:"clusters_per_second"`
+	ETA               time.Duration `json:"eta"`
+
+	StartedAt time.Time `json:"started_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	// Error is the error reported by SPDK, or the error of the last status
+	// check if the status could not be checked.
+	Error string `json:"error,omitempty"`
+}
+
+// Done reports whether the operation finished, successfully or not.
+func (p CopyProgress) Done() bool {
+	return p.State == types.ShallowCopyStateComplete || p.State == types.ShallowCopyStateError
+}
+
+// Err returns the error of a failed operation as a Go error.
+func (p CopyProgress) Err() error {
+	if p.State != types.ShallowCopyStateError {
+		return nil
+	}
+	return fmt.Errorf("%v copy operation %v from lvol %v to bdev %v failed: %v",
+		p.Kind, p.OperationID, p.SrcLvolName, p.DstBdevName, p.Error)
+}
+
+// CopyManager tracks the shallow and deep copy operations of a client. Every
+// tracked operation is polled in the background until it completes or fails,
+// and each poll is reported to the progress callback.
+type CopyManager struct {
+	ctx        context.Context
+	cli        *Client
+	interval   time.Duration
+	onProgress func(CopyProgress)
+
+	lock       sync.RWMutex
+	operations map[string]*CopyOperation
+}
+
+// CopyOperation is a copy operation tracked by a CopyManager.
+type CopyOperation struct {
+	lock     sync.RWMutex
+	progress CopyProgress
+	done     chan struct{}
+
+	// The throughput is measured from baseline processed clusters at
+	// baselineAt: none at the start for an operation started by the manager,
+	// or the count of the first check if measureFromFirstCheck is set.
+	measureFromFirstCheck bool
+	baseline              uint64
+	baselineAt            time.Time
+}
+
+// NewCopyManager creates a manager that checks the operations every interval
+// until the context is done. onProgress is optional and is called from the
+// polling goroutines, so it must be safe for concurrent use.
+func NewCopyManager(ctx context.Context, cli *Client, interval time.Duration, onProgress func(CopyProgress)) *CopyManager {
+	if interval == 0 {
+		interval = DefaultCopyCheckInterval
+	}
+	return &CopyManager{
+		ctx:        ctx,
+		cli:        cli,
+		interval:   interval,
+		onProgress: onProgress,
+		operations: map[string]*CopyOperation{},
+	}
+}
+
+// StartShallowCopy starts a shallow copy with BdevLvolStartShallowCopy and tracks it.
+func (m *CopyManager) StartShallowCopy(srcLvolName, dstBdevName string) (*CopyOperation, error) {
+	operationID, err := m.cli.BdevLvolStartShallowCopy(srcLvolName, dstBdevName)
+	if err != nil {
+		return nil, err
+	}
+	return m.track(CopyKindShallow, operationID, srcLvolName, dstBdevName, true), nil
+}
+
+// StartRangeShallowCopy starts a range shallow copy with BdevLvolStartRangeShallowCopy and tracks it.
+func (m *CopyManager) StartRangeShallowCopy(srcLvolName, dstBdevName string, clusters []uint64) (*CopyOperation, error) {
+	operationID, err := m.cli.BdevLvolStartRangeShallowCopy(srcLvolName, dstBdevName, clusters)
+	if err != nil {
+		return nil, err
+	}
+	return m.track(CopyKindShallow, operationID, srcLvolName, dstBdevName, true), nil
+}
+
+// StartDeepCopy starts a deep copy with BdevLvolStartDeepCopy and tracks it.
+func (m *CopyManager) StartDeepCopy(srcLvolName, dstBdevName string) (*CopyOperation, error) {
+	operationID, err := m.cli.BdevLvolStartDeepCopy(srcLvolName, dstBdevName)
+	if err != nil {
+		return nil, err
+	}
+	return m.track(CopyKindDeep, operationID, srcLvolName, dstBdevName, true), nil
+}
+
+// Track starts tracking an operation that was already started, e.g. by a
+// previous process. Tracking an operation twice returns the existing one.
+func (m *CopyManager) Track(kind CopyKind, operationID uint32, srcLvolName, dstBdevName string) *CopyOperation {
+	return m.track(kind, operationID, srcLvolName, dstBdevName, false)
+}
+
+func (m *CopyManager) track(kind CopyKind, operationID uint32, srcLvolName, dstBdevName string, started bool) *CopyOperation {
+	key := fmt.Sprintf("%s/%d", kind, operationID)
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if op, ok := m.operations[key]; ok {
+		return op
+	}
+
+	now := time.Now()
+	op := &CopyOperation{
+		progress: CopyProgress{
+			OperationID: operationID,
+			Kind:        kind,
+			SrcLvolName: srcLvolName,
+			DstBdevName: dstBdevName,
+			State:       types.ShallowCopyStateInProgress,
+			StartedAt:   now,
+			UpdatedAt:   now,
+		},
+		done:                  make(chan struct{}),
+		measureFromFirstCheck: !started,
+		baselineAt:            now,
+	}
+	m.operations[key] = op
+
+	go m.poll(op)
+
+	return op
+}
+
+// List returns the progress of all tracked operations.
+func (m *CopyManager) List() []CopyProgress {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	list := make([]CopyProgress, 0, len(m.operations))
+	for _, op := range m.operations {
+		list = append(list, op.Progress())
+	}
+	return list
+}
+
+// Forget stops tracking a finished operation.
+func (m *CopyManager) Forget(kind CopyKind, operationID uint32) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	delete(m.operations, fmt.Sprintf("%s/%d", kind, operationID))
+}
+
+func (m *CopyManager) poll(op *CopyOperation) {
+	defer close(op.done)
+
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+
+	failures := 0
+	for {
+		progress := op.Progress()
+
+		state, processed, total, errMsg, err := m.check(progress.Kind, progress.OperationID)
+		if err != nil {
+			failures++
+			logrus.WithError(err).Warnf("Failed to check %v copy operation %v, attempt %d", progress.Kind, progress.OperationID, failures)
+			if failures >= copyCheckMaxRetries {
+				state, errMsg = types.ShallowCopyStateError, err.Error()
+				processed, total = progress.ProcessedClusters, progress.TotalClusters
+			}
+		} else {
+			failures = 0
+		}
+
+		if err == nil || failures >= copyCheckMaxRetries {
+			progress = op.update(state, processed, total, errMsg, time.Now())
+			if m.onProgress != nil {
+				m.onProgress(progress)
+			}
+			if progress.Done() {
+				return
+			}
+		}
+
+		select {
+		case <-ticker.C:
+		case <-m.ctx.Done():
+			return
+		}
+	}
+}
+
+func (m *CopyManager) check(kind CopyKind, operationID uint32) (state string, processed, total uint64, errMsg string, err error) {
+	if kind == CopyKindDeep {
+		status, err := m.cli.BdevLvolCheckDeepCopy(operationID)
+		if err != nil {
+			return "", 0, 0, "", err
+		}
+		return status.State, status.ProcessedClusters, status.TotalClusters, status.Error, nil
+	}
+
+	status, err := m.cli.BdevLvolCheckShallowCopy(operationID)
+	if err != nil {
+		return "", 0, 0, "", err
+	}
+	return status.State, status.CopiedClusters + status.UnmappedClusters, status.TotalClusters, status.Error, nil
+}
+
+func (op *CopyOperation) update(state string, processed, total uint64, errMsg string, now time.Time) CopyProgress {
+	op.lock.Lock()
+	defer op.lock.Unlock()
+
+	p := &op.progress
+	p.State = state
+	p.ProcessedClusters = processed
+	p.TotalClusters = total
+	p.Error = errMsg
+	p.UpdatedAt = now
+
+	if op.measureFromFirstCheck {
+		op.baseline, op.baselineAt = processed, now
+		op.measureFromFirstCheck = false
+	}
+
+	p.ClustersPerSecond, p.ETA = 0, 0
+	if elapsed := now.Sub(op.baselineAt).Seconds(); elapsed > 0 && processed > op.baseline {
+		p.ClustersPerSecond = float64(processed-op.baseline) / elapsed
+		if total > processed {
+			p.ETA = time.Duration(float64(total-processed) / p.ClustersPerSecond * float64(time.Second))
+		}
+	}
+
+	return *p
+}
+
+// Progress returns the progress of the last check.
+func (op *CopyOperation) Progress() CopyProgress {
+	op.lock.RLock()
+	defer op.lock.RUnlock()
+	return op.progress
+}
+
+// Done is closed when the operation finished or the manager stopped polling it.
+func (op *CopyOperation) Done() <-chan struct{} {
+	return op.done
+}
+
+// Wait waits until the operation finishes or the context is done. It returns
+// the last progress, and an error if the operation failed or did not finish.
+func (op *CopyOperation) Wait(ctx context.Context) (CopyProgress, error) {
+	select {
+	case <-op.done:
+	case <-ctx.Done():
+		return op.Progress(), ctx.Err()
+	}
+
+	progress := op.Progress()
+	if !progress.Done() {
+		return progress, fmt.Errorf("stopped tracking %v copy operation %v before it finished", progress.Kind, progress.OperationID)
+	}
+	return progress, progress.Err()
+}
@@ -0,0 +1,136 @@
+package client
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	spdktypes "github.com/longhorn/go-spdk-helper/pkg/spdk/types"
+	"github.com/longhorn/go-spdk-helper/pkg/types"
+)
+
+func TestCopyManager(t *testing.T) {
+	tests := []struct {
+		name    string
+		steps   []jsonRPCScriptStep
+		start   func(*CopyManager) (*CopyOperation, error)
+		wantErr string
+	}{
+		{
+			name: "shallow copy completes",
+			steps: []jsonRPCScriptStep{
+				{
+					method: "bdev_lvol_start_shallow_copy",
+					params: map[string]interface{}{"src_lvol_name": "lvs0/snap", "dst_bdev_name": "dst"},
+					result: spdktypes.ShallowCopy{OperationId: 7},
+				},
+				{
+					method: "bdev_lvol_check_shallow_copy",
+					params: map[string]interface{}{"operation_id": float64(7)},
+					result: spdktypes.ShallowCopyStatus{State: types.ShallowCopyStateInProgress, CopiedClusters: 1, TotalClusters: 4},
+				},
+				{
+					method: "bdev_lvol_check_shallow_copy",
+					params: map[string]interface{}{"operation_id": float64(7)},
+					result: spdktypes.ShallowCopyStatus{State: types.ShallowCopyStateComplete, CopiedClusters: 4, TotalClusters: 4},
+				},
+			},
+			start: func(m *CopyManager) (*CopyOperation, error) {
+				return m.StartShallowCopy("lvs0/snap", "dst")
+			},
+		},
+		{
+			name: "deep copy surfaces the SPDK error",
+			steps: []jsonRPCScriptStep{
+				{
+					method: "bdev_lvol_start_deep_copy",
+					params: map[string]interface{}{"src_lvol_name": "lvs0/snap", "dst_bdev_name": "dst"},
+					result: spdktypes.DeepCopy{OperationId: 3},
+				},
+				{
+					method: "bdev_lvol_check_deep_copy",
+					params: map[string]interface{}{"operation_id": float64(3)},
+					result: spdktypes.DeepCopyStatus{State: types.ShallowCopyStateError, ProcessedClusters: 2, TotalClusters: 4, Error: "Input/output error"},
+				},
+			},
+			start: func(m *CopyManager) (*CopyOperation, error) {
+				return m.StartDeepCopy("lvs0/snap", "dst")
+			},
+			wantErr: "Input/output error",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			runJSONRPCScriptTest(t, test.steps, func(cli *Client) {
+				var lock sync.Mutex
+				reported := []CopyProgress{}
+				m := NewCopyManager(context.Background(), cli, time.Millisecond, func(p CopyProgress) {
+					lock.Lock()
+					defer lock.Unlock()
+					reported = append(reported, p)
+				})
+
+				op, err := test.start(m)
+				if err != nil {
+					t.Fatalf("failed to start copy: %v", err)
+				}
+
+				ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+				defer cancel()
+				progress, err := op.Wait(ctx)
+				if test.wantErr == "" && err != nil {
+					t.Fatalf("copy failed: %v", err)
+				}
+				if test.wantErr != "" && (err == nil || !strings.Contains(err.Error(), test.wantErr)) {
+					t.Fatalf("expected error containing %q, got %v", test.wantErr, err)
+				}
+				if !progress.Done() {
+					t.Fatalf("expected a finished copy, got %+v", progress)
+				}
+
+				lock.Lock()
+				defer lock.Unlock()
+				if len(reported) != len(test.steps)-1 {
+					t.Fatalf("expected %d progress reports, got %d", len(test.steps)-1, len(reported))
+				}
+				if len(m.List()) != 1 {
+					t.Fatalf("expected one tracked operation, got %d", len(m.List()))
+				}
+			})
+		})
+	}
+}
+
+func TestCopyOperationUpdateComputesETA(t *testing.T) {
+	start := time.Now()
+	op := &CopyOperation{progress: CopyProgress{StartedAt: start}, baselineAt: start}
+
+	p := op.update(types.ShallowCopyStateInProgress, 0, 100, "", start.Add(time.Second))
+	if p.ClustersPerSecond != 0 || p.ETA != 0 {
+		t.Fatalf("expected unknown throughput, got %+v", p)
+	}
+
+	p = op.update(types.ShallowCopyStateInProgress, 25, 100, "", start.Add(5*time.Second))
+	if p.ClustersPerSecond != 5 || p.ETA != 15*time.Second {
+		t.Fatalf("unexpected throughput %v and ETA %v", p.ClustersPerSecond, p.ETA)
+	}
+}
+
+func TestCopyOperationUpdateMeasuresTrackedOperationFromFirstCheck(t *testing.T) {
+	start := time.Now()
+	op := &CopyOperation{progress: CopyProgress{StartedAt: start}, measureFromFirstCheck: true, baselineAt: start}
+
+	// 60 clusters were copied before the operation was tracked.
+	p := op.update(types.ShallowCopyStateInProgress, 60, 100, "", start.Add(time.Second))
+	if p.ClustersPerSecond != 0 || p.ETA != 0 {
+		t.Fatalf("expected unknown throughput, got %+v", p)
+	}
+
+	p = op.update(types.ShallowCopyStateInProgress, 80, 100, "", start.Add(5*time.Second))
+	if p.ClustersPerSecond != 5 || p.ETA != 4*time.Second {
+		t.Fatalf("unexpected throughput %v and ETA %v", p.ClustersPerSecond, p.ETA)
+	}
+}
@@ -4,6 +4,8 @@ import (
 	"encoding/json"
 	"fmt"
 
+	"github.com/cockroachdb/errors"
+
 	"github.com/longhorn/go-spdk-helper/pkg/jsonrpc"
 	spdktypes "github.com/longhorn/go-spdk-helper/pkg/spdk/types"
 )
@@ -45,6 +47,30 @@ func (c *Client) BdevEcCreate(name string, dataChunks, parityChunks, stripSizeKB
 	return name, nil
 }
 
+// ValidateECCreate checks a bdev_ec_create request against its base bdevs
+// before it is sent, see spdktypes.ValidateECBaseBdevs.
+//
+//	"req": Required. The request to validate.
+//	"minDataBytes": Optional. The data bytes each base bdev must hold past the
+//	    EC metadata. One strip is required if it is 0.
+func (c *Client) ValidateECCreate(req *spdktypes.BdevEcCreateRequest, minDataBytes uint64) error {
+	bdevs := map[string]*spdktypes.BdevInfo{}
+	for _, name := range req.BaseBdevs {
+		if _, ok := bdevs[name]; ok {
+			continue
+		}
+		infos, err := c.BdevGetBdevs(name, 0)
+		if err != nil && !jsonrpc.IsJSONRPCRespErrorNoSuchDevice(err) {
+			return errors.Wrapf(err, "failed to get base bdev %v", name)
+		}
+		bdevs[name] = nil
+		if err == nil && len(infos) > 0 {
+			bdevs[name] = &infos[0]
+		}
+	}
+	return spdktypes.ValidateECBaseBdevs(req, bdevs, minDataBytes)
+}
+
 // BdevEcDelete deletes an EC bdev by name.
 func (c *Client) BdevEcDelete(name string) (deleted bool, err error) {
 	req := spdktypes.BdevEcDeleteRequest{
@@ -248,7 +274,8 @@ func (c *Client) BdevEcGetUnmapStatus(name string) (status spdktypes.BdevEcUnmap
 	return status, json.Unmarshal(cmdOutput, &status)
 }
 
-// BdevEcGetScrubProgress queries the startup scrub progress of an EC bdev.
+// BdevEcGetScrubProgress queries the scrub progress of an EC bdev, either of
+// the startup scrub or of a scrub started by BdevEcStartScrub.
 // Returns nil, nil when SPDK signals -ENOENT (no scrub is active).
 // A non-nil pointer means a scrub is currently running.
 func (c *Client) BdevEcGetScrubProgress(name string) (*spdktypes.BdevEcScrubProgress, error) {
@@ -270,3 +297,54 @@ func (c *Client) BdevEcGetScrubProgress(name string) (*spdktypes.BdevEcScrubProg
 	}
 	return &progress, nil
 }
+
+// BdevEcStartScrub starts a background scrub of an EC bdev, which verifies
+// the parity of every stripe and repairs it. With dirtyOnly, only the regions
+// marked dirty in the WIB are scrubbed. Returns an error if a scrub is
+// already running. Progress is reported by BdevEcGetScrubProgress.
+func (c *Client) BdevEcStartScrub(name string, dirtyOnly bool) (resp spdktypes.BdevEcStartScrubResponse, err error) {
+	req := spdktypes.BdevEcStartScrubRequest{
+		Name:      name,
+		DirtyOnly: dirtyOnly,
+	}
+
+	cmdOutput, err := c.jsonCli.SendCommand("bdev_ec_start_scrub", req)
+	if err != nil {
+		return resp, err
+	}
+
+	return resp, json.Unmarshal(cmdOutput, &resp)
+}
+
+// BdevEcStopScrub stops a running scrub. Returns an error if no scrub is in
+// progress (-ENOENT). The regions not scrubbed yet keep their WIB state.
+func (c *Client) BdevEcStopScrub(name string) (stopped bool, err error) {
+	req := spdktypes.BdevEcStopScrubRequest{
+		Name: name,
+	}
+
+	cmdOutput, err := c.jsonCli.SendCommand("bdev_ec_stop_scrub", req)
+	if err != nil {
+		return false, err
+	}
+
+	return stopped, json.Unmarshal(cmdOutput, &stopped)
+}
+
+// BdevEcSetScrubQos sets the scrub rate limit in stripes/sec.
+// maxStripesPerSec=0 means unlimited. paused=true suspends the scrub poller
+// without cancelling it. Applied immediately to any in-progress scrub.
+func (c *Client) BdevEcSetScrubQos(name string, maxStripesPerSec uint32, paused bool) (set bool, err error) {
+	req := spdktypes.BdevEcSetScrubQosRequest{
+		Name:             name,
+		MaxStripesPerSec: maxStripesPerSec,
+		Paused:           paused,
+	}
+
+	cmdOutput, err := c.jsonCli.SendCommand("bdev_ec_set_scrub_qos", req)
+	if err != nil {
+		return false, err
+	}
+
+	return set, json.Unmarshal(cmdOutput, &set)
+}
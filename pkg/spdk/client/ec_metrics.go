@@ -0,0 +1,343 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/sirupsen/logrus"
+
+	spdktypes "github.com/longhorn/go-spdk-helper/pkg/spdk/types"
+)
+
+const (
+	DefaultEcMetricsInterval = 15 * time.Second
+
+	ecMetricsPrefix = "spdk_ec_"
+)
+
+// EcRates are the rates and ratios of the counters of an EC bdev between two
+// samples. The ratios are nil when their denominator did not change.
+type EcRates struct {
+	// Interval is the time between the two samples.
+	Interval time.Duration `json:"interval"`
+
+	RmwPerSec                        float64 `json:"rmw_per_sec"`
+	RmwDeferredPerSec                float64 `json:"rmw_deferred_per_sec"`
+	FullStripeWritesPerSec           float64 `json:"full_stripe_writes_per_sec"`
+	UnmapsCompletedPerSec            float64 `json:"unmaps_completed_per_sec"`
+	UnmapsFailedPerSec               float64 `json:"unmaps_failed_per_sec"`
+	DegradedReadsReconstructedPerSec float64 `json:"degraded_reads_reconstructed_per_sec"`
+
+	// RmwRatio is the share of the stripe writes that were read-modify-writes
+	// rather than full-stripe writes.
+	RmwRatio *float64 `json:"rmw_ratio,omitempty"`
+	// RmwDeferredRatio is the number of deferrals, for a scrub, a dirty stripe
+	// or an in-flight write, per read-modify-write.
+	RmwDeferredRatio *float64 `json:"rmw_deferred_ratio,omitempty"`
+	// UnmapFailureRatio is the share of the submitted unmaps that failed.
+	UnmapFailureRatio *float64 `json:"unmap_failure_ratio,omitempty"`
+}
+
+// ComputeEcRates computes the rates of the counters of an EC bdev from two
+// samples taken interval apart. A counter lower than in the previous sample
+// was reset, e.g. by recreating the EC bdev, and counts from 0.
+func ComputeEcRates(prev, cur *spdktypes.BdevEcInfo, interval time.Duration) EcRates {
+	rates := EcRates{Interval: interval}
+	if interval <= 0 {
+		return rates
+	}
+	secs := interval.Seconds()
+
+	rmw := ecCounterDelta(prev.RmwTotal, cur.RmwTotal)
+	rmwDeferred := ecCounterDelta(prev.RmwDeferredScrub, cur.RmwDeferredScrub) +
+		ecCounterDelta(prev.RmwDeferredDirty, cur.RmwDeferredDirty) +
+		ecCounterDelta(prev.RmwDeferredInflight, cur.RmwDeferredInflight)
+	fullStripeWrites := ecCounterDelta(prev.FullStripeWrites, cur.FullStripeWrites)
+	unmapsSubmitted := ecCounterDelta(prev.UnmapsSubmitted, cur.UnmapsSubmitted)
+	unmapsFailed := ecCounterDelta(prev.UnmapsFailed, cur.UnmapsFailed)
+
+	rates.RmwPerSec = float64(rmw) / secs
+	rates.RmwDeferredPerSec = float64(rmwDeferred) / secs
+	rates.FullStripeWritesPerSec = float64(fullStripeWrites) / secs
+	rates.UnmapsCompletedPerSec = float64(ecCounterDelta(prev.UnmapsCompleted, cur.UnmapsCompleted)) / secs
+	rates.UnmapsFailedPerSec = float64(unmapsFailed) / secs
+	rates.DegradedReadsReconstructedPerSec = float64(ecCounterDelta(prev.DegradedReadsReconstructed, cur.DegradedReadsReconstructed)) / secs
+
+	rates.RmwRatio = ecRatio(rmw, rmw+fullStripeWrites)
+	rates.RmwDeferredRatio = ecRatio(rmwDeferred, rmw)
+	rates.UnmapFailureRatio = ecRatio(unmapsFailed, unmapsSubmitted)
+	return rates
+}
+
+func ecCounterDelta(prev, cur uint64) uint64 {
+	if cur < prev {
+		return cur
+	}
+	return cur - prev
+}
+
+func ecRatio(num, den uint64) *float64 {
+	if den == 0 {
+		return nil
+	}
+	ratio := float64(num) / float64(den)
+	return &ratio
+}
+
+// EcMetrics is the last sample of an EC bdev and the rates since the one
+// before it.
+type EcMetrics struct {
+	Time time.Time            `json:"time"`
+	Info spdktypes.BdevEcInfo `json:"info"`
+	// Rates is nil until the EC bdev has been sampled twice.
+	Rates *EcRates `json:"rates,omitempty"`
+}
+
+type EcMetricsCollectorOptions struct {
+	// Interval is the interval between samples.
+	// DefaultEcMetricsInterval is used if it is 0.
+	Interval time.Duration
+}
+
+// EcMetricsCollector samples the counters of the EC bdevs of a target and
+// exports them, with their rates, in the Prometheus text format. It is an
+// http.Handler, so it can serve the metrics endpoint directly.
+type EcMetricsCollector struct {
+	cli  *Client
+	opts EcMetricsCollectorOptions
+	now  func() time.Time
+
+	lock    sync.RWMutex
+	metrics map[string]*EcMetrics
+}
+
+func NewEcMetricsCollector(cli *Client, opts EcMetricsCollectorOptions) *EcMetricsCollector {
+	if opts.Interval == 0 {
+		opts.Interval = DefaultEcMetricsInterval
+	}
+	return &EcMetricsCollector{
+		cli:     cli,
+		opts:    opts,
+		now:     time.Now,
+		metrics: map[string]*EcMetrics{},
+	}
+}
+
+// Run samples the EC bdevs every Interval until the context is done.
+func (m *EcMetricsCollector) Run(ctx context.Context) {
+	ticker := time.NewTicker(m.opts.Interval)
+	defer ticker.Stop()
+
+	for {
+		if err := m.Collect(); err != nil {
+			logrus.WithError(err).Warn("Failed to collect EC metrics")
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Collect samples the EC bdevs and computes the rates since the previous
+// sample. The EC bdevs that are gone are forgotten.
+func (m *EcMetricsCollector) Collect() error {
+	infos, err := m.cli.BdevEcGetBdevs("")
+	if err != nil {
+		return errors.Wrap(err, "failed to get EC bdevs")
+	}
+	now := m.now()
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	metrics := map[string]*EcMetrics{}
+	for i := range infos {
+		sample := &EcMetrics{Time: now, Info: infos[i]}
+		if prev := m.metrics[infos[i].Name]; prev != nil {
+			rates := ComputeEcRates(&prev.Info, &sample.Info, now.Sub(prev.Time))
+			sample.Rates = &rates
+		}
+		metrics[infos[i].Name] = sample
+	}
+	m.metrics = metrics
+	return nil
+}
+
+// List returns the last samples of the EC bdevs, sorted by name.
+func (m *EcMetricsCollector) List() []EcMetrics {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	list := []EcMetrics{}
+	for _, metrics := range m.metrics {
+		list = append(list, *metrics)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Info.Name < list[j].Info.Name })
+	return list
+}
+
+type ecMetricFamily struct {
+	name string
+	typ  string
+	help string
+	// samples returns the labels and values of the family for an EC bdev,
+	// without the ec_name label.
+	samples func(metrics *EcMetrics) []ecMetricSample
+}
+
+type ecMetricSample struct {
+	labels [][2]string
+	value  float64
+}
+
+func ecCounter(name, help string, value func(info *spdktypes.BdevEcInfo) uint64) ecMetricFamily {
+	return ecMetricFamily{name: name, typ: "counter", help: help, samples: func(metrics *EcMetrics) []ecMetricSample {
+		return []ecMetricSample{{value: float64(value(&metrics.Info))}}
+	}}
+}
+
+func ecGauge(name, help string, value func(info *spdktypes.BdevEcInfo) float64) ecMetricFamily {
+	return ecMetricFamily{name: name, typ: "gauge", help: help, samples: func(metrics *EcMetrics) []ecMetricSample {
+		return []ecMetricSample{{value: value(&metrics.Info)}}
+	}}
+}
+
+// ecRateGauge is a gauge of the rates, without samples until there are rates
+// or when the value is nil.
+func ecRateGauge(name, help string, value func(rates *EcRates) *float64) ecMetricFamily {
+	return ecMetricFamily{name: name, typ: "gauge", help: help, samples: func(metrics *EcMetrics) []ecMetricSample {
+		if metrics.Rates == nil {
+			return nil
+		}
+		v := value(metrics.Rates)
+		if v == nil {
+			return nil
+		}
+		return []ecMetricSample{{value: *v}}
+	}}
+}
+
+var ecMetricFamilies = []ecMetricFamily{
+	{name: "state", typ: "gauge", help: "State of the EC bdev, 1 for the current state.", samples: func(metrics *EcMetrics) []ecMetricSample {
+		samples := []ecMetricSample{}
+		for _, state := range []spdktypes.BdevEcState{spdktypes.BdevEcStateOnline, spdktypes.BdevEcStateDegraded, spdktypes.BdevEcStateOffline} {
+			value := 0.0
+			if metrics.Info.State == state {
+				value = 1
+			}
+			samples = append(samples, ecMetricSample{labels: [][2]string{{"state", string(state)}}, value: value})
+		}
+		return samples
+	}},
+	{name: "slots", typ: "gauge", help: "Number of base bdev slots by slot state.", samples: func(metrics *EcMetrics) []ecMetricSample {
+		counts := map[spdktypes.BdevEcSlotState]int{}
+		for _, base := range metrics.Info.BaseBdevs {
+			counts[base.State]++
+		}
+		samples := []ecMetricSample{}
+		for _, state := range []spdktypes.BdevEcSlotState{spdktypes.BdevEcSlotStateNormal, spdktypes.BdevEcSlotStateFailed, spdktypes.BdevEcSlotStateReplacing} {
+			samples = append(samples, ecMetricSample{labels: [][2]string{{"state", string(state)}}, value: float64(counts[state])})
+		}
+		return samples
+	}},
+	{name: "slot_state", typ: "gauge", help: "Base bdev of each slot, 1 labelled with its role and state.", samples: func(metrics *EcMetrics) []ecMetricSample {
+		samples := []ecMetricSample{}
+		for _, base := range metrics.Info.BaseBdevs {
+			samples = append(samples, ecMetricSample{labels: [][2]string{
+				{"slot", strconv.FormatUint(uint64(base.Slot), 10)},
+				{"base_bdev", base.Name},
+				{"role", string(base.Role)},
+				{"state", string(base.State)},
+			}, value: 1})
+		}
+		return samples
+	}},
+	ecGauge("rebuild_in_progress", "Whether a rebuild is in progress.", func(info *spdktypes.BdevEcInfo) float64 {
+		if info.RebuildInProgress {
+			return 1
+		}
+		return 0
+	}),
+	ecGauge("dirty_stripes", "Number of stripes currently claimed by a write.", func(info *spdktypes.BdevEcInfo) float64 { return float64(info.DirtyStripes) }),
+	ecGauge("rmw_in_flight", "Number of read-modify-writes in flight.", func(info *spdktypes.BdevEcInfo) float64 { return float64(info.RmwInFlight) }),
+	ecGauge("unmapped_stripes", "Number of unmapped stripes.", func(info *spdktypes.BdevEcInfo) float64 { return float64(info.UnmappedStripes) }),
+
+	ecCounter("rmw_total", "Read-modify-writes.", func(info *spdktypes.BdevEcInfo) uint64 { return info.RmwTotal }),
+	{name: "rmw_deferred_total", typ: "counter", help: "Read-modify-writes deferred, by reason.", samples: func(metrics *EcMetrics) []ecMetricSample {
+		info := &metrics.Info
+		return []ecMetricSample{
+			{labels: [][2]string{{"reason", "scrub"}}, value: float64(info.RmwDeferredScrub)},
+			{labels: [][2]string{{"reason", "dirty"}}, value: float64(info.RmwDeferredDirty)},
+			{labels: [][2]string{{"reason", "inflight"}}, value: float64(info.RmwDeferredInflight)},
+		}
+	}},
+	ecCounter("full_stripe_writes_total", "Full-stripe writes.", func(info *spdktypes.BdevEcInfo) uint64 { return info.FullStripeWrites }),
+	ecCounter("full_stripe_writes_deferred_total", "Full-stripe writes deferred.", func(info *spdktypes.BdevEcInfo) uint64 { return info.FullStripeWritesDeferred }),
+	ecCounter("unmaps_submitted_total", "Unmaps submitted.", func(info *spdktypes.BdevEcInfo) uint64 { return info.UnmapsSubmitted }),
+	ecCounter("unmaps_completed_total", "Unmaps completed.", func(info *spdktypes.BdevEcInfo) uint64 { return info.UnmapsCompleted }),
+	ecCounter("unmaps_deferred_busy_total", "Unmaps deferred because the stripe was busy.", func(info *spdktypes.BdevEcInfo) uint64 { return info.UnmapsDeferredBusy }),
+	ecCounter("unmaps_via_write_zeros_total", "Unmaps done with write zeroes.", func(info *spdktypes.BdevEcInfo) uint64 { return info.UnmapsViaWriteZeros }),
+	ecCounter("unmaps_failed_total", "Unmaps failed.", func(info *spdktypes.BdevEcInfo) uint64 { return info.UnmapsFailed }),
+	ecCounter("unmap_fanout_misses_total", "Unmaps not fanned out to all base bdevs.", func(info *spdktypes.BdevEcInfo) uint64 { return info.UnmapFanoutMisses }),
+	ecCounter("unmapped_reads_synthesized_total", "Reads of unmapped stripes answered with zeroes.", func(info *spdktypes.BdevEcInfo) uint64 { return info.UnmappedReadsSynthesized }),
+	ecCounter("writes_into_unmapped_total", "Writes into unmapped stripes.", func(info *spdktypes.BdevEcInfo) uint64 { return info.WritesIntoUnmapped }),
+	ecCounter("writes_into_unmapped_failed_total", "Writes into unmapped stripes failed.", func(info *spdktypes.BdevEcInfo) uint64 { return info.WritesIntoUnmappedFailed }),
+	ecCounter("degraded_reads_reconstructed_total", "Degraded reads reconstructed from parity.", func(info *spdktypes.BdevEcInfo) uint64 { return info.DegradedReadsReconstructed }),
+	ecCounter("degraded_read_eio_dirty_total", "Degraded reads failed because the stripe was dirty.", func(info *spdktypes.BdevEcInfo) uint64 { return info.DegradedReadEioDirty }),
+
+	ecRateGauge("rmw_per_second", "Read-modify-writes per second over the last interval.", func(rates *EcRates) *float64 { return &rates.RmwPerSec }),
+	ecRateGauge("rmw_deferred_per_second", "Read-modify-write deferrals per second over the last interval.", func(rates *EcRates) *float64 { return &rates.RmwDeferredPerSec }),
+	ecRateGauge("full_stripe_writes_per_second", "Full-stripe writes per second over the last interval.", func(rates *EcRates) *float64 { return &rates.FullStripeWritesPerSec }),
+	ecRateGauge("unmaps_completed_per_second", "Unmaps completed per second over the last interval.", func(rates *EcRates) *float64 { return &rates.UnmapsCompletedPerSec }),
+	ecRateGauge("unmaps_failed_per_second", "Unmaps failed per second over the last interval.", func(rates *EcRates) *float64 { return &rates.UnmapsFailedPerSec }),
+	ecRateGauge("degraded_reads_reconstructed_per_second", "Degraded reads reconstructed per second over the last interval.", func(rates *EcRates) *float64 { return &rates.DegradedReadsReconstructedPerSec }),
+	ecRateGauge("rmw_ratio", "Share of the stripe writes that were read-modify-writes over the last interval.", func(rates *EcRates) *float64 { return rates.RmwRatio }),
+	ecRateGauge("rmw_deferred_ratio", "Read-modify-write deferrals per read-modify-write over the last interval.", func(rates *EcRates) *float64 { return rates.RmwDeferredRatio }),
+	ecRateGauge("unmap_failure_ratio", "Share of the submitted unmaps that failed over the last interval.", func(rates *EcRates) *float64 { return rates.UnmapFailureRatio }),
+}
+
+var ecLabelValueReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// WriteMetrics writes the last samples in the Prometheus text format. Every
+// metric is labelled with ec_name.
+func (m *EcMetricsCollector) WriteMetrics(w io.Writer) error {
+	list := m.List()
+
+	bw := bufio.NewWriter(w)
+	for _, family := range ecMetricFamilies {
+		name := ecMetricsPrefix + family.name
+		fmt.Fprintf(bw, "# HELP %s %s\n", name, family.help)
+		fmt.Fprintf(bw, "# TYPE %s %s\n", name, family.typ)
+		for i := range list {
+			for _, sample := range family.samples(&list[i]) {
+				bw.WriteString(name)
+				bw.WriteString(`{ec_name="` + ecLabelValueReplacer.Replace(list[i].Info.Name) + `"`)
+				for _, label := range sample.labels {
+					bw.WriteString("," + label[0] + `="` + ecLabelValueReplacer.Replace(label[1]) + `"`)
+				}
+				bw.WriteString("} " + strconv.FormatFloat(sample.value, 'g', -1, 64) + "\n")
+			}
+		}
+	}
+	return bw.Flush()
+}
+
+// ServeHTTP serves the last samples in the Prometheus text format.
+func (m *EcMetricsCollector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	if err := m.WriteMetrics(w); err != nil {
+		logrus.WithError(err).Warn("Failed to write EC metrics")
+	}
+}
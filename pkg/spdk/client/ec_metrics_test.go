@@ -0,0 +1,115 @@
+package client
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	spdktypes "github.com/longhorn/go-spdk-helper/pkg/spdk/types"
+)
+
+func TestComputeEcRates(t *testing.T) {
+	prev := &spdktypes.BdevEcInfo{RmwTotal: 100, RmwDeferredDirty: 10, FullStripeWrites: 300, UnmapsSubmitted: 50, UnmapsFailed: 5}
+	cur := &spdktypes.BdevEcInfo{RmwTotal: 120, RmwDeferredDirty: 15, RmwDeferredScrub: 5, FullStripeWrites: 380, UnmapsSubmitted: 50, UnmapsFailed: 5}
+
+	rates := ComputeEcRates(prev, cur, 10*time.Second)
+	if rates.RmwPerSec != 2 || rates.FullStripeWritesPerSec != 8 || rates.RmwDeferredPerSec != 1 {
+		t.Fatalf("unexpected rates %+v", rates)
+	}
+	if rates.RmwRatio == nil || *rates.RmwRatio != 0.2 {
+		t.Fatalf("got RMW ratio %v, want 0.2", rates.RmwRatio)
+	}
+	if rates.RmwDeferredRatio == nil || *rates.RmwDeferredRatio != 0.5 {
+		t.Fatalf("got RMW deferred ratio %v, want 0.5", rates.RmwDeferredRatio)
+	}
+	if rates.UnmapFailureRatio != nil {
+		t.Fatalf("got unmap failure ratio %v without unmaps", *rates.UnmapFailureRatio)
+	}
+
+	// The EC bdev was recreated, so its counters restarted from 0.
+	reset := &spdktypes.BdevEcInfo{RmwTotal: 10, FullStripeWrites: 30}
+	rates = ComputeEcRates(cur, reset, 10*time.Second)
+	if rates.RmwPerSec != 1 || rates.FullStripeWritesPerSec != 3 {
+		t.Fatalf("unexpected rates after a reset %+v", rates)
+	}
+}
+
+func TestEcMetricsCollector(t *testing.T) {
+	failed := spdktypes.BdevEcSlotStateFailed
+	normal := spdktypes.BdevEcSlotStateNormal
+	steps := []jsonRPCScriptStep{
+		ecGetBdevsStep(ecInfoResult("ec0", normal, false, 10), ecInfoResult("ec1", failed, false, 0)),
+		ecGetBdevsStep(ecInfoResult("ec0", normal, false, 30), ecInfoResult("ec1", failed, false, 0)),
+		ecGetBdevsStep(ecInfoResult("ec0", normal, false, 30)),
+	}
+
+	now := time.Unix(1000, 0)
+	outputs := []string{}
+	runJSONRPCScriptTest(t, steps, func(cli *Client) {
+		m := NewEcMetricsCollector(cli, EcMetricsCollectorOptions{})
+		m.now = func() time.Time { return now }
+
+		for range steps {
+			if err := m.Collect(); err != nil {
+				t.Errorf("failed to collect EC metrics: %v", err)
+				return
+			}
+			var buf bytes.Buffer
+			if err := m.WriteMetrics(&buf); err != nil {
+				t.Errorf("failed to write EC metrics: %v", err)
+				return
+			}
+			outputs = append(outputs, buf.String())
+			now = now.Add(10 * time.Second)
+		}
+	})
+	if t.Failed() {
+		return
+	}
+
+	for _, tc := range []struct {
+		output int
+		expect []string
+		absent []string
+	}{
+		{
+			output: 0,
+			expect: []string{
+				"# TYPE spdk_ec_rmw_total counter\n",
+				`spdk_ec_rmw_total{ec_name="ec0"} 5` + "\n",
+				`spdk_ec_rmw_deferred_total{ec_name="ec0",reason="dirty"} 0` + "\n",
+			},
+			absent: []string{`spdk_ec_rmw_per_second{`, `spdk_ec_rmw_ratio{`},
+		},
+		{
+			output: 1,
+			expect: []string{
+				`spdk_ec_rmw_total{ec_name="ec0"} 15` + "\n",
+				`spdk_ec_rmw_per_second{ec_name="ec0"} 1` + "\n",
+				`spdk_ec_rmw_ratio{ec_name="ec0"} 0.5` + "\n",
+				`spdk_ec_state{ec_name="ec1",state="degraded"} 1` + "\n",
+				`spdk_ec_state{ec_name="ec1",state="online"} 0` + "\n",
+				`spdk_ec_slots{ec_name="ec1",state="failed"} 1` + "\n",
+				`spdk_ec_slot_state{ec_name="ec1",slot="2",base_bdev="b2",role="parity",state="failed"} 1` + "\n",
+			},
+			absent: []string{`spdk_ec_rmw_ratio{ec_name="ec1"}`},
+		},
+		{
+			output: 2,
+			expect: []string{`spdk_ec_rmw_per_second{ec_name="ec0"} 0` + "\n"},
+			absent: []string{`ec_name="ec1"`},
+		},
+	} {
+		for _, s := range tc.expect {
+			if !strings.Contains(outputs[tc.output], s) {
+				t.Errorf("output %d lacks %q:\n%s", tc.output, s, outputs[tc.output])
+			}
+		}
+		for _, s := range tc.absent {
+			if strings.Contains(outputs[tc.output], s) {
+				t.Errorf("output %d unexpectedly contains %q:\n%s", tc.output, s, outputs[tc.output])
+			}
+		}
+	}
+}
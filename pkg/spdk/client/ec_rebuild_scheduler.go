@@ -0,0 +1,228 @@
+package client
+
+import (
+	"sort"
+	"sync"
+
+	spdktypes "github.com/longhorn/go-spdk-helper/pkg/spdk/types"
+)
+
+const (
+	DefaultEcMaxRebuildsPerNode       = 2
+	DefaultEcMaxRebuildsPerBaseDevice = 1
+)
+
+type EcRebuildSchedulerOptions struct {
+	// MaxRebuildsPerNode is the number of rebuilds allowed to run at once on
+	// a node. DefaultEcMaxRebuildsPerNode is used if it is 0.
+	MaxRebuildsPerNode int
+	// MaxRebuildsPerBaseDevice is the number of rebuilds allowed to use a
+	// base device at once. DefaultEcMaxRebuildsPerBaseDevice is used if it is 0.
+	MaxRebuildsPerBaseDevice int
+	// BaseDevice returns the device backing a base bdev of an EC bdev on a
+	// node. The devices are compared across nodes, so that a remote shard can
+	// share a device with a local one. It is optional: by default a lvol base
+	// bdev is backed by its lvstore, as reported to Update, and any other base
+	// bdev by itself, both on the node only.
+	BaseDevice func(node, baseBdev string) string
+}
+
+// EcRebuildAdmission is the scheduling decision for the rebuild of an EC bdev.
+type EcRebuildAdmission struct {
+	Node   string `json:"node"`
+	EcName string `json:"ec_name"`
+	// RedundancyLeft is the number of base bdevs the EC bdev can still lose,
+	// ParityChunks - FailedCount. The lower it is, the higher the priority.
+	RedundancyLeft int  `json:"redundancy_left"`
+	Running        bool `json:"running"`
+	Admitted       bool `json:"admitted"`
+}
+
+// EcRebuildScheduler decides which EC bdev rebuilds may run when more of them
+// want to than the nodes and the base devices can take. It is shared by the
+// EcSupervisors of the nodes, which report the EC bdevs of their node with
+// Update and start, pause or resume the rebuilds accordingly.
+//
+// The rebuilds are admitted in priority order, the EC bdevs with the least
+// redundancy left first, as long as neither their node nor any of their base
+// devices is at its limit. A rebuild uses all the base devices of its EC bdev,
+// since it reads the surviving slots and writes the replacing ones. A running
+// rebuild is preferred over a pending one of the same priority, and a
+// lower-priority rebuild loses its admission, so it is paused, when a
+// higher-priority one needs its node or base devices.
+type EcRebuildScheduler struct {
+	opts EcRebuildSchedulerOptions
+
+	lock     sync.Mutex
+	nodes    map[string][]spdktypes.BdevEcInfo
+	lvstores map[string]map[string]string
+}
+
+func NewEcRebuildScheduler(opts EcRebuildSchedulerOptions) *EcRebuildScheduler {
+	if opts.MaxRebuildsPerNode <= 0 {
+		opts.MaxRebuildsPerNode = DefaultEcMaxRebuildsPerNode
+	}
+	if opts.MaxRebuildsPerBaseDevice <= 0 {
+		opts.MaxRebuildsPerBaseDevice = DefaultEcMaxRebuildsPerBaseDevice
+	}
+	s := &EcRebuildScheduler{
+		opts:     opts,
+		nodes:    map[string][]spdktypes.BdevEcInfo{},
+		lvstores: map[string]map[string]string{},
+	}
+	if s.opts.BaseDevice == nil {
+		s.opts.BaseDevice = s.lvstoreBaseDevice
+	}
+	return s
+}
+
+// Update replaces the EC bdevs of the node and returns whether the rebuild of
+// each of them is admitted, by name. The EC bdevs without a running or
+// pending rebuild are not in the result. lvstores maps the lvols of the node,
+// by name and alias, to the UUID of their lvstore, as returned by
+// getLvolLvstoreUUIDs. If it is nil, only the base bdevs named by their lvol
+// alias <LVSTORE NAME>/<LVOL NAME> are grouped by lvstore.
+func (s *EcRebuildScheduler) Update(node string, infos []spdktypes.BdevEcInfo, lvstores map[string]string) map[string]bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.nodes[node] = infos
+	s.lvstores[node] = lvstores
+
+	admitted := map[string]bool{}
+	for _, a := range s.plan() {
+		if a.Node == node {
+			admitted[a.EcName] = a.Admitted
+		}
+	}
+	return admitted
+}
+
+// Remove forgets the EC bdevs of the node.
+func (s *EcRebuildScheduler) Remove(node string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	delete(s.nodes, node)
+	delete(s.lvstores, node)
+}
+
+// List returns the decisions for all running and pending rebuilds, in
+// priority order.
+func (s *EcRebuildScheduler) List() []EcRebuildAdmission {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.plan()
+}
+
+type ecRebuildCandidate struct {
+	EcRebuildAdmission
+	devices []string
+}
+
+func (s *EcRebuildScheduler) plan() []EcRebuildAdmission {
+	candidates := []*ecRebuildCandidate{}
+	for node, infos := range s.nodes {
+		for i := range infos {
+			info := &infos[i]
+			if info.Offline || (!info.RebuildInProgress && len(ecReplacingSlots(info)) == 0) {
+				continue
+			}
+
+			c := &ecRebuildCandidate{
+				EcRebuildAdmission: EcRebuildAdmission{
+					Node:           node,
+					EcName:         info.Name,
+					RedundancyLeft: int(info.ParityChunks) - int(info.FailedCount),
+					Running:        info.RebuildInProgress,
+				},
+			}
+			for _, base := range info.BaseBdevs {
+				c.devices = append(c.devices, s.opts.BaseDevice(node, base.Name))
+			}
+			candidates = append(candidates, c)
+		}
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+		if a.RedundancyLeft != b.RedundancyLeft {
+			return a.RedundancyLeft < b.RedundancyLeft
+		}
+		if a.Running != b.Running {
+			return a.Running
+		}
+		if a.Node != b.Node {
+			return a.Node < b.Node
+		}
+		return a.EcName < b.EcName
+	})
+
+	nodeRebuilds := map[string]int{}
+	deviceRebuilds := map[string]int{}
+	admissions := []EcRebuildAdmission{}
+	for _, c := range candidates {
+		c.Admitted = nodeRebuilds[c.Node] < s.opts.MaxRebuildsPerNode
+		for _, device := range c.devices {
+			if deviceRebuilds[device] >= s.opts.MaxRebuildsPerBaseDevice {
+				c.Admitted = false
+			}
+		}
+		if c.Admitted {
+			nodeRebuilds[c.Node]++
+			for _, device := range uniqueEcBaseDevices(c.devices) {
+				deviceRebuilds[device]++
+			}
+		}
+		admissions = append(admissions, c.EcRebuildAdmission)
+	}
+	return admissions
+}
+
+func uniqueEcBaseDevices(devices []string) []string {
+	seen := map[string]bool{}
+	unique := []string{}
+	for _, device := range devices {
+		if !seen[device] {
+			seen[device] = true
+			unique = append(unique, device)
+		}
+	}
+	return unique
+}
+
+// lvstoreBaseDevice is the default BaseDevice, called with the lock held.
+func (s *EcRebuildScheduler) lvstoreBaseDevice(node, baseBdev string) string {
+	if lvstores := s.lvstores[node]; lvstores != nil {
+		if lvsUUID := lvstores[baseBdev]; lvsUUID != "" {
+			return node + ":" + lvsUUID
+		}
+		return node + ":" + baseBdev
+	}
+	if lvsName := spdktypes.GetLvsNameFromAlias(baseBdev); lvsName != "" {
+		return node + ":" + lvsName
+	}
+	return node + ":" + baseBdev
+}
+
+// getLvolLvstoreUUIDs returns the UUID of the lvstore of every lvol, by lvol
+// name and alias, for EcRebuildScheduler.Update.
+func (c *Client) getLvolLvstoreUUIDs() (map[string]string, error) {
+	bdevs, err := c.BdevGetBdevs("", 0)
+	if err != nil {
+		return nil, err
+	}
+	lvstores := map[string]string{}
+	for i := range bdevs {
+		if spdktypes.GetBdevType(&bdevs[i]) != spdktypes.BdevTypeLvol {
+			continue
+		}
+		lvsUUID := bdevs[i].DriverSpecific.Lvol.LvolStoreUUID
+		lvstores[bdevs[i].Name] = lvsUUID
+		for _, alias := range bdevs[i].Aliases {
+			lvstores[alias] = lvsUUID
+		}
+	}
+	return lvstores, nil
+}
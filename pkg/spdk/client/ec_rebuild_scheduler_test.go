@@ -0,0 +1,98 @@
+package client
+
+import (
+	"reflect"
+	"testing"
+
+	spdktypes "github.com/longhorn/go-spdk-helper/pkg/spdk/types"
+)
+
+func testEcInfo(name string, parityChunks, failedCount uint32, running bool, baseBdevs ...string) spdktypes.BdevEcInfo {
+	info := spdktypes.BdevEcInfo{
+		Name:              name,
+		ParityChunks:      parityChunks,
+		FailedCount:       failedCount,
+		RebuildInProgress: running,
+	}
+	for i, baseBdev := range baseBdevs {
+		state := spdktypes.BdevEcSlotStateNormal
+		if uint32(i) < failedCount {
+			state = spdktypes.BdevEcSlotStateReplacing
+		}
+		info.BaseBdevs = append(info.BaseBdevs, spdktypes.EcBaseBdev{Name: baseBdev, Slot: uint32(i), State: state})
+	}
+	return info
+}
+
+func TestEcRebuildSchedulerPriorityAndLimits(t *testing.T) {
+	node1 := []spdktypes.BdevEcInfo{
+		testEcInfo("a", 2, 1, true, "lvs0/a0", "lvs1/a1", "lvs2/a2"),
+		testEcInfo("b", 2, 2, false, "lvs0/b0", "lvs3/b1", "lvs4/b2"),
+		testEcInfo("c", 1, 1, false, "lvs5/c0", "lvs6/c1"),
+		testEcInfo("d", 1, 0, false, "lvs0/d0", "lvs1/d1"),
+		testEcInfo("e", 1, 1, false, "lvs6/e0", "lvs7/e1"),
+	}
+	node2 := []spdktypes.BdevEcInfo{
+		testEcInfo("f", 1, 1, false, "lvs0/f0", "nvme0n1"),
+	}
+
+	s := NewEcRebuildScheduler(EcRebuildSchedulerOptions{MaxRebuildsPerNode: 3})
+	admitted := s.Update("node-1", node1, nil)
+	// b and c have no redundancy left. e shares lvs6 with c. a runs, but is
+	// the fourth one of the node.
+	if want := map[string]bool{"a": false, "b": true, "c": true, "e": false}; !reflect.DeepEqual(admitted, want) {
+		t.Fatalf("got admissions %v, want %v", admitted, want)
+	}
+
+	// lvs0 of node-2 is not lvs0 of node-1.
+	if admitted := s.Update("node-2", node2, nil); !reflect.DeepEqual(admitted, map[string]bool{"f": true}) {
+		t.Fatalf("got admissions %v for node-2", admitted)
+	}
+
+	want := []EcRebuildAdmission{
+		{Node: "node-1", EcName: "b", RedundancyLeft: 0, Admitted: true},
+		{Node: "node-1", EcName: "c", RedundancyLeft: 0, Admitted: true},
+		{Node: "node-1", EcName: "e", RedundancyLeft: 0},
+		{Node: "node-2", EcName: "f", RedundancyLeft: 0, Admitted: true},
+		{Node: "node-1", EcName: "a", RedundancyLeft: 1, Running: true},
+	}
+	if got := s.List(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got plan %+v, want %+v", got, want)
+	}
+
+	// Once c is rebuilt, e can run, while a still waits for lvs0.
+	node1[2] = testEcInfo("c", 1, 0, false, "lvs5/c0", "lvs6/c1")
+	if got, want := s.Update("node-1", node1, nil), map[string]bool{"a": false, "b": true, "e": true}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got admissions %v after the rebuild of c, want %v", got, want)
+	}
+}
+
+func TestEcRebuildSchedulerSharedBaseDevice(t *testing.T) {
+	s := NewEcRebuildScheduler(EcRebuildSchedulerOptions{
+		// The lvstores are named after globally unique disks.
+		BaseDevice: func(node, baseBdev string) string { return spdktypes.GetLvsNameFromAlias(baseBdev) },
+	})
+
+	s.Update("node-1", []spdktypes.BdevEcInfo{testEcInfo("a", 1, 1, true, "disk0/a0", "disk1/a1")}, nil)
+	if admitted := s.Update("node-2", []spdktypes.BdevEcInfo{testEcInfo("b", 1, 1, false, "disk1/b0", "disk2/b1")}, nil); admitted["b"] {
+		t.Fatal("expected the rebuild of b to wait for the one of a on disk1")
+	}
+
+	s.Remove("node-1")
+	if admitted := s.Update("node-2", []spdktypes.BdevEcInfo{testEcInfo("b", 1, 1, false, "disk1/b0", "disk2/b1")}, nil); !admitted["b"] {
+		t.Fatal("expected the rebuild of b to be admitted after node-1 is removed")
+	}
+}
+
+func TestEcRebuildSchedulerLvstoresByUUID(t *testing.T) {
+	s := NewEcRebuildScheduler(EcRebuildSchedulerOptions{})
+	// The base bdevs are named by lvol UUID, b0 and c1 in the same lvstore.
+	lvstores := map[string]string{"uuid-b0": "lvs-uuid-0", "uuid-b1": "lvs-uuid-1", "uuid-c0": "lvs-uuid-2", "uuid-c1": "lvs-uuid-0"}
+	infos := []spdktypes.BdevEcInfo{
+		testEcInfo("b", 1, 1, true, "uuid-b0", "uuid-b1"),
+		testEcInfo("c", 1, 1, false, "uuid-c0", "uuid-c1"),
+	}
+	if got, want := s.Update("node-1", infos, lvstores), map[string]bool{"b": true, "c": false}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got admitted %v, want %v", got, want)
+	}
+}
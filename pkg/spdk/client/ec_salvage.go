@@ -0,0 +1,42 @@
+package client
+
+import (
+	"fmt"
+
+	"github.com/cockroachdb/errors"
+
+	spdktypes "github.com/longhorn/go-spdk-helper/pkg/spdk/types"
+)
+
+// SalvageECBdev recreates an EC bdev whose unmapped bitmap failed to load. It
+// validates the base bdevs, creates the EC bdev with salvage requested, so
+// that SPDK loads the surviving copy of the bitmap rather than zeroing it,
+// and returns the bitmap SPDK loaded, as reported by bdev_ec_get_unmap_status.
+// The EC metadata on the base bdevs is not inspected here: its on-disk layout
+// is private to SPDK.
+func (c *Client) SalvageECBdev(req spdktypes.BdevEcCreateRequest) (*spdktypes.BdevEcUnmapStatus, error) {
+	ecInfo, err := c.getECBdev(req.Name)
+	if err != nil {
+		return nil, err
+	}
+	if ecInfo != nil {
+		return nil, fmt.Errorf("EC bdev %v already exists", req.Name)
+	}
+
+	if err := c.ValidateECCreate(&req, 0); err != nil {
+		return nil, err
+	}
+	if _, err := c.BdevEcCreate(req.Name, req.DataChunks, req.ParityChunks, req.StripSizeKB, req.BaseBdevs, true); err != nil {
+		return nil, errors.Wrapf(err, "failed to create EC bdev %v with salvage", req.Name)
+	}
+
+	status, err := c.BdevEcGetUnmapStatus(req.Name)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get unmap status of salvaged EC bdev %v", req.Name)
+	}
+	if status.UnmappedStripes > status.NumStripes {
+		return &status, fmt.Errorf("salvaged EC bdev %v loaded a bitmap with %v unmapped stripes out of %v",
+			req.Name, status.UnmappedStripes, status.NumStripes)
+	}
+	return &status, nil
+}
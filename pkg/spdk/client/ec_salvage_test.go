@@ -0,0 +1,66 @@
+package client
+
+import (
+	"testing"
+
+	spdktypes "github.com/longhorn/go-spdk-helper/pkg/spdk/types"
+)
+
+// testECSalvageSteps are the steps of a salvage of req, SPDK loading a bitmap
+// with the given unmapped stripes out of 100.
+func testECSalvageSteps(req spdktypes.BdevEcCreateRequest, unmappedStripes int) []jsonRPCScriptStep {
+	steps := []jsonRPCScriptStep{ecGetBdevsStep()}
+	for _, name := range req.BaseBdevs {
+		bdev := spdktypes.BdevInfo{}
+		bdev.Name = name
+		bdev.BlockSize = 4096
+		bdev.NumBlocks = 4096
+		steps = append(steps, jsonRPCScriptStep{method: "bdev_get_bdevs", params: map[string]interface{}{"name": name}, result: []spdktypes.BdevInfo{bdev}})
+	}
+	return append(steps,
+		jsonRPCScriptStep{
+			method: "bdev_ec_create",
+			params: map[string]interface{}{
+				"name":               "ec0",
+				"data_chunk_count":   float64(2),
+				"parity_chunk_count": float64(1),
+				"strip_size_kb":      float64(4),
+				"base_bdevs":         []interface{}{"b0", "b1", "b2"},
+				"salvage_requested":  true,
+			},
+			result: true,
+		},
+		ecNameStep("bdev_ec_get_unmap_status", "ec0", map[string]any{"ec_name": "ec0", "num_stripes": 100, "unmapped_stripes": unmappedStripes, "generation": 9, "active_copy": 1}, nil),
+	)
+}
+
+func TestSalvageECBdev(t *testing.T) {
+	req := spdktypes.BdevEcCreateRequest{Name: "ec0", DataChunks: 2, ParityChunks: 1, StripSizeKB: 4, BaseBdevs: []string{"b0", "b1", "b2"}}
+
+	runJSONRPCScriptTest(t, testECSalvageSteps(req, 10), func(cli *Client) {
+		status, err := cli.SalvageECBdev(req)
+		if err != nil {
+			t.Fatalf("failed to salvage EC bdev: %v", err)
+		}
+		if status.Generation != 9 || status.ActiveCopy != 1 || status.UnmappedStripes != 10 {
+			t.Fatalf("unexpected unmap status %+v", status)
+		}
+	})
+
+	t.Run("inconsistent bitmap", func(t *testing.T) {
+		runJSONRPCScriptTest(t, testECSalvageSteps(req, 101), func(cli *Client) {
+			if _, err := cli.SalvageECBdev(req); err == nil {
+				t.Fatal("SalvageECBdev unexpectedly succeeded")
+			}
+		})
+	})
+
+	t.Run("existing EC bdev", func(t *testing.T) {
+		steps := []jsonRPCScriptStep{ecGetBdevsStep(ecInfoResult("ec0", spdktypes.BdevEcSlotStateNormal, false, 0))}
+		runJSONRPCScriptTest(t, steps, func(cli *Client) {
+			if _, err := cli.SalvageECBdev(req); err == nil {
+				t.Fatal("SalvageECBdev unexpectedly succeeded")
+			}
+		})
+	})
+}
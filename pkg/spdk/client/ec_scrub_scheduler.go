@@ -0,0 +1,294 @@
+package client
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/sirupsen/logrus"
+
+	spdktypes "github.com/longhorn/go-spdk-helper/pkg/spdk/types"
+)
+
+const (
+	DefaultEcScrubCheckInterval     = time.Minute
+	DefaultEcFullScrubInterval      = 7 * 24 * time.Hour
+	DefaultEcScrubMaxConcurrentJobs = 1
+)
+
+// EcScrubWindow is a daily time window in which scrubs may run.
+type EcScrubWindow struct {
+	// Start is the start of the window, as the time since local midnight.
+	Start time.Duration `json:"start"`
+	// Duration is the length of the window. A window may span midnight.
+	Duration time.Duration `json:"duration"`
+}
+
+// Contains reports whether t is in the window on its day or on the previous one.
+func (w EcScrubWindow) Contains(t time.Time) bool {
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	for _, day := range []time.Time{midnight, midnight.AddDate(0, 0, -1)} {
+		start := day.Add(w.Start)
+		if !t.Before(start) && t.Before(start.Add(w.Duration)) {
+			return true
+		}
+	}
+	return false
+}
+
+// EcScrubRecord is the last completed scrubs of an EC bdev.
+type EcScrubRecord struct {
+	// LastScrub is the completion time of the last scrub, dirty-only or full.
+	LastScrub time.Time `json:"last_scrub,omitempty"`
+	// LastFullScrub is the completion time of the last full scrub.
+	LastFullScrub time.Time `json:"last_full_scrub,omitempty"`
+}
+
+type EcScrubSchedulerOptions struct {
+	// Windows are the daily windows in which scrubs run. Running scrubs are
+	// paused outside of them and resumed in the next one. Scrubs run at any
+	// time if there is no window.
+	Windows []EcScrubWindow
+	// FullScrubInterval is the interval between full scrubs of an EC bdev.
+	// DefaultEcFullScrubInterval is used if it is 0.
+	FullScrubInterval time.Duration
+	// MaxConcurrentScrubs is the number of scrubs running at once.
+	// DefaultEcScrubMaxConcurrentJobs is used if it is 0.
+	MaxConcurrentScrubs int
+	// MaxStripesPerSec is the scrub rate limit, 0 meaning unlimited.
+	MaxStripesPerSec uint32
+	// CheckInterval is the interval between checks.
+	// DefaultEcScrubCheckInterval is used if it is 0.
+	CheckInterval time.Duration
+	// Records are the records persisted by the caller, by EC bdev name.
+	Records map[string]EcScrubRecord
+	// OnComplete is called when a scrub completes, with the updated record,
+	// so that the caller can persist it. It is optional and is called with
+	// the scheduler locked, so it must not call the scheduler.
+	OnComplete func(ecName string, record EcScrubRecord)
+}
+
+// EcScrubStatus is the state of the scrubs of an EC bdev.
+type EcScrubStatus struct {
+	Name      string                         `json:"name"`
+	Running   bool                           `json:"running"`
+	DirtyOnly bool                           `json:"dirty_only,omitempty"`
+	Paused    bool                           `json:"paused,omitempty"`
+	Progress  *spdktypes.BdevEcScrubProgress `json:"progress,omitempty"`
+	Record    EcScrubRecord                  `json:"record"`
+}
+
+// EcScrubScheduler runs the scrubs of the EC bdevs of a target in the
+// configured windows. In a window, the healthy EC bdevs with dirty WIB
+// regions are scrubbed first, dirty regions only, and then the ones whose
+// last full scrub is the oldest, once it is older than FullScrubInterval.
+// Degraded, offline and rebuilding EC bdevs are not scrubbed.
+//
+// A scrub the scheduler did not start, such as the startup scrub, is tracked
+// as a dirty-only one.
+type EcScrubScheduler struct {
+	cli  *Client
+	opts EcScrubSchedulerOptions
+	now  func() time.Time
+
+	lock   sync.Mutex
+	scrubs map[string]*EcScrubStatus
+}
+
+func NewEcScrubScheduler(cli *Client, opts EcScrubSchedulerOptions) *EcScrubScheduler {
+	if opts.FullScrubInterval == 0 {
+		opts.FullScrubInterval = DefaultEcFullScrubInterval
+	}
+	if opts.MaxConcurrentScrubs <= 0 {
+		opts.MaxConcurrentScrubs = DefaultEcScrubMaxConcurrentJobs
+	}
+	if opts.CheckInterval == 0 {
+		opts.CheckInterval = DefaultEcScrubCheckInterval
+	}
+
+	scrubs := map[string]*EcScrubStatus{}
+	for name, record := range opts.Records {
+		scrubs[name] = &EcScrubStatus{Name: name, Record: record}
+	}
+	return &EcScrubScheduler{
+		cli:    cli,
+		opts:   opts,
+		now:    time.Now,
+		scrubs: scrubs,
+	}
+}
+
+// Run checks the scrubs every CheckInterval until the context is done.
+func (s *EcScrubScheduler) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.opts.CheckInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := s.Check(); err != nil {
+			logrus.WithError(err).Warn("Failed to check EC scrubs")
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// List returns the scrub status of the EC bdevs, sorted by name.
+func (s *EcScrubScheduler) List() []EcScrubStatus {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	statuses := []EcScrubStatus{}
+	for _, scrub := range s.scrubs {
+		statuses = append(statuses, *scrub)
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Name < statuses[j].Name })
+	return statuses
+}
+
+// Check records the completed scrubs, pauses or resumes the running ones
+// depending on the windows, and starts new ones in a window. The EC bdevs
+// that no longer exist are forgotten, along with their records.
+func (s *EcScrubScheduler) Check() error {
+	infos, err := s.cli.BdevEcGetBdevs("")
+	if err != nil {
+		return errors.Wrap(err, "failed to get EC bdevs")
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name < infos[j].Name })
+
+	now := s.now()
+	inWindow := len(s.opts.Windows) == 0
+	for _, w := range s.opts.Windows {
+		inWindow = inWindow || w.Contains(now)
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	exists := map[string]bool{}
+	for i := range infos {
+		exists[infos[i].Name] = true
+	}
+	for name := range s.scrubs {
+		if !exists[name] {
+			delete(s.scrubs, name)
+		}
+	}
+
+	running := 0
+	for i := range infos {
+		scrub := s.scrubs[infos[i].Name]
+		if scrub == nil {
+			scrub = &EcScrubStatus{Name: infos[i].Name}
+			s.scrubs[scrub.Name] = scrub
+		}
+		if err := s.checkRunningScrub(scrub, &infos[i], now, inWindow); err != nil {
+			logrus.WithError(err).Warnf("Failed to check the scrub of EC bdev %v", scrub.Name)
+		}
+		if scrub.Running {
+			running++
+		}
+	}
+	if !inWindow || running >= s.opts.MaxConcurrentScrubs {
+		return nil
+	}
+
+	type candidate struct {
+		scrub        *EcScrubStatus
+		dirtyRegions uint32
+	}
+	candidates := []candidate{}
+	for i := range infos {
+		info := &infos[i]
+		scrub := s.scrubs[info.Name]
+		if scrub.Running || !ecScrubbable(info) {
+			continue
+		}
+		wib, err := s.cli.BdevEcGetWibStatus(info.Name)
+		if err != nil {
+			logrus.WithError(err).Warnf("Failed to get the WIB status of EC bdev %v", info.Name)
+			continue
+		}
+		fullScrubDue := now.Sub(scrub.Record.LastFullScrub) >= s.opts.FullScrubInterval
+		if wib.DirtyRegions > 0 || fullScrubDue {
+			candidates = append(candidates, candidate{scrub: scrub, dirtyRegions: wib.DirtyRegions})
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+		if a.dirtyRegions != b.dirtyRegions {
+			return a.dirtyRegions > b.dirtyRegions
+		}
+		return a.scrub.Record.LastFullScrub.Before(b.scrub.Record.LastFullScrub)
+	})
+
+	for _, c := range candidates {
+		if running >= s.opts.MaxConcurrentScrubs {
+			break
+		}
+		dirtyOnly := c.dirtyRegions > 0
+		if _, err := s.cli.BdevEcStartScrub(c.scrub.Name, dirtyOnly); err != nil {
+			logrus.WithError(err).Warnf("Failed to start the scrub of EC bdev %v", c.scrub.Name)
+			continue
+		}
+		if s.opts.MaxStripesPerSec != 0 {
+			if _, err := s.cli.BdevEcSetScrubQos(c.scrub.Name, s.opts.MaxStripesPerSec, false); err != nil {
+				logrus.WithError(err).Warnf("Failed to set the scrub QoS of EC bdev %v", c.scrub.Name)
+			}
+		}
+		c.scrub.Running = true
+		c.scrub.DirtyOnly = dirtyOnly
+		running++
+	}
+	return nil
+}
+
+// checkRunningScrub records the completion of the scrub of an EC bdev, or
+// pauses or resumes it. SPDK reports no progress once a scrub is over,
+// whether it completed or was aborted, so a scrub that is gone is recorded as
+// completed unless it could not have run to the end: it was paused since the
+// last check, or the EC bdev is no longer healthy, which aborts it.
+func (s *EcScrubScheduler) checkRunningScrub(scrub *EcScrubStatus, info *spdktypes.BdevEcInfo, now time.Time, inWindow bool) error {
+	progress, err := s.cli.BdevEcGetScrubProgress(scrub.Name)
+	if err != nil {
+		return err
+	}
+	scrub.Progress = progress
+
+	if progress == nil {
+		if scrub.Running && !scrub.Paused && ecScrubbable(info) {
+			scrub.Record.LastScrub = now
+			if !scrub.DirtyOnly {
+				scrub.Record.LastFullScrub = now
+			}
+			if s.opts.OnComplete != nil {
+				s.opts.OnComplete(scrub.Name, scrub.Record)
+			}
+		}
+		scrub.Running, scrub.DirtyOnly, scrub.Paused = false, false, false
+		return nil
+	}
+
+	if !scrub.Running {
+		scrub.Running = true
+		scrub.DirtyOnly = true
+	}
+	if inWindow == !scrub.Paused {
+		return nil
+	}
+	if _, err := s.cli.BdevEcSetScrubQos(scrub.Name, s.opts.MaxStripesPerSec, !inWindow); err != nil {
+		return err
+	}
+	scrub.Paused = !inWindow
+	return nil
+}
+
+// ecScrubbable reports whether an EC bdev is healthy enough to be scrubbed.
+func ecScrubbable(info *spdktypes.BdevEcInfo) bool {
+	return info.State == spdktypes.BdevEcStateOnline && !info.RebuildInProgress
+}
@@ -0,0 +1,201 @@
+package client
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/longhorn/go-spdk-helper/pkg/jsonrpc"
+	spdktypes "github.com/longhorn/go-spdk-helper/pkg/spdk/types"
+)
+
+func TestEcScrubWindowContains(t *testing.T) {
+	day := func(hour, min int) time.Time { return time.Date(2026, 3, 10, hour, min, 0, 0, time.UTC) }
+	w := EcScrubWindow{Start: 23 * time.Hour, Duration: 2 * time.Hour}
+
+	for _, tc := range []struct {
+		t    time.Time
+		want bool
+	}{
+		{day(22, 59), false},
+		{day(23, 0), true},
+		{day(0, 30), true},
+		{day(1, 0), false},
+	} {
+		if got := w.Contains(tc.t); got != tc.want {
+			t.Fatalf("window contains %v: got %v, want %v", tc.t, got, tc.want)
+		}
+	}
+}
+
+func TestEcScrubScheduler(t *testing.T) {
+	noScrub := &jsonrpc.ResponseError{Code: jsonrpc.RespErrorCodeNoEntry, Message: "No such file or directory"}
+	infos := []map[string]any{
+		ecInfoResult("ec0", spdktypes.BdevEcSlotStateNormal, false, 0),
+		ecInfoResult("ec1", spdktypes.BdevEcSlotStateNormal, false, 0),
+		ecInfoResult("ec2", spdktypes.BdevEcSlotStateFailed, false, 0),
+	}
+	// progress is the scrub progress of an EC bdev, none if percent is negative.
+	progress := func(ecName string, percent int) jsonRPCScriptStep {
+		if percent < 0 {
+			return ecNameStep("bdev_ec_get_scrub_progress", ecName, nil, noScrub)
+		}
+		return ecNameStep("bdev_ec_get_scrub_progress", ecName, map[string]any{"ec_name": ecName, "percent_complete": percent}, nil)
+	}
+	// check lists the EC bdevs and gets their scrub progress, ec1 being
+	// paused or resumed right after its own.
+	check := func(ec0Percent, ec1Percent int, ec1Qos ...jsonRPCScriptStep) []jsonRPCScriptStep {
+		steps := []jsonRPCScriptStep{ecGetBdevsStep(infos...), progress("ec0", ec0Percent), progress("ec1", ec1Percent)}
+		steps = append(steps, ec1Qos...)
+		return append(steps, progress("ec2", -1))
+	}
+	wib := func(ecName string, dirtyRegions int) jsonRPCScriptStep {
+		return ecNameStep("bdev_ec_get_wib_status", ecName, map[string]any{"ec_name": ecName, "num_regions": 16, "dirty_regions": dirtyRegions}, nil)
+	}
+	scrubQos := func(ecName string, paused bool) jsonRPCScriptStep {
+		return jsonRPCScriptStep{
+			method: "bdev_ec_set_scrub_qos",
+			params: map[string]interface{}{"ec_name": ecName, "max_stripes_per_sec": float64(0), "paused": paused},
+			result: true,
+		}
+	}
+	startScrub := func(ecName string, dirtyOnly bool) jsonRPCScriptStep {
+		params := map[string]interface{}{"ec_name": ecName}
+		if dirtyOnly {
+			params["dirty_only"] = true
+		}
+		return jsonRPCScriptStep{method: "bdev_ec_start_scrub", params: params, result: map[string]any{"ec_name": ecName}}
+	}
+
+	join := func(groups ...[]jsonRPCScriptStep) []jsonRPCScriptStep {
+		steps := []jsonRPCScriptStep{}
+		for _, g := range groups {
+			steps = append(steps, g...)
+		}
+		return steps
+	}
+	steps := join(
+		// 00:30, out of the window: the startup scrub of ec1 is paused.
+		check(-1, 50, scrubQos("ec1", true)),
+		// 01:30, in the window: it is resumed.
+		check(-1, 60, scrubQos("ec1", false)),
+		// 02:00: it completes, and the dirty regions of ec1 go first.
+		check(-1, -1), []jsonRPCScriptStep{wib("ec0", 0), wib("ec1", 3), startScrub("ec1", true)},
+		// 02:10: it completes too, then the full scrub of ec0.
+		check(-1, -1), []jsonRPCScriptStep{wib("ec0", 0), wib("ec1", 0), startScrub("ec0", false)},
+		// 02:20: the full scrub of ec0 is running.
+		check(70, -1),
+		// 03:10, out of the window: it completed, and ec2 was deleted.
+		[]jsonRPCScriptStep{ecGetBdevsStep(infos[:2]...), progress("ec0", -1), progress("ec1", -1)},
+	)
+
+	now := time.Date(2026, 3, 10, 0, 30, 0, 0, time.UTC)
+	completed := map[string]EcScrubRecord{}
+	runJSONRPCScriptTest(t, steps, func(cli *Client) {
+		s := NewEcScrubScheduler(cli, EcScrubSchedulerOptions{
+			Windows: []EcScrubWindow{{Start: time.Hour, Duration: 2 * time.Hour}},
+			Records: map[string]EcScrubRecord{
+				"ec1": {LastFullScrub: now.Add(-24 * time.Hour)},
+			},
+			OnComplete: func(ecName string, record EcScrubRecord) { completed[ecName] = record },
+		})
+		s.now = func() time.Time { return now }
+
+		for _, advance := range []time.Duration{0, 60, 30, 10, 10, 50} {
+			now = now.Add(advance * time.Minute)
+			if err := s.Check(); err != nil {
+				t.Errorf("failed to check scrubs: %v", err)
+				return
+			}
+		}
+		names := []string{}
+		for _, status := range s.List() {
+			if status.Running {
+				t.Fatalf("unexpected running scrub %+v", status)
+			}
+			names = append(names, status.Name)
+		}
+		if !reflect.DeepEqual(names, []string{"ec0", "ec1"}) {
+			t.Fatalf("got scrubs of EC bdevs %v, want ec0 and ec1", names)
+		}
+	})
+
+	want := map[string]EcScrubRecord{
+		"ec0": {
+			LastScrub:     time.Date(2026, 3, 10, 3, 10, 0, 0, time.UTC),
+			LastFullScrub: time.Date(2026, 3, 10, 3, 10, 0, 0, time.UTC),
+		},
+		"ec1": {
+			LastScrub:     time.Date(2026, 3, 10, 2, 10, 0, 0, time.UTC),
+			LastFullScrub: time.Date(2026, 3, 9, 0, 30, 0, 0, time.UTC),
+		},
+	}
+	if !reflect.DeepEqual(completed, want) {
+		t.Fatalf("got records %+v, want %+v", completed, want)
+	}
+}
+
+func TestEcScrubSchedulerAbortedScrub(t *testing.T) {
+	noScrub := &jsonrpc.ResponseError{Code: jsonrpc.RespErrorCodeNoEntry, Message: "No such file or directory"}
+	healthy := ecInfoResult("ec1", spdktypes.BdevEcSlotStateNormal, false, 0)
+	degraded := ecInfoResult("ec1", spdktypes.BdevEcSlotStateFailed, false, 0)
+	progress := func(ecName string, percent int) jsonRPCScriptStep {
+		if percent < 0 {
+			return ecNameStep("bdev_ec_get_scrub_progress", ecName, nil, noScrub)
+		}
+		return ecNameStep("bdev_ec_get_scrub_progress", ecName, map[string]any{"ec_name": ecName, "percent_complete": percent}, nil)
+	}
+	ec0 := ecInfoResult("ec0", spdktypes.BdevEcSlotStateNormal, false, 0)
+
+	steps := []jsonRPCScriptStep{
+		// 01:30, in the window: the startup scrubs of both EC bdevs run.
+		ecGetBdevsStep(ec0, healthy),
+		progress("ec0", 50),
+		progress("ec1", 50),
+		// 01:40: the one of ec0 completes, the one of ec1 is gone with ec1
+		// degraded, so it is not recorded. The full scrub of ec0 starts.
+		ecGetBdevsStep(ec0, degraded),
+		progress("ec0", -1),
+		progress("ec1", -1),
+		ecNameStep("bdev_ec_get_wib_status", "ec0", map[string]any{"ec_name": "ec0", "num_regions": 16}, nil),
+		{method: "bdev_ec_start_scrub", params: map[string]interface{}{"ec_name": "ec0"}, result: map[string]any{"ec_name": "ec0"}},
+		// 03:30, out of the window: it is paused.
+		ecGetBdevsStep(ec0, degraded),
+		progress("ec0", 80),
+		{
+			method: "bdev_ec_set_scrub_qos",
+			params: map[string]interface{}{"ec_name": "ec0", "max_stripes_per_sec": float64(0), "paused": true},
+			result: true,
+		},
+		progress("ec1", -1),
+		// 03:40: it is gone while paused, so it is not recorded.
+		ecGetBdevsStep(ec0, degraded),
+		progress("ec0", -1),
+		progress("ec1", -1),
+	}
+
+	now := time.Date(2026, 3, 10, 1, 30, 0, 0, time.UTC)
+	completed := map[string]EcScrubRecord{}
+	runJSONRPCScriptTest(t, steps, func(cli *Client) {
+		s := NewEcScrubScheduler(cli, EcScrubSchedulerOptions{
+			Windows:    []EcScrubWindow{{Start: time.Hour, Duration: 2 * time.Hour}},
+			OnComplete: func(ecName string, record EcScrubRecord) { completed[ecName] = record },
+		})
+		s.now = func() time.Time { return now }
+
+		for _, advance := range []time.Duration{0, 10, 110, 10} {
+			now = now.Add(advance * time.Minute)
+			if err := s.Check(); err != nil {
+				t.Errorf("failed to check scrubs: %v", err)
+				return
+			}
+		}
+	})
+
+	want := map[string]EcScrubRecord{
+		"ec0": {LastScrub: time.Date(2026, 3, 10, 1, 40, 0, 0, time.UTC)},
+	}
+	if !reflect.DeepEqual(completed, want) {
+		t.Fatalf("got records %+v, want %+v", completed, want)
+	}
+}
@@ -0,0 +1,401 @@
+package client
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/sirupsen/logrus"
+
+	spdktypes "github.com/longhorn/go-spdk-helper/pkg/spdk/types"
+)
+
+const (
+	DefaultEcSupervisorCheckInterval = 5 * time.Second
+	DefaultEcRebuildRetryBackoff     = 10 * time.Second
+	DefaultEcRebuildMaxRetryBackoff  = 5 * time.Minute
+)
+
+type EcEventType string
+
+const (
+	EcEventTypeStateChanged     = EcEventType("state-changed")
+	EcEventTypeRemoved          = EcEventType("removed")
+	EcEventTypeRebuildStarted   = EcEventType("rebuild-started")
+	EcEventTypeRebuildCompleted = EcEventType("rebuild-completed")
+	EcEventTypeRebuildFailed    = EcEventType("rebuild-failed")
+	EcEventTypeRebuildPaused    = EcEventType("rebuild-paused")
+	EcEventTypeRebuildResumed   = EcEventType("rebuild-resumed")
+	EcEventTypeRebuildQosSet    = EcEventType("rebuild-qos-set")
+)
+
+// EcEvent is emitted by the EcSupervisor when it observes or acts on an EC bdev.
+type EcEvent struct {
+	Type   EcEventType `json:"type"`
+	EcName string      `json:"ec_name"`
+	Time   time.Time   `json:"time"`
+
+	// OldState and NewState are set for EcEventTypeStateChanged. OldState is
+	// empty for an EC bdev seen for the first time.
+	OldState spdktypes.BdevEcState `json:"old_state,omitempty"`
+	NewState spdktypes.BdevEcState `json:"new_state,omitempty"`
+	// Slots are the replacing slots being rebuilt.
+	Slots []uint32 `json:"slots,omitempty"`
+	// MaxStripesPerSec is set for EcEventTypeRebuildQosSet, 0 meaning unlimited.
+	MaxStripesPerSec uint32 `json:"max_stripes_per_sec,omitempty"`
+	// Attempts counts the consecutive failed rebuild attempts.
+	Attempts int    `json:"attempts,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// EcRebuildQosPolicy sets the rebuild rate limit of an EC bdev from its
+// foreground write load, measured as the RMW and full-stripe writes per second
+// between two polls.
+type EcRebuildQosPolicy struct {
+	// BusyWriteStripesPerSec is the foreground write rate at or above which
+	// the EC bdev is busy. The rebuild QoS is not managed if it is 0.
+	BusyWriteStripesPerSec uint64
+	// BusyMaxStripesPerSec and IdleMaxStripesPerSec are the rebuild rate
+	// limits applied when the EC bdev is busy and idle. 0 means unlimited.
+	BusyMaxStripesPerSec uint32
+	IdleMaxStripesPerSec uint32
+}
+
+type EcSupervisorOptions struct {
+	// CheckInterval is the interval between polls.
+	// DefaultEcSupervisorCheckInterval is used if it is 0.
+	CheckInterval time.Duration
+	// RetryBackoff is the delay before retrying a failed rebuild, doubled on
+	// every consecutive failure up to MaxRetryBackoff.
+	// DefaultEcRebuildRetryBackoff and DefaultEcRebuildMaxRetryBackoff are
+	// used if they are 0.
+	RetryBackoff    time.Duration
+	MaxRetryBackoff time.Duration
+	// RebuildQos is the policy applied to the running rebuilds.
+	RebuildQos EcRebuildQosPolicy
+	// Scheduler, if set, admits the rebuilds of the EC bdevs, which are
+	// reported to it as the ones of Node. The rebuilds that are not admitted
+	// are not started, or are paused if they are running.
+	Scheduler *EcRebuildScheduler
+	Node      string
+	// OnEvent is called for every event, from the goroutine running the
+	// poll. It is optional.
+	OnEvent func(EcEvent)
+}
+
+// EcArrayStatus is the state of an EC bdev as tracked by the EcSupervisor.
+type EcArrayStatus struct {
+	Name           string                `json:"name"`
+	State          spdktypes.BdevEcState `json:"state"`
+	ReplacingSlots []uint32              `json:"replacing_slots,omitempty"`
+	Rebuilding     bool                  `json:"rebuilding"`
+	// RebuildQueued and RebuildPaused report that the rebuild is waiting for
+	// the admission of the scheduler, before starting or while running.
+	RebuildQueued bool `json:"rebuild_queued,omitempty"`
+	RebuildPaused bool `json:"rebuild_paused,omitempty"`
+	// RebuildProgress is the last progress of the running rebuild.
+	RebuildProgress *spdktypes.BdevEcRebuildProgress `json:"rebuild_progress,omitempty"`
+	// RebuildAttempts counts the consecutive failed rebuild attempts, and
+	// NextRebuildRetry is when the next one may start.
+	RebuildAttempts  int       `json:"rebuild_attempts,omitempty"`
+	NextRebuildRetry time.Time `json:"next_rebuild_retry,omitempty"`
+	LastRebuildError string    `json:"last_rebuild_error,omitempty"`
+	// RebuildQosApplied reports whether MaxStripesPerSec was applied to the
+	// running rebuild.
+	RebuildQosApplied bool   `json:"rebuild_qos_applied"`
+	MaxStripesPerSec  uint32 `json:"max_stripes_per_sec"`
+}
+
+type ecArray struct {
+	EcArrayStatus
+
+	// writeStripes and sampledAt are the foreground write counters of the
+	// last poll, for the load computation.
+	writeStripes uint64
+	sampledAt    time.Time
+}
+
+// EcSupervisor polls the EC bdevs of a target and keeps them healthy: it
+// reports state transitions, starts the rebuild of replaced slots, retries
+// failed rebuilds with an exponential backoff and adjusts the rebuild QoS to
+// the foreground load.
+//
+// A rebuild is considered failed when BdevEcGetRebuildProgress fails, or when
+// it is no longer running while slots are still replacing. A rebuild started
+// by someone else is tracked like one started by the supervisor.
+type EcSupervisor struct {
+	cli  *Client
+	opts EcSupervisorOptions
+	now  func() time.Time
+
+	lock   sync.Mutex
+	arrays map[string]*ecArray
+}
+
+func NewEcSupervisor(cli *Client, opts EcSupervisorOptions) *EcSupervisor {
+	if opts.CheckInterval == 0 {
+		opts.CheckInterval = DefaultEcSupervisorCheckInterval
+	}
+	if opts.RetryBackoff == 0 {
+		opts.RetryBackoff = DefaultEcRebuildRetryBackoff
+	}
+	if opts.MaxRetryBackoff == 0 {
+		opts.MaxRetryBackoff = DefaultEcRebuildMaxRetryBackoff
+	}
+	return &EcSupervisor{
+		cli:    cli,
+		opts:   opts,
+		now:    time.Now,
+		arrays: map[string]*ecArray{},
+	}
+}
+
+// Run polls the EC bdevs every CheckInterval until the context is done.
+func (s *EcSupervisor) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.opts.CheckInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := s.Check(); err != nil {
+			logrus.WithError(err).Warn("Failed to check EC bdevs")
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Check polls the EC bdevs once and acts on them. The errors of the actions
+// on a single EC bdev are reported as events, not returned.
+func (s *EcSupervisor) Check() error {
+	infos, err := s.cli.BdevEcGetBdevs("")
+	if err != nil {
+		return errors.Wrap(err, "failed to get EC bdevs")
+	}
+
+	events := []EcEvent{}
+	emit := func(event EcEvent) {
+		event.Time = s.now()
+		events = append(events, event)
+	}
+
+	var admitted map[string]bool
+	if s.opts.Scheduler != nil {
+		lvstores, err := s.cli.getLvolLvstoreUUIDs()
+		if err != nil {
+			return errors.Wrap(err, "failed to get the lvstores of the EC base bdevs")
+		}
+		admitted = s.opts.Scheduler.Update(s.opts.Node, infos, lvstores)
+	}
+
+	s.lock.Lock()
+	seen := map[string]bool{}
+	for i := range infos {
+		info := &infos[i]
+		seen[info.Name] = true
+
+		array, ok := s.arrays[info.Name]
+		if !ok {
+			array = &ecArray{EcArrayStatus: EcArrayStatus{Name: info.Name}}
+			s.arrays[info.Name] = array
+		}
+		s.checkArray(array, info, admitted == nil || admitted[info.Name], emit)
+	}
+	for name := range s.arrays {
+		if !seen[name] {
+			delete(s.arrays, name)
+			emit(EcEvent{Type: EcEventTypeRemoved, EcName: name})
+		}
+	}
+	s.lock.Unlock()
+
+	if s.opts.OnEvent != nil {
+		for _, event := range events {
+			s.opts.OnEvent(event)
+		}
+	}
+	return nil
+}
+
+// List returns the status of the EC bdevs seen by the last poll.
+func (s *EcSupervisor) List() []EcArrayStatus {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	statuses := []EcArrayStatus{}
+	for _, array := range s.arrays {
+		statuses = append(statuses, array.EcArrayStatus)
+	}
+	return statuses
+}
+
+// Get returns the status of an EC bdev, or false if it was not seen by the
+// last poll.
+func (s *EcSupervisor) Get(name string) (EcArrayStatus, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	array, ok := s.arrays[name]
+	if !ok {
+		return EcArrayStatus{}, false
+	}
+	return array.EcArrayStatus, true
+}
+
+func (s *EcSupervisor) checkArray(array *ecArray, info *spdktypes.BdevEcInfo, admitted bool, emit func(EcEvent)) {
+	if array.State != info.State {
+		emit(EcEvent{Type: EcEventTypeStateChanged, EcName: info.Name, OldState: array.State, NewState: info.State})
+		array.State = info.State
+	}
+
+	array.ReplacingSlots = ecReplacingSlots(info)
+	array.RebuildQueued = false
+	writeStripes, sampledAt := info.RmwTotal+info.FullStripeWrites, s.now()
+	defer func() {
+		array.writeStripes, array.sampledAt = writeStripes, sampledAt
+	}()
+
+	if info.Offline {
+		return
+	}
+
+	if info.RebuildInProgress {
+		if !array.Rebuilding {
+			array.Rebuilding = true
+			emit(EcEvent{Type: EcEventTypeRebuildStarted, EcName: info.Name, Slots: array.ReplacingSlots})
+		}
+
+		// SPDK still reports the rebuild in progress, so it keeps running
+		// whatever the error, and the next poll tries again.
+		progress, err := s.cli.BdevEcGetRebuildProgress(info.Name)
+		if err != nil {
+			logrus.WithError(err).Warnf("Failed to get the rebuild progress of EC bdev %v", info.Name)
+			return
+		}
+		array.RebuildProgress = &progress
+
+		if !s.admitRebuild(array, admitted, emit) {
+			return
+		}
+		s.applyRebuildQos(array, writeStripes, sampledAt, emit)
+		return
+	}
+
+	if array.Rebuilding {
+		array.Rebuilding = false
+		array.RebuildPaused = false
+		array.RebuildProgress = nil
+		array.RebuildQosApplied = false
+		if len(array.ReplacingSlots) > 0 {
+			s.rebuildFailed(array, errors.New("rebuild stopped with replacing slots left"), emit)
+		} else {
+			array.RebuildAttempts = 0
+			array.NextRebuildRetry = time.Time{}
+			array.LastRebuildError = ""
+			emit(EcEvent{Type: EcEventTypeRebuildCompleted, EcName: info.Name})
+		}
+	}
+
+	if len(array.ReplacingSlots) == 0 || sampledAt.Before(array.NextRebuildRetry) {
+		return
+	}
+	if !admitted {
+		array.RebuildQueued = true
+		return
+	}
+	if _, err := s.cli.BdevEcStartRebuild(info.Name); err != nil {
+		s.rebuildFailed(array, err, emit)
+		return
+	}
+	array.Rebuilding = true
+	emit(EcEvent{Type: EcEventTypeRebuildStarted, EcName: info.Name, Slots: array.ReplacingSlots})
+}
+
+// rebuildFailed records a failed rebuild attempt and schedules the retry.
+func (s *EcSupervisor) rebuildFailed(array *ecArray, err error, emit func(EcEvent)) {
+	array.Rebuilding = false
+	array.RebuildPaused = false
+	array.RebuildProgress = nil
+	array.RebuildQosApplied = false
+	array.RebuildAttempts++
+	array.LastRebuildError = err.Error()
+
+	backoff := s.opts.RetryBackoff
+	for i := 1; i < array.RebuildAttempts && backoff < s.opts.MaxRetryBackoff; i++ {
+		backoff *= 2
+	}
+	backoff = min(backoff, s.opts.MaxRetryBackoff)
+	array.NextRebuildRetry = s.now().Add(backoff)
+
+	emit(EcEvent{
+		Type:     EcEventTypeRebuildFailed,
+		EcName:   array.Name,
+		Slots:    array.ReplacingSlots,
+		Attempts: array.RebuildAttempts,
+		Error:    array.LastRebuildError,
+	})
+}
+
+// admitRebuild pauses the running rebuild if it is not admitted, or resumes
+// it with the last applied rate limit if it is, and returns whether it runs.
+func (s *EcSupervisor) admitRebuild(array *ecArray, admitted bool, emit func(EcEvent)) bool {
+	if admitted == !array.RebuildPaused {
+		return admitted
+	}
+
+	if _, err := s.cli.BdevEcSetRebuildQos(array.Name, array.MaxStripesPerSec, !admitted); err != nil {
+		logrus.WithError(err).Warnf("Failed to pause or resume the rebuild of EC bdev %v", array.Name)
+		return !array.RebuildPaused
+	}
+	array.RebuildPaused = !admitted
+	if array.RebuildPaused {
+		emit(EcEvent{Type: EcEventTypeRebuildPaused, EcName: array.Name})
+	} else {
+		emit(EcEvent{Type: EcEventTypeRebuildResumed, EcName: array.Name})
+	}
+	return admitted
+}
+
+// applyRebuildQos sets the rebuild rate limit from the foreground write rate
+// since the last poll, if it differs from the applied one.
+func (s *EcSupervisor) applyRebuildQos(array *ecArray, writeStripes uint64, sampledAt time.Time, emit func(EcEvent)) {
+	policy := s.opts.RebuildQos
+	if policy.BusyWriteStripesPerSec == 0 || array.sampledAt.IsZero() || !sampledAt.After(array.sampledAt) {
+		return
+	}
+
+	var rate uint64
+	if writeStripes > array.writeStripes {
+		rate = uint64(float64(writeStripes-array.writeStripes) / sampledAt.Sub(array.sampledAt).Seconds())
+	}
+	maxStripesPerSec := policy.IdleMaxStripesPerSec
+	if rate >= policy.BusyWriteStripesPerSec {
+		maxStripesPerSec = policy.BusyMaxStripesPerSec
+	}
+	if array.RebuildQosApplied && array.MaxStripesPerSec == maxStripesPerSec {
+		return
+	}
+
+	if _, err := s.cli.BdevEcSetRebuildQos(array.Name, maxStripesPerSec, false); err != nil {
+		logrus.WithError(err).Warnf("Failed to set the rebuild QoS of EC bdev %v", array.Name)
+		return
+	}
+	array.RebuildQosApplied = true
+	array.MaxStripesPerSec = maxStripesPerSec
+	emit(EcEvent{Type: EcEventTypeRebuildQosSet, EcName: array.Name, MaxStripesPerSec: maxStripesPerSec})
+}
+
+// ecReplacingSlots returns the slots of the EC bdev waiting for a rebuild.
+func ecReplacingSlots(info *spdktypes.BdevEcInfo) []uint32 {
+	slots := []uint32{}
+	for _, base := range info.BaseBdevs {
+		if base.State == spdktypes.BdevEcSlotStateReplacing || base.NeedsRebuild {
+			slots = append(slots, base.Slot)
+		}
+	}
+	return slots
+}
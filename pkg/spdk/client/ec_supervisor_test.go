@@ -0,0 +1,256 @@
+package client
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/longhorn/go-spdk-helper/pkg/jsonrpc"
+	spdktypes "github.com/longhorn/go-spdk-helper/pkg/spdk/types"
+)
+
+// ecInfoResult is a bdev_ec_get_bdevs element of a 2+1 EC bdev whose slot 2
+// is failed, replacing, or normal.
+func ecInfoResult(name string, slot2 spdktypes.BdevEcSlotState, rebuilding bool, writeStripes uint64) map[string]any {
+	failedCount := 0
+	if slot2 != spdktypes.BdevEcSlotStateNormal {
+		failedCount = 1
+	}
+	return map[string]any{
+		"name":                name,
+		"k":                   2,
+		"m":                   1,
+		"n":                   3,
+		"failed_count":        failedCount,
+		"rebuild_in_progress": rebuilding,
+		"rmw_total":           writeStripes / 2,
+		"full_stripe_writes":  writeStripes - writeStripes/2,
+		"base_bdevs": []map[string]any{
+			{"name": "b0", "slot": 0, "role": "data", "state": "normal"},
+			{"name": "b1", "slot": 1, "role": "data", "state": "normal"},
+			{"name": "b2", "slot": 2, "role": "parity", "state": string(slot2)},
+		},
+	}
+}
+
+func ecGetBdevsStep(infos ...map[string]any) jsonRPCScriptStep {
+	return jsonRPCScriptStep{method: "bdev_ec_get_bdevs", result: infos}
+}
+
+func ecNameStep(method, ecName string, result any, responseError *jsonrpc.ResponseError) jsonRPCScriptStep {
+	return jsonRPCScriptStep{
+		method:        method,
+		params:        map[string]interface{}{"ec_name": ecName},
+		result:        result,
+		responseError: responseError,
+	}
+}
+
+func ecSetRebuildQosStep(ecName string, maxStripesPerSec uint32, paused bool) jsonRPCScriptStep {
+	return jsonRPCScriptStep{
+		method: "bdev_ec_set_rebuild_qos",
+		params: map[string]interface{}{
+			"ec_name":             ecName,
+			"max_stripes_per_sec": float64(maxStripesPerSec),
+			"paused":              paused,
+		},
+		result: true,
+	}
+}
+
+func TestEcSupervisorRebuildLifecycle(t *testing.T) {
+	replacing := spdktypes.BdevEcSlotStateReplacing
+	startErr := &jsonrpc.ResponseError{Code: jsonrpc.RespErrorCodeDeviceOrResourceBusy, Message: "Device or resource busy"}
+	started := map[string]any{"ec_name": "ec0", "num_stripes": 100, "first_slot": 2}
+
+	steps := []jsonRPCScriptStep{
+		// Replaced slot: the rebuild is started.
+		ecGetBdevsStep(ecInfoResult("ec0", replacing, false, 0)),
+		ecNameStep("bdev_ec_start_rebuild", "ec0", started, nil),
+		// Busy: the rebuild is throttled.
+		ecGetBdevsStep(ecInfoResult("ec0", replacing, true, 500)),
+		ecNameStep("bdev_ec_get_rebuild_progress", "ec0", map[string]any{"ec_name": "ec0", "percent_complete": 40}, nil),
+		ecSetRebuildQosStep("ec0", 50, false),
+		// Idle: the rebuild is unthrottled.
+		ecGetBdevsStep(ecInfoResult("ec0", replacing, true, 500)),
+		ecNameStep("bdev_ec_get_rebuild_progress", "ec0", map[string]any{"ec_name": "ec0", "percent_complete": 60}, nil),
+		ecSetRebuildQosStep("ec0", 0, false),
+		// The rebuild stopped with the slot still replacing.
+		ecGetBdevsStep(ecInfoResult("ec0", replacing, false, 500)),
+		// Backing off.
+		ecGetBdevsStep(ecInfoResult("ec0", replacing, false, 500)),
+		// The first retry fails, the second one starts.
+		ecGetBdevsStep(ecInfoResult("ec0", replacing, false, 500)),
+		ecNameStep("bdev_ec_start_rebuild", "ec0", nil, startErr),
+		ecGetBdevsStep(ecInfoResult("ec0", replacing, false, 500)),
+		ecNameStep("bdev_ec_start_rebuild", "ec0", started, nil),
+		// Rebuilt.
+		ecGetBdevsStep(ecInfoResult("ec0", spdktypes.BdevEcSlotStateNormal, false, 500)),
+		// Deleted.
+		ecGetBdevsStep(),
+	}
+
+	events := []EcEvent{}
+	now := time.Unix(1000, 0)
+	runJSONRPCScriptTest(t, steps, func(cli *Client) {
+		s := NewEcSupervisor(cli, EcSupervisorOptions{
+			RetryBackoff:    10 * time.Second,
+			MaxRetryBackoff: time.Minute,
+			RebuildQos: EcRebuildQosPolicy{
+				BusyWriteStripesPerSec: 100,
+				BusyMaxStripesPerSec:   50,
+			},
+			OnEvent: func(event EcEvent) {
+				event.Time = time.Time{}
+				// Drop the request details, which hold a random message id.
+				if strings.HasSuffix(event.Error, startErr.Error()) {
+					event.Error = startErr.Error()
+				}
+				events = append(events, event)
+			},
+		})
+		s.now = func() time.Time { return now }
+
+		for _, advance := range []time.Duration{0, 1, 1, 1, 1, 10, 20, 1, 1} {
+			now = now.Add(advance * time.Second)
+			if err := s.Check(); err != nil {
+				t.Fatalf("failed to check EC bdevs: %v", err)
+			}
+			if advance == 1 && now.Equal(time.Unix(1001, 0)) {
+				status, _ := s.Get("ec0")
+				if !status.Rebuilding || status.RebuildProgress.PercentComplete != 40 || status.MaxStripesPerSec != 50 {
+					t.Fatalf("unexpected status of a throttled rebuild %+v", status)
+				}
+			}
+		}
+		if statuses := s.List(); len(statuses) != 0 {
+			t.Fatalf("expected no EC bdev after deletion, got %+v", statuses)
+		}
+	})
+
+	want := []EcEvent{
+		{Type: EcEventTypeStateChanged, EcName: "ec0", NewState: spdktypes.BdevEcStateDegraded},
+		{Type: EcEventTypeRebuildStarted, EcName: "ec0", Slots: []uint32{2}},
+		{Type: EcEventTypeRebuildQosSet, EcName: "ec0", MaxStripesPerSec: 50},
+		{Type: EcEventTypeRebuildQosSet, EcName: "ec0", MaxStripesPerSec: 0},
+		{Type: EcEventTypeRebuildFailed, EcName: "ec0", Slots: []uint32{2}, Attempts: 1, Error: "rebuild stopped with replacing slots left"},
+		{Type: EcEventTypeRebuildFailed, EcName: "ec0", Slots: []uint32{2}, Attempts: 2, Error: startErr.Error()},
+		{Type: EcEventTypeRebuildStarted, EcName: "ec0", Slots: []uint32{2}},
+		{Type: EcEventTypeStateChanged, EcName: "ec0", OldState: spdktypes.BdevEcStateDegraded, NewState: spdktypes.BdevEcStateOnline},
+		{Type: EcEventTypeRebuildCompleted, EcName: "ec0"},
+		{Type: EcEventTypeRemoved, EcName: "ec0"},
+	}
+	if !reflect.DeepEqual(events, want) {
+		t.Fatalf("got events\n%+v\nwant\n%+v", events, want)
+	}
+}
+
+func TestEcSupervisorRebuildProgressError(t *testing.T) {
+	progressErr := &jsonrpc.ResponseError{Code: jsonrpc.RespErrorCodeInternalError, Message: "Input/output error"}
+	replacing := spdktypes.BdevEcSlotStateReplacing
+	steps := []jsonRPCScriptStep{
+		// A rebuild started by someone else is tracked.
+		ecGetBdevsStep(ecInfoResult("ec0", replacing, true, 0)),
+		ecNameStep("bdev_ec_get_rebuild_progress", "ec0", nil, progressErr),
+		// The rebuild kept running.
+		ecGetBdevsStep(ecInfoResult("ec0", replacing, true, 0)),
+		ecNameStep("bdev_ec_get_rebuild_progress", "ec0", map[string]any{"ec_name": "ec0", "percent_complete": 40}, nil),
+	}
+
+	events := []EcEvent{}
+	runJSONRPCScriptTest(t, steps, func(cli *Client) {
+		s := NewEcSupervisor(cli, EcSupervisorOptions{
+			RetryBackoff: 10 * time.Second,
+			OnEvent: func(event EcEvent) {
+				if event.Type != EcEventTypeStateChanged {
+					events = append(events, EcEvent{Type: event.Type, EcName: event.EcName})
+				}
+			},
+		})
+		now := time.Unix(1000, 0)
+		s.now = func() time.Time { return now }
+
+		for i := 0; i < 2; i++ {
+			if err := s.Check(); err != nil {
+				t.Fatalf("failed to check EC bdevs: %v", err)
+			}
+			status, ok := s.Get("ec0")
+			if !ok {
+				t.Fatal("cannot find EC bdev ec0")
+			}
+			if !status.Rebuilding || status.RebuildAttempts != 0 || status.LastRebuildError != "" || !status.NextRebuildRetry.IsZero() {
+				t.Fatalf("unexpected status after check %d %+v", i, status)
+			}
+		}
+		if status, _ := s.Get("ec0"); status.RebuildProgress == nil || status.RebuildProgress.PercentComplete != 40 {
+			t.Fatalf("unexpected rebuild progress %+v", status.RebuildProgress)
+		}
+	})
+
+	if want := []EcEvent{{Type: EcEventTypeRebuildStarted, EcName: "ec0"}}; !reflect.DeepEqual(events, want) {
+		t.Fatalf("got events %+v, want %+v", events, want)
+	}
+}
+
+func TestEcSupervisorWithRebuildScheduler(t *testing.T) {
+	replacing := spdktypes.BdevEcSlotStateReplacing
+	progress := func(ecName string) jsonRPCScriptStep {
+		return ecNameStep("bdev_ec_get_rebuild_progress", ecName, map[string]any{"ec_name": ecName, "percent_complete": 10}, nil)
+	}
+
+	// The base bdevs are not lvols, so each one is its own device.
+	getBdevs := jsonRPCScriptStep{method: "bdev_get_bdevs", result: []spdktypes.BdevInfo{}}
+
+	steps := []jsonRPCScriptStep{
+		// Both rebuilds run and have the same priority: ec1 is paused.
+		ecGetBdevsStep(ecInfoResult("ec0", replacing, true, 0), ecInfoResult("ec1", replacing, true, 0)),
+		getBdevs,
+		progress("ec0"),
+		progress("ec1"),
+		ecSetRebuildQosStep("ec1", 0, true),
+		// ec0 is rebuilt: ec1 is resumed.
+		ecGetBdevsStep(ecInfoResult("ec0", spdktypes.BdevEcSlotStateNormal, false, 0), ecInfoResult("ec1", replacing, true, 0)),
+		getBdevs,
+		progress("ec1"),
+		ecSetRebuildQosStep("ec1", 0, false),
+		// ec0 is deleted and ec2 is replaced: its rebuild waits for ec1.
+		ecGetBdevsStep(ecInfoResult("ec1", replacing, true, 0), ecInfoResult("ec2", replacing, false, 0)),
+		getBdevs,
+		progress("ec1"),
+	}
+
+	events := []EcEvent{}
+	runJSONRPCScriptTest(t, steps, func(cli *Client) {
+		s := NewEcSupervisor(cli, EcSupervisorOptions{
+			Scheduler: NewEcRebuildScheduler(EcRebuildSchedulerOptions{MaxRebuildsPerNode: 1}),
+			Node:      "node-0",
+			OnEvent: func(event EcEvent) {
+				if event.Type != EcEventTypeStateChanged {
+					events = append(events, EcEvent{Type: event.Type, EcName: event.EcName})
+				}
+			},
+		})
+
+		for i := 0; i < 3; i++ {
+			if err := s.Check(); err != nil {
+				t.Fatalf("failed to check EC bdevs: %v", err)
+			}
+		}
+		if status, _ := s.Get("ec2"); !status.RebuildQueued || status.Rebuilding {
+			t.Fatalf("expected a queued rebuild, got %+v", status)
+		}
+	})
+
+	want := []EcEvent{
+		{Type: EcEventTypeRebuildStarted, EcName: "ec0"},
+		{Type: EcEventTypeRebuildStarted, EcName: "ec1"},
+		{Type: EcEventTypeRebuildPaused, EcName: "ec1"},
+		{Type: EcEventTypeRebuildCompleted, EcName: "ec0"},
+		{Type: EcEventTypeRebuildResumed, EcName: "ec1"},
+		{Type: EcEventTypeRemoved, EcName: "ec0"},
+	}
+	if !reflect.DeepEqual(events, want) {
+		t.Fatalf("got events %+v, want %+v", events, want)
+	}
+}
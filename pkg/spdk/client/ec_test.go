@@ -178,6 +178,52 @@ func TestBdevEcRPCRequests(t *testing.T) {
 			absentKeys: []string{"name"},
 			result:     map[string]any{},
 		},
+		{
+			name:   "BdevEcStartScrub",
+			method: "bdev_ec_start_scrub",
+			call: func(cli *Client) error {
+				_, err := cli.BdevEcStartScrub("ec0", false)
+				return err
+			},
+			expectKeys: map[string]any{"ec_name": "ec0"},
+			absentKeys: []string{"name", "dirty_only"},
+			result:     map[string]any{},
+		},
+		{
+			name:   "BdevEcStartScrub dirty only",
+			method: "bdev_ec_start_scrub",
+			call: func(cli *Client) error {
+				_, err := cli.BdevEcStartScrub("ec0", true)
+				return err
+			},
+			expectKeys: map[string]any{"ec_name": "ec0", "dirty_only": true},
+			result:     map[string]any{},
+		},
+		{
+			name:   "BdevEcStopScrub",
+			method: "bdev_ec_stop_scrub",
+			call: func(cli *Client) error {
+				_, err := cli.BdevEcStopScrub("ec0")
+				return err
+			},
+			expectKeys: map[string]any{"ec_name": "ec0"},
+			absentKeys: []string{"name"},
+			result:     true,
+		},
+		{
+			name:   "BdevEcSetScrubQos",
+			method: "bdev_ec_set_scrub_qos",
+			call: func(cli *Client) error {
+				_, err := cli.BdevEcSetScrubQos("ec0", 1000, false)
+				return err
+			},
+			expectKeys: map[string]any{
+				"ec_name":             "ec0",
+				"max_stripes_per_sec": float64(1000),
+				"paused":              false,
+			},
+			result: true,
+		},
 	}
 
 	for _, tc := range cases {
@@ -0,0 +1,528 @@
+package client
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/longhorn/go-spdk-helper/pkg/jsonrpc"
+	spdktypes "github.com/longhorn/go-spdk-helper/pkg/spdk/types"
+	"github.com/longhorn/go-spdk-helper/pkg/types"
+)
+
+// ECVolumeRemote is a node hosting remote shards of an EC volume.
+type ECVolumeRemote struct {
+	Client *Client
+	// IP and Port are the NVMe/TCP address the shards of the node are exposed on.
+	IP   string
+	Port string
+}
+
+// ECVolumeSpec describes an EC volume: an EC bdev on shard lvols, local or
+// remote, and a lvstore on the EC bdev.
+type ECVolumeSpec struct {
+	// Placement is the shard placement from spdktypes.PlanECPlacement. The
+	// shards of the local node of the placement are created with the client
+	// the volume is provisioned with.
+	Placement  *spdktypes.EcPlacement
+	VolumeSize int64
+	// CreationVolumeSize is the volume size the lvstore was created for, which
+	// bounds how far it can grow. ExpandECVolume requires it, since VolumeSize
+	// no longer is that size once the volume has been expanded.
+	CreationVolumeSize int64
+	// LvsName is the name of the lvstore created on the EC bdev.
+	LvsName string
+	// Remotes are the nodes hosting the remote shards, by node name.
+	Remotes map[string]ECVolumeRemote
+}
+
+// ECVolume is the result of ProvisionECVolume.
+type ECVolume struct {
+	EcName    string   `json:"ec_name"`
+	BaseBdevs []string `json:"base_bdevs"`
+	LvsName   string   `json:"lvs_name"`
+	LvsUUID   string   `json:"lvs_uuid"`
+	// Capacity is the data capacity of the lvstore, in bytes.
+	Capacity uint64 `json:"capacity"`
+}
+
+// ProvisionECVolume creates the shard lvols of the placement, exposes the
+// remote ones and attaches them, creates the EC bdev on the shards, and then
+// a lvstore on the EC bdev with the cluster size and metadata ratio the shard
+// sizing assumes. Finally it checks that the lvstore can hold the volume.
+//
+// Every step checks the current state first, so calling ProvisionECVolume
+// again with the same spec resumes an interrupted provisioning. On failure,
+// what this call created is removed in reverse order.
+func (c *Client) ProvisionECVolume(spec ECVolumeSpec) (volume *ECVolume, err error) {
+	if err := spec.validate(); err != nil {
+		return nil, err
+	}
+	placement := spec.Placement
+	create := placement.Create
+
+	var rollback rollbackSteps
+	defer func() {
+		if err != nil {
+			logrus.WithError(err).Warnf("Rolling back provisioning EC volume %v", create.Name)
+			rollback.run(fmt.Sprintf("provisioning EC volume %v", create.Name))
+		}
+	}()
+
+	for _, shard := range placement.Shards {
+		if err := c.provisionECShard(spec, shard, &rollback); err != nil {
+			return nil, errors.Wrapf(err, "failed to provision shard %v of EC volume %v on node %v", shard.Slot, create.Name, shard.Node)
+		}
+	}
+
+	ecInfo, err := c.getECBdev(create.Name)
+	if err != nil {
+		return nil, err
+	}
+	if ecInfo == nil {
+		if err := c.ValidateECCreate(&create, placement.ShardSize-spdktypes.EcFrontReservationBytes(create.StripSizeKB)); err != nil {
+			return nil, err
+		}
+		if _, err := c.BdevEcCreate(create.Name, create.DataChunks, create.ParityChunks, create.StripSizeKB, create.BaseBdevs, false); err != nil {
+			return nil, errors.Wrapf(err, "failed to create EC bdev %v", create.Name)
+		}
+		rollback.push(func() error {
+			_, err := c.BdevEcDelete(create.Name)
+			return err
+		})
+	}
+
+	lvs, err := c.getLvstore(spec.LvsName)
+	if err != nil {
+		return nil, err
+	}
+	if lvs == nil {
+		if _, err := c.BdevLvolCreateLvstoreWithMdRatio(create.Name, spec.LvsName,
+			spdktypes.EcLvstoreClusterSize, spdktypes.EcLvstoreMdPagesPerClusterRatio); err != nil {
+			return nil, errors.Wrapf(err, "failed to create lvstore %v on EC bdev %v", spec.LvsName, create.Name)
+		}
+		rollback.push(func() error {
+			_, err := c.BdevLvolDeleteLvstore(spec.LvsName, "")
+			return err
+		})
+		if lvs, err = c.getLvstore(spec.LvsName); err != nil {
+			return nil, err
+		}
+		if lvs == nil {
+			return nil, fmt.Errorf("cannot find lvstore %v after creation", spec.LvsName)
+		}
+	}
+	if lvs.BaseBdev != create.Name {
+		return nil, fmt.Errorf("lvstore %v is on bdev %v instead of EC bdev %v", spec.LvsName, lvs.BaseBdev, create.Name)
+	}
+
+	capacity := lvs.TotalDataClusters * lvs.ClusterSize
+	if capacity < uint64(spec.VolumeSize) {
+		return nil, fmt.Errorf("lvstore %v on EC bdev %v holds %v bytes, less than the volume size %v",
+			spec.LvsName, create.Name, capacity, spec.VolumeSize)
+	}
+
+	return &ECVolume{
+		EcName:    create.Name,
+		BaseBdevs: create.BaseBdevs,
+		LvsName:   lvs.Name,
+		LvsUUID:   lvs.UUID,
+		Capacity:  capacity,
+	}, nil
+}
+
+// TeardownECVolume deletes the lvstore, the EC bdev and the shards of an EC
+// volume, including remote ones. Whatever is already gone is skipped, so it
+// also cleans up after an incomplete provisioning.
+func (c *Client) TeardownECVolume(spec ECVolumeSpec) error {
+	placement := spec.Placement
+	if err := spec.validate(); err != nil {
+		return err
+	}
+
+	lvs, err := c.getLvstore(spec.LvsName)
+	if err != nil {
+		return err
+	}
+	if lvs != nil {
+		if _, err := c.BdevLvolDeleteLvstore(spec.LvsName, ""); err != nil {
+			return errors.Wrapf(err, "failed to delete lvstore %v", spec.LvsName)
+		}
+	}
+
+	ecInfo, err := c.getECBdev(placement.Create.Name)
+	if err != nil {
+		return err
+	}
+	if ecInfo != nil {
+		if _, err := c.BdevEcDelete(placement.Create.Name); err != nil {
+			return errors.Wrapf(err, "failed to delete EC bdev %v", placement.Create.Name)
+		}
+	}
+
+	for _, shard := range placement.Shards {
+		if err := c.teardownECShard(spec, shard); err != nil {
+			return errors.Wrapf(err, "failed to delete shard %v of EC volume %v on node %v", shard.Slot, placement.Create.Name, shard.Node)
+		}
+	}
+	return nil
+}
+
+// ECVolumeLayerResize is the size of a bdev of an EC volume before and after
+// ExpandECVolume.
+type ECVolumeLayerResize struct {
+	Name string `json:"name"`
+	// Node is the node of a shard lvol, empty for the bdevs of the local node.
+	Node      string `json:"node,omitempty"`
+	BlockSize uint32 `json:"block_size"`
+	OldBlocks uint64 `json:"old_blocks"`
+	NewBlocks uint64 `json:"new_blocks"`
+}
+
+// ECVolumeLvstoreResize is the size of the lvstore of an EC volume before and
+// after ExpandECVolume.
+type ECVolumeLvstoreResize struct {
+	Name            string `json:"name"`
+	ClusterSize     uint64 `json:"cluster_size"`
+	OldDataClusters uint64 `json:"old_data_clusters"`
+	NewDataClusters uint64 `json:"new_data_clusters"`
+	// OldCapacity and NewCapacity are the data capacity, in bytes.
+	OldCapacity uint64 `json:"old_capacity"`
+	NewCapacity uint64 `json:"new_capacity"`
+}
+
+// ECVolumeExpansion is the result of ExpandECVolume, layer by layer.
+type ECVolumeExpansion struct {
+	EcName        string `json:"ec_name"`
+	OldVolumeSize int64  `json:"old_volume_size"`
+	NewVolumeSize int64  `json:"new_volume_size"`
+	// NewShardSize is the shard size for NewVolumeSize, to which the
+	// placement of the volume is to be updated.
+	NewShardSize uint64 `json:"new_shard_size"`
+	// Shards are the shard lvols, on their node.
+	Shards []ECVolumeLayerResize `json:"shards"`
+	// RemoteBaseBdevs are the NVMe bdevs of the remote shards on the local node.
+	RemoteBaseBdevs []ECVolumeLayerResize `json:"remote_base_bdevs,omitempty"`
+	Ec              ECVolumeLayerResize   `json:"ec"`
+	Lvstore         ECVolumeLvstoreResize `json:"lvstore"`
+}
+
+var (
+	// ecRemoteShardResizeTimeout is how long ExpandECVolume waits for the NVMe
+	// bdev of a remote shard to pick up the resize of the shard lvol.
+	ecRemoteShardResizeTimeout  = 30 * time.Second
+	ecRemoteShardResizeInterval = time.Second
+)
+
+// ExpandECVolume grows an EC volume provisioned by ProvisionECVolume to
+// newVolumeSize: it resizes the shard lvols, local or remote, to the shard
+// size of the new volume size, waits for the NVMe bdevs of the remote shards
+// to follow, resizes the EC bdev and grows the lvstore on it. Finally it
+// checks that the lvstore can hold the new volume size.
+//
+// spec is the spec the volume was provisioned with, with VolumeSize its
+// current size and CreationVolumeSize its size at provisioning. The layers already at the
+// new size are left as they are, so calling ExpandECVolume again with the
+// same spec resumes an interrupted expansion. The lvstore can only grow up to
+// spdktypes.EcLvstoreMaxGrowthFactor times its creation size, so a larger
+// new size is rejected before anything is resized.
+func (c *Client) ExpandECVolume(spec ECVolumeSpec, newVolumeSize int64) (*ECVolumeExpansion, error) {
+	if err := spec.validate(); err != nil {
+		return nil, err
+	}
+	placement := spec.Placement
+	create := placement.Create
+	if newVolumeSize <= spec.VolumeSize {
+		return nil, fmt.Errorf("new size %v of EC volume %v is not larger than its size %v", newVolumeSize, create.Name, spec.VolumeSize)
+	}
+	if err := spdktypes.ValidateECCreationSize(newVolumeSize, int(create.DataChunks), int(create.StripSizeKB)); err != nil {
+		return nil, err
+	}
+	if spec.CreationVolumeSize <= 0 || spec.CreationVolumeSize > spec.VolumeSize {
+		return nil, fmt.Errorf("invalid creation size %v of EC volume %v, it must be positive and at most its size %v",
+			spec.CreationVolumeSize, create.Name, spec.VolumeSize)
+	}
+	if maxVolumeSize := spec.CreationVolumeSize * spdktypes.EcLvstoreMaxGrowthFactor; newVolumeSize > maxVolumeSize {
+		return nil, fmt.Errorf("new size %v of EC volume %v is larger than %v, %v times the size %v its lvstore was created for",
+			newVolumeSize, create.Name, maxVolumeSize, spdktypes.EcLvstoreMaxGrowthFactor, spec.CreationVolumeSize)
+	}
+
+	expansion := &ECVolumeExpansion{
+		EcName:        create.Name,
+		OldVolumeSize: spec.VolumeSize,
+		NewVolumeSize: newVolumeSize,
+		NewShardSize:  uint64(spdktypes.ComputeShardSize(newVolumeSize, int(create.DataChunks), int(create.StripSizeKB))),
+	}
+
+	for _, shard := range placement.Shards {
+		resize, err := c.expandECShard(spec, shard, expansion.NewShardSize)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to resize shard %v of EC volume %v on node %v", shard.Slot, create.Name, shard.Node)
+		}
+		expansion.Shards = append(expansion.Shards, *resize)
+	}
+	for _, shard := range placement.Shards {
+		if !shard.Remote {
+			continue
+		}
+		resize, err := c.waitECRemoteShardResize(shard, expansion.NewShardSize)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to wait for the resize of shard %v of EC volume %v", shard.Slot, create.Name)
+		}
+		expansion.RemoteBaseBdevs = append(expansion.RemoteBaseBdevs, *resize)
+	}
+
+	ecResize, err := c.BdevEcResize(create.Name)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to resize EC bdev %v", create.Name)
+	}
+	ecBdev, err := c.getBdev(create.Name)
+	if err != nil {
+		return nil, err
+	}
+	expansion.Ec = ECVolumeLayerResize{
+		Name:      create.Name,
+		BlockSize: ecBdev.BlockSize,
+		OldBlocks: ecResize.OldBlockcnt,
+		NewBlocks: ecResize.NewBlockcnt,
+	}
+	usable := spdktypes.EcUsableSize(newVolumeSize, int(create.DataChunks), int(create.StripSizeKB))
+	if size := ecResize.NewBlockcnt * uint64(ecBdev.BlockSize); size < usable {
+		return nil, fmt.Errorf("EC bdev %v is %v bytes after the resize, less than the %v bytes expected", create.Name, size, usable)
+	}
+
+	lvs, err := c.getLvstore(spec.LvsName)
+	if err != nil {
+		return nil, err
+	}
+	if lvs == nil {
+		return nil, fmt.Errorf("cannot find lvstore %v of EC volume %v", spec.LvsName, create.Name)
+	}
+	expansion.Lvstore = ECVolumeLvstoreResize{
+		Name:            lvs.Name,
+		ClusterSize:     lvs.ClusterSize,
+		OldDataClusters: lvs.TotalDataClusters,
+		OldCapacity:     lvs.TotalDataClusters * lvs.ClusterSize,
+	}
+	if _, err := c.BdevLvolGrowLvstore(spec.LvsName, ""); err != nil {
+		return nil, errors.Wrapf(err, "failed to grow lvstore %v on EC bdev %v", spec.LvsName, create.Name)
+	}
+	if lvs, err = c.getLvstore(spec.LvsName); err != nil {
+		return nil, err
+	}
+	if lvs == nil {
+		return nil, fmt.Errorf("cannot find lvstore %v of EC volume %v after growing it", spec.LvsName, create.Name)
+	}
+	expansion.Lvstore.NewDataClusters = lvs.TotalDataClusters
+	expansion.Lvstore.NewCapacity = lvs.TotalDataClusters * lvs.ClusterSize
+	if expansion.Lvstore.NewCapacity < uint64(newVolumeSize) {
+		return nil, fmt.Errorf("lvstore %v on EC bdev %v holds %v bytes after growing, less than the volume size %v",
+			spec.LvsName, create.Name, expansion.Lvstore.NewCapacity, newVolumeSize)
+	}
+	return expansion, nil
+}
+
+// expandECShard resizes the lvol of a shard to shardSize unless it is
+// already at least that large.
+func (c *Client) expandECShard(spec ECVolumeSpec, shard spdktypes.EcShardPlacement, shardSize uint64) (*ECVolumeLayerResize, error) {
+	owner := c
+	if shard.Remote {
+		owner = spec.Remotes[shard.Node].Client
+	}
+	alias := shard.LvsName + "/" + shard.LvolName
+
+	bdev, err := owner.getBdev(alias)
+	if err != nil {
+		return nil, err
+	}
+	resize := &ECVolumeLayerResize{
+		Name:      alias,
+		Node:      shard.Node,
+		BlockSize: bdev.BlockSize,
+		OldBlocks: bdev.NumBlocks,
+		NewBlocks: bdev.NumBlocks,
+	}
+	if bdev.NumBlocks*uint64(bdev.BlockSize) >= shardSize {
+		return resize, nil
+	}
+
+	if _, err := owner.BdevLvolResize(alias, shardSize/types.MiB); err != nil {
+		return nil, err
+	}
+	if bdev, err = owner.getBdev(alias); err != nil {
+		return nil, err
+	}
+	resize.NewBlocks = bdev.NumBlocks
+	return resize, nil
+}
+
+// waitECRemoteShardResize waits for the NVMe bdev of a remote shard to reach
+// shardSize. The initiator picks up the new namespace size asynchronously.
+func (c *Client) waitECRemoteShardResize(shard spdktypes.EcShardPlacement, shardSize uint64) (*ECVolumeLayerResize, error) {
+	var resize *ECVolumeLayerResize
+	deadline := time.Now().Add(ecRemoteShardResizeTimeout)
+	for {
+		bdev, err := c.getBdev(shard.BaseBdev)
+		if err != nil {
+			return nil, err
+		}
+		if resize == nil {
+			resize = &ECVolumeLayerResize{Name: shard.BaseBdev, BlockSize: bdev.BlockSize, OldBlocks: bdev.NumBlocks}
+		}
+		resize.NewBlocks = bdev.NumBlocks
+		if bdev.NumBlocks*uint64(bdev.BlockSize) >= shardSize {
+			return resize, nil
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("timed out after %v waiting for bdev %v to grow to %v bytes", ecRemoteShardResizeTimeout, shard.BaseBdev, shardSize)
+		}
+		time.Sleep(ecRemoteShardResizeInterval)
+	}
+}
+
+func (spec *ECVolumeSpec) validate() error {
+	placement := spec.Placement
+	if placement == nil || len(placement.Shards) == 0 {
+		return fmt.Errorf("no shard placement for EC volume")
+	}
+	create := placement.Create
+	if spec.LvsName == "" {
+		return fmt.Errorf("no lvstore name for EC volume %v", create.Name)
+	}
+	if shardSize := spdktypes.ComputeShardSize(spec.VolumeSize, int(create.DataChunks), int(create.StripSizeKB)); uint64(shardSize) != placement.ShardSize {
+		return fmt.Errorf("shard size %v of the placement of EC volume %v does not match the size %v for volume size %v",
+			placement.ShardSize, create.Name, shardSize, spec.VolumeSize)
+	}
+	for _, shard := range placement.Shards {
+		if _, ok := spec.Remotes[shard.Node]; shard.Remote && !ok {
+			return fmt.Errorf("no remote for node %v of shard %v of EC volume %v", shard.Node, shard.Slot, create.Name)
+		}
+	}
+	return nil
+}
+
+// provisionECShard creates the lvol of a shard unless it exists, and for a
+// remote shard exposes it and attaches it unless it is already attached.
+func (c *Client) provisionECShard(spec ECVolumeSpec, shard spdktypes.EcShardPlacement, rollback *rollbackSteps) error {
+	owner := c
+	if shard.Remote {
+		owner = spec.Remotes[shard.Node].Client
+	}
+
+	lvol, err := owner.getLvolInfo(shard.LvsName, shard.LvolName)
+	if err != nil {
+		return err
+	}
+	if lvol == nil {
+		if _, err := owner.BdevLvolCreate(shard.LvsName, "", shard.LvolName, spec.Placement.ShardSize/types.MiB, "", false); err != nil {
+			return err
+		}
+		rollback.push(func() error {
+			_, err := owner.BdevLvolDelete(shard.LvsName + "/" + shard.LvolName)
+			return err
+		})
+	}
+	if !shard.Remote {
+		return nil
+	}
+
+	bdevs, err := c.BdevGetBdevs(shard.BaseBdev, 0)
+	if err != nil && !jsonrpc.IsJSONRPCRespErrorNoSuchDevice(err) {
+		return err
+	}
+	if err == nil && len(bdevs) > 0 {
+		return nil
+	}
+
+	remote := spec.Remotes[shard.Node]
+	nqn := types.GetNQN(shard.LvolName)
+	if err := remote.Client.StartExposeBdev(nqn, shard.LvsName+"/"+shard.LvolName, "", remote.IP, remote.Port); err != nil {
+		return err
+	}
+	rollback.push(func() error { return remote.Client.StopExposeBdev(nqn) })
+
+	bdevNames, err := c.BdevNvmeAttachController(shard.LvolName, nqn, remote.IP, remote.Port,
+		spdktypes.NvmeTransportTypeTCP, DetectAddressFamily(remote.IP),
+		types.DefaultCtrlrLossTimeoutSec, types.DefaultReconnectDelaySec, types.DefaultFastIOFailTimeoutSec,
+		types.DefaultMultipath, "")
+	if err != nil {
+		return err
+	}
+	rollback.push(func() error {
+		_, err := c.BdevNvmeDetachController(shard.LvolName)
+		return err
+	})
+	if len(bdevNames) != 1 || bdevNames[0] != shard.BaseBdev {
+		return fmt.Errorf("unexpected bdevs %v of controller %v, expected %v", bdevNames, shard.LvolName, shard.BaseBdev)
+	}
+	return nil
+}
+
+// teardownECShard detaches and stops exposing a remote shard, then deletes
+// the lvol of the shard. Each step is skipped if already done.
+func (c *Client) teardownECShard(spec ECVolumeSpec, shard spdktypes.EcShardPlacement) error {
+	owner := c
+	if shard.Remote {
+		remote := spec.Remotes[shard.Node]
+		owner = remote.Client
+
+		if _, err := c.BdevNvmeDetachController(shard.LvolName); err != nil && !jsonrpc.IsJSONRPCRespErrorNoSuchDevice(err) {
+			return errors.Wrapf(err, "failed to detach controller %v", shard.LvolName)
+		}
+		if err := remote.Client.StopExposeBdev(types.GetNQN(shard.LvolName)); err != nil {
+			return err
+		}
+	}
+
+	lvol, err := owner.getLvolInfo(shard.LvsName, shard.LvolName)
+	if err != nil {
+		return err
+	}
+	if lvol == nil {
+		return nil
+	}
+	_, err = owner.BdevLvolDelete(lvol.UUID)
+	return err
+}
+
+// getECBdev returns the EC bdev with the given name, or nil.
+func (c *Client) getECBdev(name string) (*spdktypes.BdevEcInfo, error) {
+	infos, err := c.BdevEcGetBdevs("")
+	if err != nil {
+		return nil, err
+	}
+	for i := range infos {
+		if infos[i].Name == name {
+			return &infos[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// getBdev returns the bdev with the given name.
+func (c *Client) getBdev(name string) (*spdktypes.BdevInfo, error) {
+	bdevs, err := c.BdevGetBdevs(name, 0)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get bdev %v", name)
+	}
+	if len(bdevs) != 1 {
+		return nil, fmt.Errorf("found %v bdevs named %v", len(bdevs), name)
+	}
+	return &bdevs[0], nil
+}
+
+// getLvstore returns the lvstore with the given name, or nil.
+func (c *Client) getLvstore(lvsName string) (*spdktypes.LvstoreInfo, error) {
+	lvstores, err := c.BdevLvolGetLvstore("", "")
+	if err != nil {
+		return nil, err
+	}
+	for i := range lvstores {
+		if lvstores[i].Name == lvsName {
+			return &lvstores[i], nil
+		}
+	}
+	return nil, nil
+}
@@ -0,0 +1,333 @@
+package client
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	spdktypes "github.com/longhorn/go-spdk-helper/pkg/spdk/types"
+	"github.com/longhorn/go-spdk-helper/pkg/types"
+)
+
+const testECVolumeSize = 10 * 1024 * types.MiB
+
+func testECVolumeSpec(t *testing.T) ECVolumeSpec {
+	backends := []spdktypes.EcPlacementBackend{}
+	for _, lvsName := range []string{"lvs-a", "lvs-b", "lvs-c"} {
+		backends = append(backends, spdktypes.EcPlacementBackend{
+			Node:        "node-1",
+			LvsName:     lvsName,
+			Domain:      lvsName,
+			FreeBytes:   100 * 1024 * types.MiB,
+			ClusterSize: spdktypes.EcLvstoreClusterSize,
+		})
+	}
+	placement, err := spdktypes.PlanECPlacement(spdktypes.EcPlacementRequest{
+		Name:         "ec0",
+		VolumeSize:   testECVolumeSize,
+		DataChunks:   2,
+		ParityChunks: 1,
+		StripSizeKB:  64,
+		LocalNode:    "node-1",
+	}, backends)
+	if err != nil {
+		t.Fatalf("failed to plan placement: %v", err)
+	}
+	return ECVolumeSpec{Placement: placement, VolumeSize: testECVolumeSize, LvsName: "vol-lvs"}
+}
+
+func getLvolsStep(lvsName string, lvols ...spdktypes.LvolInfo) jsonRPCScriptStep {
+	if lvols == nil {
+		lvols = []spdktypes.LvolInfo{}
+	}
+	return jsonRPCScriptStep{method: "bdev_lvol_get_lvols", params: map[string]interface{}{"lvs_name": lvsName}, result: lvols}
+}
+
+func getLvstoresStep(lvstores ...spdktypes.LvstoreInfo) jsonRPCScriptStep {
+	if lvstores == nil {
+		lvstores = []spdktypes.LvstoreInfo{}
+	}
+	return jsonRPCScriptStep{method: "bdev_lvol_get_lvstores", result: lvstores}
+}
+
+// shardBdevStep returns the shard bdev of the placement, sized as a shard.
+func shardBdevStep(spec ECVolumeSpec, name string, claimed bool) jsonRPCScriptStep {
+	bdev := spdktypes.BdevInfo{}
+	bdev.Name = name
+	bdev.BlockSize = 4096
+	bdev.NumBlocks = spec.Placement.ShardSize / 4096
+	bdev.Claimed = claimed
+	return jsonRPCScriptStep{method: "bdev_get_bdevs", params: map[string]interface{}{"name": name}, result: []spdktypes.BdevInfo{bdev}}
+}
+
+func ecProvisionSteps(spec ECVolumeSpec, lvs spdktypes.LvstoreInfo) []jsonRPCScriptStep {
+	steps := []jsonRPCScriptStep{}
+	for _, shard := range spec.Placement.Shards {
+		steps = append(steps,
+			getLvolsStep(shard.LvsName),
+			jsonRPCScriptStep{
+				method: "bdev_lvol_create",
+				params: map[string]interface{}{
+					"lvs_name":     shard.LvsName,
+					"lvol_name":    shard.LvolName,
+					"size_in_mib":  float64(spec.Placement.ShardSize / types.MiB),
+					"clear_method": "unmap",
+				},
+				result: shard.LvolName + "-uuid",
+			})
+	}
+	steps = append(steps, ecGetBdevsStep())
+	for _, shard := range spec.Placement.Shards {
+		steps = append(steps, shardBdevStep(spec, shard.BaseBdev, false))
+	}
+	return append(steps,
+		jsonRPCScriptStep{
+			method: "bdev_ec_create",
+			params: map[string]interface{}{
+				"name":               "ec0",
+				"data_chunk_count":   float64(2),
+				"parity_chunk_count": float64(1),
+				"strip_size_kb":      float64(64),
+				"base_bdevs":         []interface{}{"lvs-a/ec0-shard-0", "lvs-b/ec0-shard-1", "lvs-c/ec0-shard-2"},
+			},
+			result: true,
+		},
+		getLvstoresStep(),
+		jsonRPCScriptStep{
+			method: "bdev_lvol_create_lvstore",
+			params: map[string]interface{}{
+				"bdev_name":                      "ec0",
+				"lvs_name":                       "vol-lvs",
+				"cluster_sz":                     float64(spdktypes.EcLvstoreClusterSize),
+				"num_md_pages_per_cluster_ratio": float64(spdktypes.EcLvstoreMdPagesPerClusterRatio),
+			},
+			result: "lvs-uuid",
+		},
+		getLvstoresStep(lvs),
+	)
+}
+
+func TestProvisionECVolume(t *testing.T) {
+	spec := testECVolumeSpec(t)
+	lvs := spdktypes.LvstoreInfo{
+		UUID:              "lvs-uuid",
+		Name:              "vol-lvs",
+		BaseBdev:          "ec0",
+		TotalDataClusters: 2600,
+		ClusterSize:       spdktypes.EcLvstoreClusterSize,
+	}
+
+	runJSONRPCScriptTest(t, ecProvisionSteps(spec, lvs), func(cli *Client) {
+		volume, err := cli.ProvisionECVolume(spec)
+		if err != nil {
+			t.Fatalf("failed to provision EC volume: %v", err)
+		}
+		if volume.LvsUUID != "lvs-uuid" || volume.Capacity != 2600*spdktypes.EcLvstoreClusterSize || len(volume.BaseBdevs) != 3 {
+			t.Fatalf("unexpected EC volume %+v", volume)
+		}
+	})
+}
+
+func TestProvisionECVolumeRollsBack(t *testing.T) {
+	spec := testECVolumeSpec(t)
+	lvs := spdktypes.LvstoreInfo{
+		UUID:              "lvs-uuid",
+		Name:              "vol-lvs",
+		BaseBdev:          "ec0",
+		TotalDataClusters: 100,
+		ClusterSize:       spdktypes.EcLvstoreClusterSize,
+	}
+
+	steps := append(ecProvisionSteps(spec, lvs),
+		jsonRPCScriptStep{method: "bdev_lvol_delete_lvstore", params: map[string]interface{}{"lvs_name": "vol-lvs"}, result: true},
+		jsonRPCScriptStep{method: "bdev_ec_delete", params: map[string]interface{}{"name": "ec0"}, result: true},
+		jsonRPCScriptStep{method: "bdev_lvol_delete", params: map[string]interface{}{"name": "lvs-c/ec0-shard-2"}, result: true},
+		jsonRPCScriptStep{method: "bdev_lvol_delete", params: map[string]interface{}{"name": "lvs-b/ec0-shard-1"}, result: true},
+		jsonRPCScriptStep{method: "bdev_lvol_delete", params: map[string]interface{}{"name": "lvs-a/ec0-shard-0"}, result: true},
+	)
+
+	runJSONRPCScriptTest(t, steps, func(cli *Client) {
+		_, err := cli.ProvisionECVolume(spec)
+		if err == nil || !strings.Contains(err.Error(), "less than the volume size") {
+			t.Fatalf("unexpected error %v", err)
+		}
+	})
+}
+
+func TestProvisionECVolumeValidatesShards(t *testing.T) {
+	spec := testECVolumeSpec(t)
+	// The shard lvols are created and the EC bdev is listed, then shard 1
+	// turns out to be claimed.
+	steps := ecProvisionSteps(spec, spdktypes.LvstoreInfo{})[:7]
+	steps = append(steps,
+		shardBdevStep(spec, "lvs-a/ec0-shard-0", false),
+		shardBdevStep(spec, "lvs-b/ec0-shard-1", true),
+		shardBdevStep(spec, "lvs-c/ec0-shard-2", false),
+		jsonRPCScriptStep{method: "bdev_lvol_delete", params: map[string]interface{}{"name": "lvs-c/ec0-shard-2"}, result: true},
+		jsonRPCScriptStep{method: "bdev_lvol_delete", params: map[string]interface{}{"name": "lvs-b/ec0-shard-1"}, result: true},
+		jsonRPCScriptStep{method: "bdev_lvol_delete", params: map[string]interface{}{"name": "lvs-a/ec0-shard-0"}, result: true},
+	)
+
+	runJSONRPCScriptTest(t, steps, func(cli *Client) {
+		_, err := cli.ProvisionECVolume(spec)
+		if err == nil || !strings.Contains(err.Error(), "invalid EC base bdev lvs-b/ec0-shard-1: already claimed") {
+			t.Fatalf("unexpected error %v", err)
+		}
+	})
+}
+
+func TestProvisionECVolumeRejectsMismatchedPlacement(t *testing.T) {
+	spec := testECVolumeSpec(t)
+	spec.VolumeSize *= 2
+
+	runJSONRPCScriptTest(t, nil, func(cli *Client) {
+		if _, err := cli.ProvisionECVolume(spec); err == nil || !strings.Contains(err.Error(), "does not match") {
+			t.Fatalf("unexpected error %v", err)
+		}
+	})
+}
+
+func TestTeardownECVolume(t *testing.T) {
+	spec := testECVolumeSpec(t)
+	steps := []jsonRPCScriptStep{
+		getLvstoresStep(spdktypes.LvstoreInfo{Name: "vol-lvs", BaseBdev: "ec0"}),
+		{method: "bdev_lvol_delete_lvstore", params: map[string]interface{}{"lvs_name": "vol-lvs"}, result: true},
+		ecGetBdevsStep(map[string]any{"name": "ec0"}),
+		{method: "bdev_ec_delete", params: map[string]interface{}{"name": "ec0"}, result: true},
+		getLvolsStep("lvs-a", spdktypes.LvolInfo{UUID: "shard-0-uuid", Name: "ec0-shard-0"}),
+		{method: "bdev_lvol_delete", params: map[string]interface{}{"name": "shard-0-uuid"}, result: true},
+		// The shard of slot 1 is already deleted.
+		getLvolsStep("lvs-b", spdktypes.LvolInfo{UUID: "other-uuid", Name: "other"}),
+		getLvolsStep("lvs-c", spdktypes.LvolInfo{UUID: "shard-2-uuid", Name: "ec0-shard-2"}),
+		{method: "bdev_lvol_delete", params: map[string]interface{}{"name": "shard-2-uuid"}, result: true},
+	}
+
+	runJSONRPCScriptTest(t, steps, func(cli *Client) {
+		if err := cli.TeardownECVolume(spec); err != nil {
+			t.Fatalf("failed to tear down EC volume: %v", err)
+		}
+	})
+}
+
+func sizedBdevStep(name string, size uint64) jsonRPCScriptStep {
+	bdev := spdktypes.BdevInfo{}
+	bdev.Name = name
+	bdev.BlockSize = 4096
+	bdev.NumBlocks = size / 4096
+	return jsonRPCScriptStep{method: "bdev_get_bdevs", params: map[string]interface{}{"name": name}, result: []spdktypes.BdevInfo{bdev}}
+}
+
+func TestExpandECVolume(t *testing.T) {
+	spec := testECVolumeSpec(t)
+	// The shard of slot 2 is on another node.
+	shard := &spec.Placement.Shards[2]
+	shard.Node, shard.Remote, shard.BaseBdev = "node-2", true, "ec0-shard-2n1"
+	spec.Placement.Create.BaseBdevs[2] = shard.BaseBdev
+	spec.CreationVolumeSize = testECVolumeSize
+
+	newVolumeSize := int64(2 * testECVolumeSize)
+	oldShardSize := spec.Placement.ShardSize
+	newShardSize := uint64(spdktypes.ComputeShardSize(newVolumeSize, 2, 64))
+	resizeStep := func(name string) jsonRPCScriptStep {
+		return jsonRPCScriptStep{
+			method: "bdev_lvol_resize",
+			params: map[string]interface{}{"name": name, "size_in_mib": float64(newShardSize / types.MiB)},
+			result: true,
+		}
+	}
+	lvs := func(clusters uint64) spdktypes.LvstoreInfo {
+		return spdktypes.LvstoreInfo{Name: "vol-lvs", BaseBdev: "ec0", TotalDataClusters: clusters, ClusterSize: spdktypes.EcLvstoreClusterSize}
+	}
+	oldEcBlocks := spdktypes.EcUsableSize(testECVolumeSize, 2, 64) / 4096
+	newEcBlocks := spdktypes.EcUsableSize(newVolumeSize, 2, 64) / 4096
+
+	localSteps := []jsonRPCScriptStep{
+		sizedBdevStep("lvs-a/ec0-shard-0", oldShardSize),
+		resizeStep("lvs-a/ec0-shard-0"),
+		sizedBdevStep("lvs-a/ec0-shard-0", newShardSize),
+		// The shard of slot 1 was resized by an interrupted expansion.
+		sizedBdevStep("lvs-b/ec0-shard-1", newShardSize),
+		// The NVMe bdev of the remote shard picks up the resize late.
+		sizedBdevStep("ec0-shard-2n1", oldShardSize),
+		sizedBdevStep("ec0-shard-2n1", newShardSize),
+		{
+			method: "bdev_ec_resize",
+			params: map[string]interface{}{"ec_name": "ec0"},
+			result: map[string]any{"ec_name": "ec0", "old_blockcnt": oldEcBlocks, "new_blockcnt": newEcBlocks, "resized": true},
+		},
+		sizedBdevStep("ec0", newEcBlocks*4096),
+		getLvstoresStep(lvs(2600)),
+		{method: "bdev_lvol_grow_lvstore", params: map[string]interface{}{"lvs_name": "vol-lvs"}, result: true},
+		getLvstoresStep(lvs(5200)),
+	}
+	remoteSteps := []jsonRPCScriptStep{
+		sizedBdevStep("lvs-c/ec0-shard-2", oldShardSize),
+		resizeStep("lvs-c/ec0-shard-2"),
+		sizedBdevStep("lvs-c/ec0-shard-2", newShardSize),
+	}
+
+	interval := ecRemoteShardResizeInterval
+	ecRemoteShardResizeInterval = time.Millisecond
+	defer func() { ecRemoteShardResizeInterval = interval }()
+
+	runJSONRPCScriptTest(t, remoteSteps, func(remote *Client) {
+		spec.Remotes = map[string]ECVolumeRemote{"node-2": {Client: remote}}
+		runJSONRPCScriptTest(t, localSteps, func(cli *Client) {
+			expansion, err := cli.ExpandECVolume(spec, newVolumeSize)
+			if err != nil {
+				t.Errorf("failed to expand EC volume: %v", err)
+				return
+			}
+			if expansion.NewShardSize != newShardSize || len(expansion.Shards) != 3 || len(expansion.RemoteBaseBdevs) != 1 {
+				t.Errorf("unexpected expansion %+v", expansion)
+				return
+			}
+			for i, resize := range expansion.Shards {
+				oldBlocks := oldShardSize / 4096
+				if i == 1 {
+					oldBlocks = newShardSize / 4096
+				}
+				if resize.OldBlocks != oldBlocks || resize.NewBlocks != newShardSize/4096 {
+					t.Errorf("unexpected resize of shard %v %+v", i, resize)
+				}
+			}
+			if remote := expansion.RemoteBaseBdevs[0]; remote.Name != "ec0-shard-2n1" || remote.OldBlocks != oldShardSize/4096 || remote.NewBlocks != newShardSize/4096 {
+				t.Errorf("unexpected resize of the remote base bdev %+v", remote)
+			}
+			if expansion.Ec.OldBlocks != oldEcBlocks || expansion.Ec.NewBlocks != newEcBlocks {
+				t.Errorf("unexpected resize of the EC bdev %+v", expansion.Ec)
+			}
+			if expansion.Lvstore.OldDataClusters != 2600 || expansion.Lvstore.NewDataClusters != 5200 {
+				t.Errorf("unexpected growth of the lvstore %+v", expansion.Lvstore)
+			}
+		})
+	})
+}
+
+func TestExpandECVolumeRejectsInvalidSize(t *testing.T) {
+	spec := testECVolumeSpec(t)
+
+	runJSONRPCScriptTest(t, nil, func(cli *Client) {
+		if _, err := cli.ExpandECVolume(spec, 2*testECVolumeSize); err == nil || !strings.Contains(err.Error(), "invalid creation size") {
+			t.Fatalf("unexpected error %v", err)
+		}
+		spec.CreationVolumeSize = 2 * testECVolumeSize
+		if _, err := cli.ExpandECVolume(spec, 3*testECVolumeSize); err == nil || !strings.Contains(err.Error(), "invalid creation size") {
+			t.Fatalf("unexpected error %v", err)
+		}
+		spec.CreationVolumeSize = testECVolumeSize
+		if _, err := cli.ExpandECVolume(spec, testECVolumeSize); err == nil || !strings.Contains(err.Error(), "is not larger than") {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if _, err := cli.ExpandECVolume(spec, spdktypes.EcLvstoreMaxCreationSize*2); err == nil || !strings.Contains(err.Error(), "exceeds the maximum") {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if _, err := cli.ExpandECVolume(spec, testECVolumeSize*spdktypes.EcLvstoreMaxGrowthFactor+types.MiB); err == nil || !strings.Contains(err.Error(), "times the size") {
+			t.Fatalf("unexpected error %v", err)
+		}
+		spec.CreationVolumeSize = testECVolumeSize / 2
+		if _, err := cli.ExpandECVolume(spec, testECVolumeSize*spdktypes.EcLvstoreMaxGrowthFactor/2+types.MiB); err == nil || !strings.Contains(err.Error(), "times the size") {
+			t.Fatalf("unexpected error %v", err)
+		}
+	})
+}
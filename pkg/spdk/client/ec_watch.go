@@ -0,0 +1,138 @@
+package client
+
+import (
+	"sort"
+	"time"
+
+	"github.com/cockroachdb/errors"
+
+	spdktypes "github.com/longhorn/go-spdk-helper/pkg/spdk/types"
+)
+
+// EcWatchSlot is a base bdev slot of an EcWatchRow.
+type EcWatchSlot struct {
+	Slot  uint32                    `json:"slot"`
+	Name  string                    `json:"name"`
+	Role  spdktypes.BdevEcSlotRole  `json:"role"`
+	State spdktypes.BdevEcSlotState `json:"state"`
+}
+
+// EcWatchRow is the state of an EC bdev at a poll of an EcWatcher.
+type EcWatchRow struct {
+	Time  time.Time             `json:"time"`
+	Name  string                `json:"name"`
+	State spdktypes.BdevEcState `json:"state"`
+	Slots []EcWatchSlot         `json:"slots"`
+
+	// RebuildPercent and ScrubPercent are nil when there is no rebuild or
+	// scrub. The ETAs are nil until the progress has moved while watched.
+	RebuildPercent *uint32 `json:"rebuild_percent,omitempty"`
+	RebuildEtaSec  *int64  `json:"rebuild_eta_sec,omitempty"`
+	ScrubPercent   *uint32 `json:"scrub_percent,omitempty"`
+	ScrubEtaSec    *int64  `json:"scrub_eta_sec,omitempty"`
+
+	DirtyStripes    uint64 `json:"dirty_stripes"`
+	WibDirtyRegions uint32 `json:"wib_dirty_regions"`
+	WibNumRegions   uint32 `json:"wib_num_regions"`
+
+	// Error is set when the scrub or WIB status of the EC bdev could not be
+	// polled, leaving the corresponding fields empty.
+	Error string `json:"error,omitempty"`
+}
+
+type ecProgressStart struct {
+	time    time.Time
+	percent uint32
+}
+
+// EcWatcher polls the EC bdevs of a target for a live view. It estimates the
+// time left of the rebuilds and scrubs from their average progress since it
+// first saw them.
+type EcWatcher struct {
+	cli *Client
+	now func() time.Time
+
+	rebuilds map[string]ecProgressStart
+	scrubs   map[string]ecProgressStart
+}
+
+func NewEcWatcher(cli *Client) *EcWatcher {
+	return &EcWatcher{
+		cli:      cli,
+		now:      time.Now,
+		rebuilds: map[string]ecProgressStart{},
+		scrubs:   map[string]ecProgressStart{},
+	}
+}
+
+// Poll returns the rows of the EC bdevs, or of the one with the given name,
+// sorted by name.
+func (w *EcWatcher) Poll(name string) ([]EcWatchRow, error) {
+	infos, err := w.cli.BdevEcGetBdevs(name)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get EC bdevs")
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name < infos[j].Name })
+	now := w.now()
+
+	rebuilds := map[string]ecProgressStart{}
+	scrubs := map[string]ecProgressStart{}
+	rows := []EcWatchRow{}
+	for i := range infos {
+		info := &infos[i]
+		row := EcWatchRow{
+			Time:         now,
+			Name:         info.Name,
+			State:        info.State,
+			DirtyStripes: info.DirtyStripes,
+		}
+		for _, base := range info.BaseBdevs {
+			row.Slots = append(row.Slots, EcWatchSlot{Slot: base.Slot, Name: base.Name, Role: base.Role, State: base.State})
+		}
+
+		if info.RebuildInProgress && info.RebuildProgress != nil {
+			percent := info.RebuildProgress.PercentComplete
+			row.RebuildPercent = &percent
+			row.RebuildEtaSec = ecProgressEta(w.rebuilds, rebuilds, info.Name, now, percent)
+		}
+
+		scrub, err := w.cli.BdevEcGetScrubProgress(info.Name)
+		if err != nil {
+			row.Error = errors.Wrap(err, "failed to get scrub progress").Error()
+		} else if scrub != nil {
+			percent := scrub.PercentComplete
+			row.ScrubPercent = &percent
+			row.ScrubEtaSec = ecProgressEta(w.scrubs, scrubs, info.Name, now, percent)
+		}
+
+		wib, err := w.cli.BdevEcGetWibStatus(info.Name)
+		if err != nil {
+			row.Error = errors.Wrap(err, "failed to get WIB status").Error()
+		} else {
+			row.WibDirtyRegions = wib.DirtyRegions
+			row.WibNumRegions = wib.NumRegions
+		}
+
+		rows = append(rows, row)
+	}
+	w.rebuilds, w.scrubs = rebuilds, scrubs
+	return rows, nil
+}
+
+// ecProgressEta records in next where the progress of name started being
+// watched, and returns the time left at the average rate since then. The
+// start is reset if the progress went back, i.e. a new job started.
+func ecProgressEta(prev, next map[string]ecProgressStart, name string, now time.Time, percent uint32) *int64 {
+	start, ok := prev[name]
+	if !ok || percent < start.percent {
+		start = ecProgressStart{time: now, percent: percent}
+	}
+	next[name] = start
+
+	if percent <= start.percent || percent >= 100 {
+		return nil
+	}
+	elapsed := now.Sub(start.time).Seconds()
+	eta := int64(elapsed * float64(100-percent) / float64(percent-start.percent))
+	return &eta
+}
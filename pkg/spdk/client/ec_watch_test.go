@@ -0,0 +1,86 @@
+package client
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/longhorn/go-spdk-helper/pkg/jsonrpc"
+	spdktypes "github.com/longhorn/go-spdk-helper/pkg/spdk/types"
+)
+
+func TestEcWatcherPoll(t *testing.T) {
+	noScrub := &jsonrpc.ResponseError{Code: jsonrpc.RespErrorCodeNoEntry, Message: "No such file or directory"}
+	rebuilding := func(percent int) map[string]any {
+		info := ecInfoResult("ec0", spdktypes.BdevEcSlotStateReplacing, true, 0)
+		info["dirty_stripes"] = 4
+		info["rebuild_progress"] = map[string]any{"ec_name": "ec0", "percent_complete": percent}
+		return info
+	}
+	scrubbing := func(percent int) map[string]any {
+		return map[string]any{"ec_name": "ec0", "percent_complete": percent}
+	}
+	wib := ecNameStep("bdev_ec_get_wib_status", "ec0", map[string]any{"ec_name": "ec0", "num_regions": 16, "dirty_regions": 2}, nil)
+
+	steps := []jsonRPCScriptStep{
+		ecGetBdevsStep(rebuilding(10)),
+		ecNameStep("bdev_ec_get_scrub_progress", "ec0", nil, noScrub),
+		wib,
+
+		ecGetBdevsStep(rebuilding(30)),
+		ecNameStep("bdev_ec_get_scrub_progress", "ec0", scrubbing(50), nil),
+		ecNameStep("bdev_ec_get_wib_status", "ec0", nil, &jsonrpc.ResponseError{Code: -5, Message: "Input/output error"}),
+
+		ecGetBdevsStep(ecInfoResult("ec0", spdktypes.BdevEcSlotStateNormal, false, 0)),
+		ecNameStep("bdev_ec_get_scrub_progress", "ec0", scrubbing(5), nil),
+		wib,
+	}
+
+	now := time.Unix(1000, 0)
+	polls := [][]EcWatchRow{}
+	runJSONRPCScriptTest(t, steps, func(cli *Client) {
+		w := NewEcWatcher(cli)
+		w.now = func() time.Time { return now }
+		for i := 0; i < 3; i++ {
+			rows, err := w.Poll("")
+			if err != nil {
+				t.Errorf("failed to poll EC bdevs: %v", err)
+				return
+			}
+			polls = append(polls, rows)
+			now = now.Add(10 * time.Second)
+		}
+	})
+	if t.Failed() {
+		return
+	}
+
+	row := polls[0][0]
+	if row.State != spdktypes.BdevEcStateDegraded || len(row.Slots) != 3 || row.Slots[2].State != spdktypes.BdevEcSlotStateReplacing {
+		t.Fatalf("unexpected row %+v", row)
+	}
+	if row.RebuildPercent == nil || *row.RebuildPercent != 10 || row.RebuildEtaSec != nil || row.ScrubPercent != nil {
+		t.Fatalf("unexpected progress in the first poll %+v", row)
+	}
+	if row.DirtyStripes != 4 || row.WibDirtyRegions != 2 || row.WibNumRegions != 16 || row.Error != "" {
+		t.Fatalf("unexpected counters in the first poll %+v", row)
+	}
+
+	// 20% in 10s, so 35s for the remaining 70%.
+	row = polls[1][0]
+	if row.RebuildEtaSec == nil || *row.RebuildEtaSec != 35 {
+		t.Fatalf("got rebuild ETA %v, want 35s", row.RebuildEtaSec)
+	}
+	if row.ScrubPercent == nil || *row.ScrubPercent != 50 || row.ScrubEtaSec != nil {
+		t.Fatalf("unexpected scrub progress in the second poll %+v", row)
+	}
+	if !strings.Contains(row.Error, "failed to get WIB status") {
+		t.Fatalf("got error %q, want a WIB status error", row.Error)
+	}
+
+	// The scrub went back, so it is a new one without an ETA yet.
+	row = polls[2][0]
+	if row.RebuildPercent != nil || row.ScrubPercent == nil || *row.ScrubPercent != 5 || row.ScrubEtaSec != nil {
+		t.Fatalf("unexpected progress in the third poll %+v", row)
+	}
+}
@@ -0,0 +1,233 @@
+package client
+
+import (
+	"fmt"
+
+	"github.com/cockroachdb/errors"
+
+	"github.com/longhorn/go-spdk-helper/pkg/jsonrpc"
+	spdktypes "github.com/longhorn/go-spdk-helper/pkg/spdk/types"
+)
+
+type LvolDiffOptions struct {
+	// UseChecksums drops the clusters that were rewritten with the same data,
+	// by comparing the range checksums of the cluster before and after. Only
+	// clusters whose checksums are registered on both sides can be dropped.
+	UseChecksums bool
+}
+
+// LvolDiff is the set of clusters that changed between two lvols of a chain.
+type LvolDiff struct {
+	From        string `json:"from"`
+	To          string `json:"to"`
+	ClusterSize uint64 `json:"cluster_size"`
+	NumClusters uint64 `json:"num_clusters"`
+
+	Ranges []spdktypes.ClusterRange `json:"ranges"`
+	// Clusters are the indexes of all the changed clusters. A range shallow
+	// copy only copies the clusters allocated to its source lvol itself and
+	// unmaps the others, so to bring a copy of From up to date, copy the
+	// clusters of each of the Sources from their lvol instead.
+	Clusters []uint64 `json:"clusters"`
+	// Sources split Clusters by the lvol between To and From owning their
+	// latest data, from the newest to the oldest lvol. Each can be passed to
+	// BdevLvolStartRangeShallowCopy as is.
+	Sources []LvolDiffSource `json:"sources"`
+}
+
+// LvolDiffSource is the part of an LvolDiff whose latest data is allocated
+// to a lvol of the chain.
+type LvolDiffSource struct {
+	Lvol     string   `json:"lvol"`
+	UUID     string   `json:"uuid"`
+	Clusters []uint64 `json:"clusters"`
+}
+
+// BdevLvolGetDiff returns the clusters that changed between the snapshot from
+// and the lvol to, which must be based on from directly or indirectly. They
+// are the clusters allocated to to or to any snapshot between to and from.
+// If from is empty, all the clusters allocated to the chain of to are returned.
+//
+//	"from": Optional. UUID or alias of the older snapshot.
+//
+//	"to": Required. UUID or alias of the newer lvol.
+func (c *Client) BdevLvolGetDiff(from, to string, opts LvolDiffOptions) (*LvolDiff, error) {
+	tree, err := c.BdevLvolGetTree()
+	if err != nil {
+		return nil, err
+	}
+
+	toNode := tree.Get(to)
+	if toNode == nil {
+		return nil, fmt.Errorf("cannot find lvol %v", to)
+	}
+	var fromNode *LvolTreeNode
+	if from != "" {
+		if fromNode = tree.Get(from); fromNode == nil {
+			return nil, fmt.Errorf("cannot find snapshot %v", from)
+		}
+	}
+
+	// The lvols between to, included, and from, excluded, from the newest to the oldest.
+	changed := []*LvolTreeNode{}
+	for n := toNode; n != fromNode; n = n.Parent {
+		if n == nil {
+			return nil, fmt.Errorf("lvol %v is not based on snapshot %v", to, from)
+		}
+		changed = append(changed, n)
+	}
+
+	diff := &LvolDiff{
+		From:     from,
+		To:       to,
+		Ranges:   []spdktypes.ClusterRange{},
+		Clusters: []uint64{},
+		Sources:  []LvolDiffSource{},
+	}
+	if len(changed) == 0 {
+		return diff, nil
+	}
+
+	changedBitmaps, err := c.getLvolBitmaps(changed, diff)
+	if err != nil {
+		return nil, err
+	}
+	clusters := bitmapIndexes(spdktypes.MergeBitmaps(changedBitmaps...), diff.NumClusters)
+
+	if opts.UseChecksums && fromNode != nil && len(clusters) > 0 {
+		base := append([]*LvolTreeNode{fromNode}, fromNode.Ancestors()...)
+		baseBitmaps, err := c.getLvolBitmaps(base, nil)
+		if err != nil {
+			return nil, err
+		}
+		if clusters, err = c.dropUnchangedClusters(clusters, changed, changedBitmaps, base, baseBitmaps); err != nil {
+			return nil, err
+		}
+	}
+
+	diff.Clusters = clusters
+	diff.Ranges = spdktypes.ClusterRangesFromIndexes(clusters)
+	diff.Sources = groupClustersByOwner(clusters, changed, changedBitmaps)
+	return diff, nil
+}
+
+// groupClustersByOwner splits the clusters by their newest owner in nodes,
+// keeping the order of nodes and skipping the lvols owning none.
+func groupClustersByOwner(clusters []uint64, nodes []*LvolTreeNode, bitmaps [][]byte) []LvolDiffSource {
+	owned := map[*LvolTreeNode][]uint64{}
+	for _, cluster := range clusters {
+		if owner := bitmapOwner(cluster, nodes, bitmaps); owner != nil {
+			owned[owner] = append(owned[owner], cluster)
+		}
+	}
+
+	sources := []LvolDiffSource{}
+	for _, n := range nodes {
+		if len(owned[n]) > 0 {
+			sources = append(sources, LvolDiffSource{Lvol: n.Alias, UUID: n.UUID, Clusters: owned[n]})
+		}
+	}
+	return sources
+}
+
+// getLvolBitmaps returns the decoded fragmaps of the lvols. If diff is not
+// nil, its cluster size and number of clusters are set from the first lvol.
+func (c *Client) getLvolBitmaps(nodes []*LvolTreeNode, diff *LvolDiff) ([][]byte, error) {
+	bitmaps := [][]byte{}
+	for i, n := range nodes {
+		fragmap, err := c.BdevLvolGetFragmap(n.UUID, 0, 0)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to get fragmap of lvol %v", n.Alias)
+		}
+		bitmap, err := fragmap.Bitmap()
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to decode fragmap of lvol %v", n.Alias)
+		}
+		if i == 0 && diff != nil {
+			diff.ClusterSize = fragmap.ClusterSize
+			diff.NumClusters = fragmap.NumClusters
+		}
+		bitmaps = append(bitmaps, bitmap)
+	}
+	return bitmaps, nil
+}
+
+// dropUnchangedClusters removes the clusters whose data checksum in the newest
+// lvol owning them after from equals the one in the newest lvol owning them up
+// to from.
+func (c *Client) dropUnchangedClusters(clusters []uint64, changed []*LvolTreeNode, changedBitmaps [][]byte,
+	base []*LvolTreeNode, baseBitmaps [][]byte) ([]uint64, error) {
+	newOwners := map[*LvolTreeNode][]uint64{}
+	oldOwners := map[*LvolTreeNode][]uint64{}
+	for _, cluster := range clusters {
+		newOwner := bitmapOwner(cluster, changed, changedBitmaps)
+		oldOwner := bitmapOwner(cluster, base, baseBitmaps)
+		if newOwner == nil || oldOwner == nil || !newOwner.Snapshot {
+			continue
+		}
+		newOwners[newOwner] = append(newOwners[newOwner], cluster)
+		oldOwners[oldOwner] = append(oldOwners[oldOwner], cluster)
+	}
+
+	newChecksums, err := c.getClusterChecksums(changed, newOwners)
+	if err != nil {
+		return nil, err
+	}
+	oldChecksums, err := c.getClusterChecksums(base, oldOwners)
+	if err != nil {
+		return nil, err
+	}
+
+	result := []uint64{}
+	for _, cluster := range clusters {
+		newChecksum, newOk := newChecksums[cluster]
+		oldChecksum, oldOk := oldChecksums[cluster]
+		if newOk && oldOk && newChecksum == oldChecksum {
+			continue
+		}
+		result = append(result, cluster)
+	}
+	return result, nil
+}
+
+// getClusterChecksums fetches the range checksums of the clusters owned by
+// each snapshot, in the order of the nodes. The snapshots without registered
+// checksums are skipped.
+func (c *Client) getClusterChecksums(nodes []*LvolTreeNode, owners map[*LvolTreeNode][]uint64) (map[uint64]uint64, error) {
+	checksums := map[uint64]uint64{}
+	for _, n := range nodes {
+		for _, r := range spdktypes.ClusterRangesFromIndexes(owners[n]) {
+			rangeChecksums, err := c.BdevLvolGetRangeChecksums(n.UUID, r.Start, r.Count)
+			if err != nil {
+				if jsonrpc.IsJSONRPCRespErrorNoSuchDevice(err) {
+					break
+				}
+				return nil, errors.Wrapf(err, "failed to get range checksums of snapshot %v", n.Alias)
+			}
+			for cluster, checksum := range rangeChecksums {
+				checksums[cluster] = checksum
+			}
+		}
+	}
+	return checksums, nil
+}
+
+// bitmapOwner returns the first lvol whose bitmap has the cluster set.
+func bitmapOwner(cluster uint64, nodes []*LvolTreeNode, bitmaps [][]byte) *LvolTreeNode {
+	for i, bitmap := range bitmaps {
+		if cluster/8 < uint64(len(bitmap)) && bitmap[cluster/8]&(1<<(cluster%8)) != 0 {
+			return nodes[i]
+		}
+	}
+	return nil
+}
+
+func bitmapIndexes(bitmap []byte, numClusters uint64) []uint64 {
+	indexes := []uint64{}
+	for _, r := range spdktypes.ClusterRangesFromBitmap(bitmap, numClusters) {
+		for i := r.Start; i < r.Start+r.Count; i++ {
+			indexes = append(indexes, i)
+		}
+	}
+	return indexes
+}
@@ -0,0 +1,145 @@
+package client
+
+import (
+	"encoding/base64"
+	"reflect"
+	"strings"
+	"testing"
+
+	spdktypes "github.com/longhorn/go-spdk-helper/pkg/spdk/types"
+)
+
+func bitmapFragmapStep(name string, bitmap byte) jsonRPCScriptStep {
+	return jsonRPCScriptStep{
+		method: "bdev_lvol_get_fragmap",
+		params: map[string]interface{}{"name": name, "offset": float64(0), "size": float64(0)},
+		result: spdktypes.BdevLvolFragmap{
+			ClusterSize: 1024,
+			NumClusters: 8,
+			Fragmap:     base64.StdEncoding.EncodeToString([]byte{bitmap}),
+		},
+	}
+}
+
+func TestBdevLvolGetDiff(t *testing.T) {
+	chain := listLvolsStep(
+		treeLvol("s1", "lvs0/snap1", "", true),
+		treeLvol("s2", "lvs0/snap2", "snap1", true),
+		treeLvol("s3", "lvs0/snap3", "snap2", true),
+		treeLvol("v", "lvs0/vol", "snap3", false),
+	)
+
+	tests := []struct {
+		name        string
+		from        string
+		to          string
+		opts        LvolDiffOptions
+		steps       []jsonRPCScriptStep
+		want        []uint64
+		wantSources []LvolDiffSource
+		wantErr     string
+	}{
+		{
+			name: "fragmaps of the snapshots in between",
+			from: "lvs0/snap1",
+			to:   "lvs0/snap3",
+			steps: []jsonRPCScriptStep{
+				bitmapFragmapStep("s3", 0b00100001),
+				bitmapFragmapStep("s2", 0b00100010),
+			},
+			want: []uint64{0, 1, 5},
+			wantSources: []LvolDiffSource{
+				{Lvol: "lvs0/snap3", UUID: "s3", Clusters: []uint64{0, 5}},
+				{Lvol: "lvs0/snap2", UUID: "s2", Clusters: []uint64{1}},
+			},
+		},
+		{
+			name: "change only in the middle snapshot",
+			from: "lvs0/snap1",
+			to:   "lvs0/vol",
+			steps: []jsonRPCScriptStep{
+				bitmapFragmapStep("v", 0b00000001),
+				bitmapFragmapStep("s3", 0b00000000),
+				bitmapFragmapStep("s2", 0b00000100),
+			},
+			want: []uint64{0, 2},
+			wantSources: []LvolDiffSource{
+				{Lvol: "lvs0/vol", UUID: "v", Clusters: []uint64{0}},
+				{Lvol: "lvs0/snap2", UUID: "s2", Clusters: []uint64{2}},
+			},
+		},
+		{
+			name: "checksums drop the clusters rewritten with the same data",
+			from: "lvs0/snap1",
+			to:   "lvs0/snap3",
+			opts: LvolDiffOptions{UseChecksums: true},
+			steps: []jsonRPCScriptStep{
+				bitmapFragmapStep("s3", 0b00100001),
+				bitmapFragmapStep("s2", 0b00100010),
+				bitmapFragmapStep("s1", 0b00000111),
+				rangeChecksumsStep("s3", 0, 1, spdktypes.BdevLvolRangeChecksum{ClusterIndex: 0, Checksum: 7}),
+				rangeChecksumsStep("s2", 1, 1, spdktypes.BdevLvolRangeChecksum{ClusterIndex: 1, Checksum: 9}),
+				rangeChecksumsStep("s1", 0, 2,
+					spdktypes.BdevLvolRangeChecksum{ClusterIndex: 0, Checksum: 7},
+					spdktypes.BdevLvolRangeChecksum{ClusterIndex: 1, Checksum: 8}),
+			},
+			want: []uint64{1, 5},
+			wantSources: []LvolDiffSource{
+				{Lvol: "lvs0/snap3", UUID: "s3", Clusters: []uint64{5}},
+				{Lvol: "lvs0/snap2", UUID: "s2", Clusters: []uint64{1}},
+			},
+		},
+		{
+			name: "whole chain without from",
+			to:   "v",
+			steps: []jsonRPCScriptStep{
+				bitmapFragmapStep("v", 0b10000000),
+				bitmapFragmapStep("s3", 0b00000001),
+				bitmapFragmapStep("s2", 0b00000001),
+				bitmapFragmapStep("s1", 0b00000100),
+			},
+			want: []uint64{0, 2, 7},
+			wantSources: []LvolDiffSource{
+				{Lvol: "lvs0/vol", UUID: "v", Clusters: []uint64{7}},
+				{Lvol: "lvs0/snap3", UUID: "s3", Clusters: []uint64{0}},
+				{Lvol: "lvs0/snap1", UUID: "s1", Clusters: []uint64{2}},
+			},
+		},
+		{
+			name:    "to is not based on from",
+			from:    "lvs0/vol",
+			to:      "lvs0/snap2",
+			wantErr: "is not based on snapshot",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			steps := append([]jsonRPCScriptStep{chain}, test.steps...)
+			runJSONRPCScriptTest(t, steps, func(cli *Client) {
+				diff, err := cli.BdevLvolGetDiff(test.from, test.to, test.opts)
+				if test.wantErr != "" {
+					if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+						t.Fatalf("expected error %q, got %v", test.wantErr, err)
+					}
+					return
+				}
+				if err != nil {
+					t.Fatalf("failed to get diff: %v", err)
+				}
+				if !reflect.DeepEqual(diff.Clusters, test.want) {
+					t.Fatalf("got clusters %v, want %v", diff.Clusters, test.want)
+				}
+				if !reflect.DeepEqual(diff.Sources, test.wantSources) {
+					t.Fatalf("got sources %+v, want %+v", diff.Sources, test.wantSources)
+				}
+				if !reflect.DeepEqual(diff.Ranges, spdktypes.ClusterRangesFromIndexes(test.want)) {
+					t.Fatalf("got ranges %v for clusters %v", diff.Ranges, test.want)
+				}
+				if diff.ClusterSize != 1024 || diff.NumClusters != 8 {
+					t.Fatalf("unexpected geometry %+v", diff)
+				}
+			})
+		})
+	}
+}
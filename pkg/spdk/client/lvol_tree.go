@@ -0,0 +1,190 @@
+package client
+
+import (
+	"sort"
+
+	spdktypes "github.com/longhorn/go-spdk-helper/pkg/spdk/types"
+)
+
+// LvolTreeNode is a lvol in the snapshot chain tree. The parent of a node is
+// the snapshot it is based on, and its children are the lvols based on it.
+type LvolTreeNode struct {
+	UUID    string `json:"uuid"`
+	Alias   string `json:"alias"`
+	Name    string `json:"name"`
+	LvsUUID string `json:"lvs_uuid"`
+
+	Snapshot      bool `json:"snapshot"`
+	Clone         bool `json:"clone"`
+	ThinProvision bool `json:"thin_provision"`
+
+	SizeBytes            uint64            `json:"size_bytes"`
+	NumAllocatedClusters uint64            `json:"num_allocated_clusters"`
+	Xattrs               map[string]string `json:"xattrs,omitempty"`
+
+	// BaseSnapshot is the name of the parent snapshot as reported by SPDK. It
+	// is kept when the parent cannot be found, see LvolTree.Orphans.
+	BaseSnapshot string `json:"base_snapshot,omitempty"`
+
+	Parent   *LvolTreeNode   `json:"-"`
+	Children []*LvolTreeNode `json:"children,omitempty"`
+}
+
+// LvolTree is the forest of snapshot chains of the lvols, built from a single
+// bdev_get_bdevs call.
+type LvolTree struct {
+	// Roots are the lvols without parent, sorted by alias.
+	Roots []*LvolTreeNode `json:"roots"`
+
+	nodes map[string]*LvolTreeNode
+}
+
+// BdevLvolGetTree builds the snapshot chain tree of all lvols from one
+// bdev_get_bdevs call. The xattrs are the ones bdev_get_bdevs reports; unlike
+// BdevLvolGet, no xattr is fetched separately.
+func (c *Client) BdevLvolGetTree() (*LvolTree, error) {
+	bdevs, err := c.BdevGetBdevs("", 0)
+	if err != nil {
+		return nil, err
+	}
+	return NewLvolTree(bdevs), nil
+}
+
+// NewLvolTree builds the snapshot chain tree from the bdev list. Bdevs that
+// are not lvols are ignored. The parent of a lvol is looked up by name in the
+// same lvstore, since SPDK reports base_snapshot as a lvol name.
+func NewLvolTree(bdevs []spdktypes.BdevInfo) *LvolTree {
+	t := &LvolTree{
+		nodes: map[string]*LvolTreeNode{},
+	}
+
+	byLvsName := map[string]*LvolTreeNode{}
+	ordered := []*LvolTreeNode{}
+	for i := range bdevs {
+		b := &bdevs[i]
+		if spdktypes.GetBdevType(b) != spdktypes.BdevTypeLvol {
+			continue
+		}
+		lvol := b.DriverSpecific.Lvol
+
+		alias := ""
+		if len(b.Aliases) > 0 {
+			alias = b.Aliases[0]
+		}
+		node := &LvolTreeNode{
+			UUID:                 b.Name,
+			Alias:                alias,
+			Name:                 spdktypes.GetLvolNameFromAlias(alias),
+			LvsUUID:              lvol.LvolStoreUUID,
+			Snapshot:             lvol.Snapshot,
+			Clone:                lvol.Clone,
+			ThinProvision:        lvol.ThinProvision,
+			SizeBytes:            uint64(b.BlockSize) * b.NumBlocks,
+			NumAllocatedClusters: lvol.NumAllocatedClusters,
+			Xattrs:               lvol.Xattrs,
+			BaseSnapshot:         lvol.BaseSnapshot,
+		}
+
+		t.nodes[node.UUID] = node
+		if alias != "" {
+			t.nodes[alias] = node
+		}
+		byLvsName[lvsNameKey(node.LvsUUID, node.Name)] = node
+		ordered = append(ordered, node)
+	}
+
+	sort.Slice(ordered, func(i, j int) bool { return ordered[i].Alias < ordered[j].Alias })
+
+	for _, node := range ordered {
+		parent, ok := byLvsName[lvsNameKey(node.LvsUUID, node.BaseSnapshot)]
+		if node.BaseSnapshot == "" || !ok || parent == node {
+			t.Roots = append(t.Roots, node)
+			continue
+		}
+		node.Parent = parent
+		parent.Children = append(parent.Children, node)
+	}
+
+	return t
+}
+
+func lvsNameKey(lvsUUID, name string) string {
+	return lvsUUID + "/" + name
+}
+
+// Get returns the node of the lvol with the given UUID or alias, or nil.
+func (t *LvolTree) Get(name string) *LvolTreeNode {
+	return t.nodes[name]
+}
+
+// Nodes returns all nodes, sorted by alias.
+func (t *LvolTree) Nodes() []*LvolTreeNode {
+	nodes := []*LvolTreeNode{}
+	for _, root := range t.Roots {
+		nodes = append(nodes, root)
+		nodes = append(nodes, root.Descendants()...)
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].Alias < nodes[j].Alias })
+	return nodes
+}
+
+// Orphans returns the lvols whose base snapshot is reported but cannot be
+// found, e.g. because the snapshot was deleted while the lvol was being
+// listed, or the lvstore metadata is inconsistent.
+func (t *LvolTree) Orphans() []*LvolTreeNode {
+	orphans := []*LvolTreeNode{}
+	for _, root := range t.Roots {
+		if root.BaseSnapshot != "" {
+			orphans = append(orphans, root)
+		}
+	}
+	return orphans
+}
+
+// Ancestors returns the snapshots the lvol is based on, from its parent up to
+// the root of the chain.
+func (n *LvolTreeNode) Ancestors() []*LvolTreeNode {
+	ancestors := []*LvolTreeNode{}
+	for p := n.Parent; p != nil; p = p.Parent {
+		ancestors = append(ancestors, p)
+	}
+	return ancestors
+}
+
+// Descendants returns the lvols based on the lvol, directly or indirectly, in
+// depth-first order.
+func (n *LvolTreeNode) Descendants() []*LvolTreeNode {
+	descendants := []*LvolTreeNode{}
+	for _, child := range n.Children {
+		descendants = append(descendants, child)
+		descendants = append(descendants, child.Descendants()...)
+	}
+	return descendants
+}
+
+// Root returns the root of the chain the lvol belongs to.
+func (n *LvolTreeNode) Root() *LvolTreeNode {
+	root := n
+	for root.Parent != nil {
+		root = root.Parent
+	}
+	return root
+}
+
+// Heads returns the chain heads in the subtree of the lvol, which are the
+// lvols that are not snapshots. A lvol that is not a snapshot is its own head.
+func (n *LvolTreeNode) Heads() []*LvolTreeNode {
+	if !n.Snapshot {
+		return []*LvolTreeNode{n}
+	}
+	heads := []*LvolTreeNode{}
+	for _, child := range n.Children {
+		heads = append(heads, child.Heads()...)
+	}
+	return heads
+}
+
+// IsInLvstore reports whether the lvol alias is in the lvstore with the given name.
+func (n *LvolTreeNode) IsInLvstore(lvsName string) bool {
+	return spdktypes.GetLvsNameFromAlias(n.Alias) == lvsName
+}
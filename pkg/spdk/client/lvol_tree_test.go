@@ -0,0 +1,90 @@
+package client
+
+import (
+	"reflect"
+	"testing"
+
+	spdktypes "github.com/longhorn/go-spdk-helper/pkg/spdk/types"
+)
+
+func treeLvol(uuid, alias, baseSnapshot string, snapshot bool) spdktypes.BdevInfo {
+	return spdktypes.BdevInfo{
+		BdevInfoBasic: spdktypes.BdevInfoBasic{
+			Name:        uuid,
+			Aliases:     []string{alias},
+			ProductName: spdktypes.BdevProductNameLvol,
+			BlockSize:   4096,
+			NumBlocks:   256,
+		},
+		DriverSpecific: &spdktypes.BdevDriverSpecific{
+			Lvol: &spdktypes.BdevDriverSpecificLvol{
+				LvolStoreUUID:        "lvs-uuid",
+				BaseSnapshot:         baseSnapshot,
+				Snapshot:             snapshot,
+				NumAllocatedClusters: 2,
+			},
+		},
+	}
+}
+
+func treeAliases(nodes []*LvolTreeNode) []string {
+	aliases := []string{}
+	for _, n := range nodes {
+		aliases = append(aliases, n.Alias)
+	}
+	return aliases
+}
+
+func TestLvolTree(t *testing.T) {
+	bdevs := []spdktypes.BdevInfo{
+		treeLvol("u-vol", "lvs0/vol", "snap2", false),
+		treeLvol("u-snap2", "lvs0/snap2", "snap1", true),
+		treeLvol("u-snap1", "lvs0/snap1", "", true),
+		treeLvol("u-clone", "lvs0/clone", "snap1", false),
+		treeLvol("u-lost", "lvs0/lost", "deleted-snap", false),
+		{BdevInfoBasic: spdktypes.BdevInfoBasic{Name: "aio0", ProductName: spdktypes.BdevProductNameAio}},
+	}
+
+	var tree *LvolTree
+	runJSONRPCScriptTest(t, []jsonRPCScriptStep{listLvolsStep(bdevs...)}, func(cli *Client) {
+		var err error
+		if tree, err = cli.BdevLvolGetTree(); err != nil {
+			t.Fatalf("BdevLvolGetTree failed: %v", err)
+		}
+	})
+
+	if got, want := treeAliases(tree.Roots), []string{"lvs0/lost", "lvs0/snap1"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("roots: got %v, want %v", got, want)
+	}
+	if got, want := treeAliases(tree.Orphans()), []string{"lvs0/lost"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("orphans: got %v, want %v", got, want)
+	}
+	if got := len(tree.Nodes()); got != 5 {
+		t.Fatalf("expected 5 lvol nodes, got %d", got)
+	}
+
+	vol := tree.Get("u-vol")
+	if vol == nil || tree.Get("lvs0/vol") != vol {
+		t.Fatal("expected the lvol to be found by UUID and alias")
+	}
+	if vol.SizeBytes != 4096*256 || vol.NumAllocatedClusters != 2 {
+		t.Fatalf("unexpected lvol size %+v", vol)
+	}
+	if got, want := treeAliases(vol.Ancestors()), []string{"lvs0/snap2", "lvs0/snap1"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("ancestors: got %v, want %v", got, want)
+	}
+	if vol.Root() != tree.Get("lvs0/snap1") {
+		t.Fatalf("unexpected root %v", vol.Root().Alias)
+	}
+
+	snap1 := tree.Get("lvs0/snap1")
+	if got, want := treeAliases(snap1.Descendants()), []string{"lvs0/clone", "lvs0/snap2", "lvs0/vol"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("descendants: got %v, want %v", got, want)
+	}
+	if got, want := treeAliases(snap1.Heads()), []string{"lvs0/clone", "lvs0/vol"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("heads: got %v, want %v", got, want)
+	}
+	if !snap1.IsInLvstore("lvs0") || snap1.IsInLvstore("lvs") {
+		t.Fatal("unexpected lvstore match")
+	}
+}
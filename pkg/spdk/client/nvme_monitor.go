@@ -0,0 +1,271 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	spdktypes "github.com/longhorn/go-spdk-helper/pkg/spdk/types"
+)
+
+const DefaultNvmeMonitorInterval = 10 * time.Second
+
+type NvmeControllerEventType string
+
+const (
+	// NvmeControllerEventStateChanged is emitted when a controller path enters
+	// or leaves an unhealthy state such as resetting or failed.
+	NvmeControllerEventStateChanged = NvmeControllerEventType("state_changed")
+	// NvmeControllerEventCriticalWarning is emitted when the critical warning
+	// bits of a controller change to a non-zero value.
+	NvmeControllerEventCriticalWarning = NvmeControllerEventType("critical_warning")
+	// NvmeControllerEventSpareLow is emitted when the available spare drops
+	// below the threshold.
+	NvmeControllerEventSpareLow = NvmeControllerEventType("spare_low")
+	// NvmeControllerEventTemperatureHigh is emitted when the temperature
+	// reaches the threshold.
+	NvmeControllerEventTemperatureHigh = NvmeControllerEventType("temperature_high")
+	// NvmeControllerEventRecovered is emitted when a condition reported by one
+	// of the events above clears.
+	NvmeControllerEventRecovered = NvmeControllerEventType("recovered")
+)
+
+// NvmeControllerEvent describes a change of an NVMe bdev controller noticed by
+// the NvmeMonitor.
+type NvmeControllerEvent struct {
+	Type       NvmeControllerEventType
+	Controller string
+	Time       time.Time
+
+	// Cntlid, Trid, PreviousState and State are set for state events.
+	Cntlid        uint16
+	Trid          spdktypes.NvmeTransportID
+	PreviousState spdktypes.NvmeControllerState
+	State         spdktypes.NvmeControllerState
+
+	// Condition is the event type that cleared for a recovered event.
+	Condition NvmeControllerEventType
+
+	// Health is set for the events derived from the controller health info.
+	Health *spdktypes.BdevNvmeControllerHealthInfo
+}
+
+type NvmeMonitorOptions struct {
+	// Interval is the poll interval. DefaultNvmeMonitorInterval is used if it is 0.
+	Interval time.Duration
+	// AvailableSpareThresholdPercentage is the available spare below which
+	// a spare_low event is emitted. The threshold reported by the controller
+	// is used if it is 0.
+	AvailableSpareThresholdPercentage uint32
+	// TemperatureThresholdCelsius is the temperature at which a
+	// temperature_high event is emitted. The check is disabled if it is 0.
+	TemperatureThresholdCelsius float64
+}
+
+// NvmeMonitor polls the NVMe bdev controllers, their health info and the
+// transport statistics, and emits an event when a controller becomes unhealthy
+// or recovers. Conditions are edge triggered: an event is emitted once when a
+// condition appears and once when it clears.
+type NvmeMonitor struct {
+	cli  *Client
+	opts NvmeMonitorOptions
+
+	lock       sync.RWMutex
+	states     map[string]spdktypes.NvmeControllerState
+	conditions map[string]map[NvmeControllerEventType]bool
+	warnings   map[string]uint32
+	stats      spdktypes.BdevNvmeTransportStatistics
+}
+
+func NewNvmeMonitor(cli *Client, opts NvmeMonitorOptions) *NvmeMonitor {
+	if opts.Interval == 0 {
+		opts.Interval = DefaultNvmeMonitorInterval
+	}
+	return &NvmeMonitor{
+		cli:        cli,
+		opts:       opts,
+		states:     map[string]spdktypes.NvmeControllerState{},
+		conditions: map[string]map[NvmeControllerEventType]bool{},
+		warnings:   map[string]uint32{},
+	}
+}
+
+// Run polls until the context is done and sends the events to the channel.
+// Poll failures are logged and retried at the next interval.
+func (m *NvmeMonitor) Run(ctx context.Context, events chan<- NvmeControllerEvent) {
+	ticker := time.NewTicker(m.opts.Interval)
+	defer ticker.Stop()
+
+	for {
+		polled, err := m.Poll()
+		if err != nil {
+			logrus.WithError(err).Warn("Failed to poll NVMe bdev controllers")
+		}
+		for _, event := range polled {
+			select {
+			case events <- event:
+			case <-ctx.Done():
+				return
+			}
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// TransportStatistics returns the transport statistics of the last poll.
+func (m *NvmeMonitor) TransportStatistics() spdktypes.BdevNvmeTransportStatistics {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	return m.stats
+}
+
+// Poll checks the controllers once and returns the events since the last poll.
+// The health info of a controller that cannot be fetched is skipped, so that
+// one unreachable controller does not hide the events of the others, and
+// transport statistics that cannot be fetched keep their previous value.
+func (m *NvmeMonitor) Poll() ([]NvmeControllerEvent, error) {
+	controllers, err := m.cli.BdevNvmeGetControllers("")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get NVMe bdev controllers: %w", err)
+	}
+
+	stats, statsErr := m.cli.BdevNvmeGetTransportStatistics()
+	if statsErr != nil {
+		logrus.WithError(statsErr).Warn("Failed to get NVMe bdev transport statistics")
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if statsErr == nil {
+		m.stats = stats
+	}
+	now := time.Now()
+	events := []NvmeControllerEvent{}
+
+	seenStates := map[string]bool{}
+	seenControllers := map[string]bool{}
+	for _, controller := range controllers {
+		seenControllers[controller.Name] = true
+		for _, ctrlr := range controller.Ctrlrs {
+			key := fmt.Sprintf("%s/%d", controller.Name, ctrlr.Cntlid)
+			seenStates[key] = true
+
+			state := spdktypes.NvmeControllerState(ctrlr.State)
+			previous, known := m.states[key]
+			m.states[key] = state
+			if state == previous || (!known && !isNvmeControllerStateUnhealthy(state)) {
+				continue
+			}
+			if !isNvmeControllerStateUnhealthy(state) && !isNvmeControllerStateUnhealthy(previous) {
+				continue
+			}
+			events = append(events, NvmeControllerEvent{
+				Type:          NvmeControllerEventStateChanged,
+				Controller:    controller.Name,
+				Time:          now,
+				Cntlid:        ctrlr.Cntlid,
+				Trid:          ctrlr.Trid,
+				PreviousState: previous,
+				State:         state,
+			})
+		}
+
+		healthInfo, err := m.cli.BdevNvmeGetControllerHealthInfo(controller.Name)
+		if err != nil {
+			logrus.WithError(err).Debugf("Failed to get health info of NVMe bdev controller %v", controller.Name)
+			continue
+		}
+		events = append(events, m.checkHealth(controller.Name, &healthInfo, now)...)
+	}
+
+	// Forget the controllers that are gone, so that one added back with the
+	// same name reports its events again.
+	for key := range m.states {
+		if !seenStates[key] {
+			delete(m.states, key)
+		}
+	}
+	for name := range m.warnings {
+		if !seenControllers[name] {
+			delete(m.warnings, name)
+		}
+	}
+	for name := range m.conditions {
+		if !seenControllers[name] {
+			delete(m.conditions, name)
+		}
+	}
+
+	return events, nil
+}
+
+func (m *NvmeMonitor) checkHealth(name string, healthInfo *spdktypes.BdevNvmeControllerHealthInfo, now time.Time) []NvmeControllerEvent {
+	events := []NvmeControllerEvent{}
+
+	newEvent := func(eventType, condition NvmeControllerEventType) NvmeControllerEvent {
+		return NvmeControllerEvent{
+			Type:       eventType,
+			Controller: name,
+			Time:       now,
+			Condition:  condition,
+			Health:     healthInfo,
+		}
+	}
+
+	if healthInfo.CriticalWarning != m.warnings[name] {
+		if healthInfo.CriticalWarning != 0 {
+			events = append(events, newEvent(NvmeControllerEventCriticalWarning, ""))
+		} else {
+			events = append(events, newEvent(NvmeControllerEventRecovered, NvmeControllerEventCriticalWarning))
+		}
+		m.warnings[name] = healthInfo.CriticalWarning
+	}
+
+	spareThreshold := m.opts.AvailableSpareThresholdPercentage
+	if spareThreshold == 0 {
+		spareThreshold = healthInfo.AvailableSpareThresholdPercentage
+	}
+	spareLow := healthInfo.AvailableSparePercentage < spareThreshold
+	events = append(events, m.checkCondition(name, NvmeControllerEventSpareLow, spareLow, newEvent)...)
+
+	temperatureHigh := m.opts.TemperatureThresholdCelsius != 0 &&
+		healthInfo.TemperatureCelsius != spdktypes.UnknownTemperature &&
+		healthInfo.TemperatureCelsius >= m.opts.TemperatureThresholdCelsius
+	events = append(events, m.checkCondition(name, NvmeControllerEventTemperatureHigh, temperatureHigh, newEvent)...)
+
+	return events
+}
+
+func (m *NvmeMonitor) checkCondition(name string, condition NvmeControllerEventType, active bool,
+	newEvent func(eventType, condition NvmeControllerEventType) NvmeControllerEvent) []NvmeControllerEvent {
+	if m.conditions[name][condition] == active {
+		return nil
+	}
+	if m.conditions[name] == nil {
+		m.conditions[name] = map[NvmeControllerEventType]bool{}
+	}
+	m.conditions[name][condition] = active
+	if active {
+		return []NvmeControllerEvent{newEvent(condition, "")}
+	}
+	return []NvmeControllerEvent{newEvent(NvmeControllerEventRecovered, condition)}
+}
+
+func isNvmeControllerStateUnhealthy(state spdktypes.NvmeControllerState) bool {
+	switch state {
+	case spdktypes.NvmeControllerStateResetting,
+		spdktypes.NvmeControllerStateReconnectIsDelayed,
+		spdktypes.NvmeControllerStateFailed:
+		return true
+	}
+	return false
+}
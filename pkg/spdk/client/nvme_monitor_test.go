@@ -0,0 +1,120 @@
+package client
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/longhorn/go-spdk-helper/pkg/jsonrpc"
+	spdktypes "github.com/longhorn/go-spdk-helper/pkg/spdk/types"
+)
+
+func TestNvmeMonitorPoll(t *testing.T) {
+	controllersStep := func(state string) jsonRPCScriptStep {
+		return jsonRPCScriptStep{
+			method: "bdev_nvme_get_controllers",
+			result: []spdktypes.BdevNvmeControllerInfo{
+				{Name: "Nvme0", Ctrlrs: []spdktypes.NvmeControllerInfo{{State: state, Cntlid: 1}}},
+			},
+		}
+	}
+	statsStep := jsonRPCScriptStep{
+		method: "bdev_nvme_get_transport_statistics",
+		result: spdktypes.BdevNvmeTransportStatistics{
+			PollGroups: []spdktypes.BdevNvmePollGroupTransportStatistics{
+				{Thread: "nvmf_tgt_poll_group_000", Transports: []spdktypes.NvmeTransportStatistic{{Trname: "TCP", Polls: 10}}},
+			},
+		},
+	}
+	statsErrStep := jsonRPCScriptStep{
+		method:        "bdev_nvme_get_transport_statistics",
+		responseError: &jsonrpc.ResponseError{Code: jsonrpc.RespErrorCodeInternalError, Message: "Internal error"},
+	}
+	healthStep := func(criticalWarning, spare uint32, temperature float64) jsonRPCScriptStep {
+		return jsonRPCScriptStep{
+			method: "bdev_nvme_get_controller_health_info",
+			params: map[string]interface{}{"name": "Nvme0"},
+			result: spdktypes.BdevNvmeControllerHealthInfo{
+				CriticalWarning:                   criticalWarning,
+				AvailableSparePercentage:          spare,
+				AvailableSpareThresholdPercentage: 10,
+				TemperatureCelsius:                temperature,
+			},
+		}
+	}
+
+	steps := []jsonRPCScriptStep{
+		controllersStep("enabled"), statsStep, healthStep(0, 5, 40),
+		controllersStep("resetting"), statsStep, healthStep(1, 50, 75),
+		controllersStep("enabled"), statsErrStep, healthStep(1, 50, 75),
+		{method: "bdev_nvme_get_controllers", result: []spdktypes.BdevNvmeControllerInfo{}}, statsStep,
+		controllersStep("enabled"), statsStep, healthStep(1, 50, 75),
+	}
+
+	eventTypes := func(events []NvmeControllerEvent) []NvmeControllerEventType {
+		types := []NvmeControllerEventType{}
+		for _, e := range events {
+			types = append(types, e.Type)
+		}
+		return types
+	}
+
+	runJSONRPCScriptTest(t, steps, func(cli *Client) {
+		monitor := NewNvmeMonitor(cli, NvmeMonitorOptions{TemperatureThresholdCelsius: 70})
+
+		events, err := monitor.Poll()
+		if err != nil {
+			t.Fatalf("first poll failed: %v", err)
+		}
+		if got, want := eventTypes(events), []NvmeControllerEventType{NvmeControllerEventSpareLow}; !reflect.DeepEqual(got, want) {
+			t.Fatalf("first poll: got events %v, want %v", got, want)
+		}
+		if stats := monitor.TransportStatistics(); len(stats.PollGroups) != 1 || stats.PollGroups[0].Transports[0].Polls != 10 {
+			t.Fatalf("unexpected transport statistics %+v", stats)
+		}
+
+		events, err = monitor.Poll()
+		if err != nil {
+			t.Fatalf("second poll failed: %v", err)
+		}
+		want := []NvmeControllerEventType{
+			NvmeControllerEventStateChanged,
+			NvmeControllerEventCriticalWarning,
+			NvmeControllerEventRecovered,
+			NvmeControllerEventTemperatureHigh,
+		}
+		if got := eventTypes(events); !reflect.DeepEqual(got, want) {
+			t.Fatalf("second poll: got events %v, want %v", got, want)
+		}
+		if events[0].PreviousState != spdktypes.NvmeControllerStateEnabled || events[0].State != spdktypes.NvmeControllerStateResetting {
+			t.Fatalf("unexpected state event %+v", events[0])
+		}
+		if events[2].Condition != NvmeControllerEventSpareLow {
+			t.Fatalf("unexpected recovered event %+v", events[2])
+		}
+
+		events, err = monitor.Poll()
+		if err != nil {
+			t.Fatalf("third poll failed: %v", err)
+		}
+		if got, want := eventTypes(events), []NvmeControllerEventType{NvmeControllerEventStateChanged}; !reflect.DeepEqual(got, want) {
+			t.Fatalf("third poll: got events %v, want %v", got, want)
+		}
+		if stats := monitor.TransportStatistics(); len(stats.PollGroups) != 1 {
+			t.Fatalf("expected the transport statistics of the previous poll, got %+v", stats)
+		}
+
+		// A controller removed and added back with the same name reports its
+		// conditions again.
+		if events, err = monitor.Poll(); err != nil || len(events) != 0 {
+			t.Fatalf("fourth poll: got events %v, error %v", eventTypes(events), err)
+		}
+		events, err = monitor.Poll()
+		if err != nil {
+			t.Fatalf("fifth poll failed: %v", err)
+		}
+		want = []NvmeControllerEventType{NvmeControllerEventCriticalWarning, NvmeControllerEventTemperatureHigh}
+		if got := eventTypes(events); !reflect.DeepEqual(got, want) {
+			t.Fatalf("fifth poll: got events %v, want %v", got, want)
+		}
+	})
+}
@@ -0,0 +1,301 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/longhorn/go-spdk-helper/pkg/jsonrpc"
+	spdktypes "github.com/longhorn/go-spdk-helper/pkg/spdk/types"
+	"github.com/longhorn/go-spdk-helper/pkg/types"
+)
+
+const DefaultReplicaRebuildCheckInterval = 3 * time.Second
+
+type ReplicaRebuildPhase string
+
+const (
+	ReplicaRebuildPhaseExposing = ReplicaRebuildPhase("exposing")
+	ReplicaRebuildPhaseCloning  = ReplicaRebuildPhase("cloning")
+	ReplicaRebuildPhaseCopying  = ReplicaRebuildPhase("copying")
+	ReplicaRebuildPhaseCleaning = ReplicaRebuildPhase("cleaning")
+	ReplicaRebuildPhaseComplete = ReplicaRebuildPhase("complete")
+)
+
+// ReplicaRebuildSpec describes the rebuild of a replica from a snapshot of a
+// remote replica.
+type ReplicaRebuildSpec struct {
+	// Source is the client of the target of the remote replica.
+	Source *Client
+	// SourceSnapshot is the UUID or alias of the snapshot to rebuild from.
+	SourceSnapshot string
+	// SourceIP and SourcePort are the NVMe/TCP address the snapshot is exposed on.
+	SourceIP   string
+	SourcePort string
+
+	// LvsName and CloneName are the lvstore and the name of the rebuilt lvol.
+	LvsName   string
+	CloneName string
+
+	// ControllerName is the name of the local NVMe controller attached to the
+	// exposed snapshot. "rebuild-<CloneName>" is used if it is empty.
+	ControllerName string
+
+	// CheckInterval is the interval between progress checks.
+	// DefaultReplicaRebuildCheckInterval is used if it is 0.
+	CheckInterval time.Duration
+	// OnProgress is called on every phase change and progress check. It is optional.
+	OnProgress func(ReplicaRebuildProgress)
+}
+
+// ReplicaRebuildProgress is the progress of a replica rebuild.
+type ReplicaRebuildProgress struct {
+	Phase     ReplicaRebuildPhase `json:"phase"`
+	CloneUUID string              `json:"clone_uuid,omitempty"`
+	// CopiedClusters counts the clusters of the clone that are allocated locally.
+	CopiedClusters uint64 `json:"copied_clusters"`
+	TotalClusters  uint64 `json:"total_clusters"`
+}
+
+// RebuildReplica rebuilds a local lvol from a snapshot of a remote replica.
+// The snapshot is exposed over NVMe/TCP on the source target and attached
+// locally, and an external snapshot clone of it is created, so the rebuilt
+// lvol is readable right away. The data is then copied in the background by
+// decoupling the clone from the external snapshot, which also removes the
+// dependency on it; BdevLvolDetachParent does not apply to an external
+// snapshot parent. Finally the controller is detached and the snapshot is no
+// longer exposed.
+//
+// Every step checks the current state first, so a rebuild interrupted by an
+// error or by the context is resumed by calling RebuildReplica again with the
+// same spec. The exposed snapshot and the controller are kept for the resume
+// once the clone exists, and are removed otherwise. The decouple keeps running
+// in SPDK when the context is done; a resumed rebuild waits for it rather
+// than starting another one.
+func (c *Client) RebuildReplica(ctx context.Context, spec ReplicaRebuildSpec) (cloneUUID string, err error) {
+	if spec.ControllerName == "" {
+		spec.ControllerName = "rebuild-" + spec.CloneName
+	}
+	if spec.CheckInterval == 0 {
+		spec.CheckInterval = DefaultReplicaRebuildCheckInterval
+	}
+	nqn := types.GetNQN(spec.ControllerName)
+
+	report := func(progress ReplicaRebuildProgress) {
+		if spec.OnProgress != nil {
+			spec.OnProgress(progress)
+		}
+	}
+
+	clone, err := c.getLvolInfo(spec.LvsName, spec.CloneName)
+	if err != nil {
+		return "", err
+	}
+
+	if clone == nil || clone.IsEsnapClone {
+		var rollback rollbackSteps
+		defer func() {
+			if err != nil && clone == nil {
+				rollback.run(fmt.Sprintf("rebuild of replica %v/%v", spec.LvsName, spec.CloneName))
+			}
+		}()
+
+		report(ReplicaRebuildProgress{Phase: ReplicaRebuildPhaseExposing})
+		esnapBdev, err := c.attachRebuildSource(spec, nqn, &rollback)
+		if err != nil {
+			return "", err
+		}
+
+		if clone == nil {
+			report(ReplicaRebuildProgress{Phase: ReplicaRebuildPhaseCloning})
+			if _, err := c.BdevLvolCloneEsnap(esnapBdev, spec.LvsName, spec.CloneName); err != nil {
+				return "", errors.Wrapf(err, "failed to create external snapshot clone %v/%v", spec.LvsName, spec.CloneName)
+			}
+			if clone, err = c.getLvolInfo(spec.LvsName, spec.CloneName); err != nil {
+				return "", err
+			}
+			if clone == nil {
+				return "", fmt.Errorf("cannot find external snapshot clone %v/%v after creation", spec.LvsName, spec.CloneName)
+			}
+		}
+
+		if err := c.copyRebuildData(ctx, spec, clone.UUID, report); err != nil {
+			return "", err
+		}
+	}
+
+	report(ReplicaRebuildProgress{Phase: ReplicaRebuildPhaseCleaning, CloneUUID: clone.UUID})
+	if err := c.cleanupRebuildSource(spec, nqn); err != nil {
+		return "", err
+	}
+
+	report(ReplicaRebuildProgress{Phase: ReplicaRebuildPhaseComplete, CloneUUID: clone.UUID})
+	return clone.UUID, nil
+}
+
+// attachRebuildSource exposes the source snapshot and attaches it locally
+// unless it is already attached, and returns the name of the local bdev.
+func (c *Client) attachRebuildSource(spec ReplicaRebuildSpec, nqn string, rollback *rollbackSteps) (string, error) {
+	esnapBdev := spec.ControllerName + "n1"
+
+	bdevs, err := c.BdevGetBdevs(esnapBdev, 0)
+	if err != nil && !jsonrpc.IsJSONRPCRespErrorNoSuchDevice(err) {
+		return "", err
+	}
+	if err == nil && len(bdevs) > 0 {
+		return esnapBdev, nil
+	}
+
+	if err := spec.Source.StartExposeBdev(nqn, spec.SourceSnapshot, "", spec.SourceIP, spec.SourcePort); err != nil {
+		return "", errors.Wrapf(err, "failed to expose source snapshot %v", spec.SourceSnapshot)
+	}
+	rollback.push(func() error { return spec.Source.StopExposeBdev(nqn) })
+
+	bdevNames, err := c.BdevNvmeAttachController(spec.ControllerName, nqn, spec.SourceIP, spec.SourcePort,
+		spdktypes.NvmeTransportTypeTCP, DetectAddressFamily(spec.SourceIP),
+		types.DefaultCtrlrLossTimeoutSec, types.DefaultReconnectDelaySec, types.DefaultFastIOFailTimeoutSec,
+		types.DefaultMultipath, "")
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to attach source snapshot %v", spec.SourceSnapshot)
+	}
+	rollback.push(func() error {
+		_, err := c.BdevNvmeDetachController(spec.ControllerName)
+		return err
+	})
+	if len(bdevNames) != 1 {
+		return "", fmt.Errorf("unexpected bdevs %v of the controller of source snapshot %v", bdevNames, spec.SourceSnapshot)
+	}
+
+	return bdevNames[0], nil
+}
+
+// lvolDecouple is a bdev_lvol_decouple_parent call in flight.
+type lvolDecouple struct {
+	done chan struct{}
+	err  error
+}
+
+var (
+	lvolDecouplesLock sync.Mutex
+	// lvolDecouples are the decouples in flight by lvol UUID.
+	lvolDecouples = map[string]*lvolDecouple{}
+)
+
+// startDecoupleParent decouples the lvol from its parent in the background,
+// unless a decouple of the lvol started by this process is still in flight,
+// in which case that one is returned.
+func (c *Client) startDecoupleParent(lvolUUID string) *lvolDecouple {
+	lvolDecouplesLock.Lock()
+	defer lvolDecouplesLock.Unlock()
+
+	if d, ok := lvolDecouples[lvolUUID]; ok {
+		return d
+	}
+	d := &lvolDecouple{done: make(chan struct{})}
+	lvolDecouples[lvolUUID] = d
+	go func() {
+		_, err := c.BdevLvolDecoupleParent(lvolUUID)
+		lvolDecouplesLock.Lock()
+		delete(lvolDecouples, lvolUUID)
+		lvolDecouplesLock.Unlock()
+		d.err = err
+		close(d.done)
+	}()
+	return d
+}
+
+// copyRebuildData decouples the clone from its external snapshot in the
+// background and reports the allocated clusters of the clone until it is done.
+// If SPDK rejects the decouple as busy, one started by another process is in
+// flight, and the clone is polled until it is no longer an esnap clone.
+func (c *Client) copyRebuildData(ctx context.Context, spec ReplicaRebuildSpec, cloneUUID string, report func(ReplicaRebuildProgress)) error {
+	check := func() {
+		fragmap, err := c.BdevLvolGetFragmap(cloneUUID, 0, 0)
+		if err != nil {
+			logrus.WithError(err).Warnf("Failed to check the rebuild progress of lvol %v", cloneUUID)
+			return
+		}
+		report(ReplicaRebuildProgress{
+			Phase:          ReplicaRebuildPhaseCopying,
+			CloneUUID:      cloneUUID,
+			CopiedClusters: fragmap.NumAllocatedClusters,
+			TotalClusters:  fragmap.NumClusters,
+		})
+	}
+
+	decoupled := func() (bool, error) {
+		clone, err := c.getLvolInfo(spec.LvsName, spec.CloneName)
+		if err != nil {
+			return false, err
+		}
+		if clone == nil || clone.UUID != cloneUUID {
+			return false, fmt.Errorf("cannot find external snapshot clone %v/%v", spec.LvsName, spec.CloneName)
+		}
+		return !clone.IsEsnapClone, nil
+	}
+
+	decouple := c.startDecoupleParent(cloneUUID)
+	done := decouple.done
+
+	ticker := time.NewTicker(spec.CheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			if decouple.err == nil {
+				check()
+				return nil
+			}
+			if !jsonrpc.IsJSONRPCRespErrorDeviceOrResourceBusy(decouple.err) {
+				return errors.Wrapf(decouple.err, "failed to copy the data of external snapshot clone %v", cloneUUID)
+			}
+			logrus.Infof("Waiting for the decouple of external snapshot clone %v already in progress", cloneUUID)
+			done = nil
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+		check()
+		if done == nil {
+			finished, err := decoupled()
+			if err != nil {
+				return err
+			}
+			if finished {
+				return nil
+			}
+		}
+	}
+}
+
+// cleanupRebuildSource detaches the controller and stops exposing the source
+// snapshot. Both are no-ops if already done.
+func (c *Client) cleanupRebuildSource(spec ReplicaRebuildSpec, nqn string) error {
+	if _, err := c.BdevNvmeDetachController(spec.ControllerName); err != nil && !jsonrpc.IsJSONRPCRespErrorNoSuchDevice(err) {
+		return errors.Wrapf(err, "failed to detach controller %v", spec.ControllerName)
+	}
+	if err := spec.Source.StopExposeBdev(nqn); err != nil {
+		return errors.Wrapf(err, "failed to stop exposing source snapshot %v", spec.SourceSnapshot)
+	}
+	return nil
+}
+
+// getLvolInfo returns the lvol with the given name in the lvstore, or nil.
+func (c *Client) getLvolInfo(lvsName, lvolName string) (*spdktypes.LvolInfo, error) {
+	lvols, err := c.BdevLvolGetLvols(lvsName, "")
+	if err != nil {
+		return nil, err
+	}
+	for i := range lvols {
+		if lvols[i].Name == lvolName {
+			return &lvols[i], nil
+		}
+	}
+	return nil, nil
+}
@@ -0,0 +1,219 @@
+package client
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/longhorn/go-spdk-helper/pkg/jsonrpc"
+	spdktypes "github.com/longhorn/go-spdk-helper/pkg/spdk/types"
+	"github.com/longhorn/go-spdk-helper/pkg/types"
+)
+
+func TestRebuildReplica(t *testing.T) {
+	nqn := types.GetNQN("rebuild-vol")
+	noDevice := &jsonrpc.ResponseError{Code: jsonrpc.RespErrorCodeNoSuchDevice, Message: "No such device"}
+	listenAddress := map[string]interface{}{"trtype": "tcp", "adrfam": "ipv4", "traddr": "10.0.0.2", "trsvcid": "4430"}
+
+	getLvolsStep := func(lvols ...spdktypes.LvolInfo) jsonRPCScriptStep {
+		if lvols == nil {
+			lvols = []spdktypes.LvolInfo{}
+		}
+		return jsonRPCScriptStep{method: "bdev_lvol_get_lvols", params: map[string]interface{}{"lvs_name": "lvs0"}, result: lvols}
+	}
+	esnapClone := spdktypes.LvolInfo{UUID: "clone-uuid", Name: "vol", IsEsnapClone: true}
+	rebuilt := spdktypes.LvolInfo{UUID: "clone-uuid", Name: "vol"}
+
+	copySteps := []jsonRPCScriptStep{
+		{method: "bdev_lvol_decouple_parent", params: map[string]interface{}{"name": "clone-uuid"}, result: true},
+		{
+			method: "bdev_lvol_get_fragmap",
+			params: map[string]interface{}{"name": "clone-uuid", "offset": float64(0), "size": float64(0)},
+			result: spdktypes.BdevLvolFragmap{ClusterSize: 1024, NumClusters: 8, NumAllocatedClusters: 8},
+		},
+	}
+	exposed := spdktypes.NvmfSubsystem{Nqn: nqn, Namespaces: []spdktypes.NvmfSubsystemNamespace{{Nsid: 1, BdevName: "lvs1/snap"}}}
+	cleanupSteps := []jsonRPCScriptStep{
+		{method: "bdev_nvme_detach_controller", params: map[string]interface{}{"name": "rebuild-vol"}, result: true},
+		{method: "nvmf_get_subsystems", result: []spdktypes.NvmfSubsystem{exposed}},
+		{
+			method: "nvmf_subsystem_get_listeners",
+			params: map[string]interface{}{"nqn": nqn},
+			result: []spdktypes.NvmfSubsystemListener{{Address: spdktypes.NvmfSubsystemListenAddress{Trtype: "tcp", Adrfam: "ipv4", Traddr: "10.0.0.2", Trsvcid: "4430"}}},
+		},
+		{method: "nvmf_subsystem_remove_listener", params: map[string]interface{}{"nqn": nqn, "listen_address": listenAddress}, result: true},
+		{method: "nvmf_subsystem_remove_ns", params: map[string]interface{}{"nqn": nqn, "nsid": float64(1)}, result: true},
+		{method: "nvmf_delete_subsystem", params: map[string]interface{}{"nqn": nqn}, result: true},
+	}
+	exposeSteps := []jsonRPCScriptStep{
+		{method: "bdev_get_bdevs", params: map[string]interface{}{"name": "rebuild-voln1"}, responseError: noDevice},
+		{method: "nvmf_get_transports", result: []spdktypes.NvmfTransport{{Trtype: "TCP"}}},
+		{method: "nvmf_get_subsystems", result: []spdktypes.NvmfSubsystem{}},
+		{method: "nvmf_create_subsystem", params: map[string]interface{}{"nqn": nqn, "allow_any_host": true}, result: true},
+		{method: "nvmf_subsystem_add_ns", params: map[string]interface{}{"nqn": nqn, "namespace": map[string]interface{}{"bdev_name": "lvs1/snap"}}, result: 1},
+		{method: "nvmf_subsystem_add_listener", params: map[string]interface{}{"nqn": nqn, "listen_address": listenAddress}, result: true},
+		{
+			method: "bdev_nvme_attach_controller",
+			params: map[string]interface{}{
+				"name":                     "rebuild-vol",
+				"trtype":                   "tcp",
+				"adrfam":                   "ipv4",
+				"traddr":                   "10.0.0.2",
+				"trsvcid":                  "4430",
+				"subnqn":                   nqn,
+				"ctrlr_loss_timeout_sec":   float64(types.DefaultCtrlrLossTimeoutSec),
+				"reconnect_delay_sec":      float64(types.DefaultReconnectDelaySec),
+				"fast_io_fail_timeout_sec": float64(types.DefaultFastIOFailTimeoutSec),
+				"multipath":                types.DefaultMultipath,
+			},
+			result: []string{"rebuild-voln1"},
+		},
+	}
+	esnapBdev := spdktypes.BdevInfo{BdevInfoBasic: spdktypes.BdevInfoBasic{Name: "rebuild-voln1", UUID: "snap-uuid"}}
+	cloneSteps := []jsonRPCScriptStep{
+		{method: "bdev_get_bdevs", params: map[string]interface{}{"name": "rebuild-voln1"}, result: []spdktypes.BdevInfo{esnapBdev}},
+		{method: "bdev_lvol_clone_bdev", params: map[string]interface{}{"bdev": "snap-uuid", "lvs_name": "lvs0", "clone_name": "vol"}, result: "clone-uuid"},
+		getLvolsStep(esnapClone),
+	}
+
+	join := func(groups ...[]jsonRPCScriptStep) []jsonRPCScriptStep {
+		steps := []jsonRPCScriptStep{}
+		for _, g := range groups {
+			steps = append(steps, g...)
+		}
+		return steps
+	}
+
+	tests := []struct {
+		name       string
+		steps      []jsonRPCScriptStep
+		wantErr    bool
+		wantPhases []ReplicaRebuildPhase
+	}{
+		{
+			name:  "full rebuild",
+			steps: join([]jsonRPCScriptStep{getLvolsStep()}, exposeSteps, cloneSteps, copySteps, cleanupSteps),
+			wantPhases: []ReplicaRebuildPhase{
+				ReplicaRebuildPhaseExposing, ReplicaRebuildPhaseCloning, ReplicaRebuildPhaseCopying,
+				ReplicaRebuildPhaseCleaning, ReplicaRebuildPhaseComplete,
+			},
+		},
+		{
+			name: "resumes the copy of an existing esnap clone",
+			steps: join([]jsonRPCScriptStep{
+				getLvolsStep(esnapClone),
+				{method: "bdev_get_bdevs", params: map[string]interface{}{"name": "rebuild-voln1"}, result: []spdktypes.BdevInfo{esnapBdev}},
+			}, copySteps, cleanupSteps),
+			wantPhases: []ReplicaRebuildPhase{
+				ReplicaRebuildPhaseExposing, ReplicaRebuildPhaseCopying, ReplicaRebuildPhaseCleaning, ReplicaRebuildPhaseComplete,
+			},
+		},
+		{
+			name: "waits for a decouple already in progress",
+			steps: join([]jsonRPCScriptStep{
+				getLvolsStep(esnapClone),
+				{method: "bdev_get_bdevs", params: map[string]interface{}{"name": "rebuild-voln1"}, result: []spdktypes.BdevInfo{esnapBdev}},
+				{
+					method:        "bdev_lvol_decouple_parent",
+					params:        map[string]interface{}{"name": "clone-uuid"},
+					responseError: &jsonrpc.ResponseError{Code: jsonrpc.RespErrorCodeDeviceOrResourceBusy, Message: "Device or resource busy"},
+				},
+				copySteps[1],
+				getLvolsStep(rebuilt),
+			}, cleanupSteps),
+			wantPhases: []ReplicaRebuildPhase{
+				ReplicaRebuildPhaseExposing, ReplicaRebuildPhaseCopying, ReplicaRebuildPhaseCleaning, ReplicaRebuildPhaseComplete,
+			},
+		},
+		{
+			name: "only cleans up after the copy",
+			steps: []jsonRPCScriptStep{
+				getLvolsStep(rebuilt),
+				{method: "bdev_nvme_detach_controller", params: map[string]interface{}{"name": "rebuild-vol"}, responseError: noDevice},
+				{method: "nvmf_get_subsystems", result: []spdktypes.NvmfSubsystem{}},
+			},
+			wantPhases: []ReplicaRebuildPhase{ReplicaRebuildPhaseCleaning, ReplicaRebuildPhaseComplete},
+		},
+		{
+			name: "rolls back the source when the clone fails",
+			steps: join([]jsonRPCScriptStep{getLvolsStep()}, exposeSteps, []jsonRPCScriptStep{
+				cloneSteps[0],
+				{
+					method:        "bdev_lvol_clone_bdev",
+					params:        map[string]interface{}{"bdev": "snap-uuid", "lvs_name": "lvs0", "clone_name": "vol"},
+					responseError: &jsonrpc.ResponseError{Code: jsonrpc.RespErrorCodeInternalError, Message: "No space left on device"},
+				},
+			}, cleanupSteps),
+			wantErr:    true,
+			wantPhases: []ReplicaRebuildPhase{ReplicaRebuildPhaseExposing, ReplicaRebuildPhaseCloning},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			runJSONRPCScriptTest(t, test.steps, func(cli *Client) {
+				phases := []ReplicaRebuildPhase{}
+				uuid, err := cli.RebuildReplica(context.Background(), ReplicaRebuildSpec{
+					Source:         cli,
+					SourceSnapshot: "lvs1/snap",
+					SourceIP:       "10.0.0.2",
+					SourcePort:     "4430",
+					LvsName:        "lvs0",
+					CloneName:      "vol",
+					CheckInterval:  time.Hour,
+					OnProgress: func(p ReplicaRebuildProgress) {
+						phases = append(phases, p.Phase)
+					},
+				})
+				if test.wantErr {
+					if err == nil {
+						t.Fatal("RebuildReplica unexpectedly succeeded")
+					}
+				} else {
+					if err != nil {
+						t.Fatalf("RebuildReplica failed: %v", err)
+					}
+					if uuid != "clone-uuid" {
+						t.Fatalf("got clone uuid %v, want clone-uuid", uuid)
+					}
+				}
+				if len(phases) != len(test.wantPhases) {
+					t.Fatalf("got phases %v, want %v", phases, test.wantPhases)
+				}
+				for i := range phases {
+					if phases[i] != test.wantPhases[i] {
+						t.Fatalf("got phases %v, want %v", phases, test.wantPhases)
+					}
+				}
+			})
+		})
+	}
+}
+
+func TestStartDecoupleParentJoinsDecoupleInFlight(t *testing.T) {
+	// Nothing serves the connection, so the decouple stays in flight until
+	// the connection is closed.
+	serverConn, clientConn := net.Pipe()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cli := &Client{conn: clientConn, jsonCli: jsonrpc.NewClient(ctx, clientConn)}
+
+	first := cli.startDecoupleParent("in-flight-uuid")
+	if second := cli.startDecoupleParent("in-flight-uuid"); second != first {
+		t.Fatal("expected the decouple in flight to be returned")
+	}
+
+	_ = serverConn.Close()
+	_ = clientConn.Close()
+	cancel()
+	<-first.done
+	if first.err == nil {
+		t.Fatal("expected the decouple to fail with the connection closed")
+	}
+	if next := cli.startDecoupleParent("in-flight-uuid"); next == first {
+		t.Fatal("expected a new decouple once the previous one finished")
+	} else {
+		<-next.done
+	}
+}
@@ -0,0 +1,137 @@
+package client
+
+import (
+	"fmt"
+
+	"github.com/cockroachdb/errors"
+
+	spdktypes "github.com/longhorn/go-spdk-helper/pkg/spdk/types"
+)
+
+const DefaultReplicaVerifyWindowClusters = 1024
+
+// ReplicaSnapshot is a snapshot of a replica, possibly on another target. The
+// range checksums of the snapshot must have been registered with
+// BdevLvolRegisterRangeChecksums.
+type ReplicaSnapshot struct {
+	// Name identifies the replica in the result.
+	Name   string
+	Client *Client
+	// Snapshot is the UUID or alias of the snapshot.
+	Snapshot string
+}
+
+type ReplicaVerifyOptions struct {
+	// WindowClusters is the number of clusters whose checksums are fetched per
+	// call. DefaultReplicaVerifyWindowClusters is used if it is 0.
+	WindowClusters uint64
+	// NumClusters is the number of clusters to compare. It is read from the
+	// fragmap of the snapshots if it is 0.
+	NumClusters uint64
+}
+
+// ReplicaDivergence lists the clusters of a replica that differ from the reference.
+type ReplicaDivergence struct {
+	Replica string                   `json:"replica"`
+	Ranges  []spdktypes.ClusterRange `json:"ranges"`
+	// Clusters are the indexes of the divergent clusters. Passing them to
+	// BdevLvolStartRangeShallowCopy with the reference snapshot as source and
+	// the replica as destination repairs the replica: the clusters allocated
+	// in the reference are copied and the others are unmapped.
+	Clusters []uint64 `json:"clusters"`
+}
+
+// ReplicaVerifyResult is the result of VerifyReplicas.
+type ReplicaVerifyResult struct {
+	Reference   string `json:"reference"`
+	NumClusters uint64 `json:"num_clusters"`
+	// Divergences lists the replicas that differ from the reference, in the
+	// order they were given.
+	Divergences []ReplicaDivergence `json:"divergences,omitempty"`
+}
+
+// Consistent reports whether all replicas match the reference.
+func (r *ReplicaVerifyResult) Consistent() bool {
+	return len(r.Divergences) == 0
+}
+
+// VerifyReplicas compares the range checksums of the replica snapshots window
+// by window against the first replica, which is the reference. A cluster
+// differs if the checksums differ, or if it has a checksum, meaning it is
+// allocated, on only one of the two replicas.
+func VerifyReplicas(replicas []ReplicaSnapshot, opts ReplicaVerifyOptions) (*ReplicaVerifyResult, error) {
+	if len(replicas) < 2 {
+		return nil, fmt.Errorf("at least 2 replicas are required for verification, got %d", len(replicas))
+	}
+	if opts.WindowClusters == 0 {
+		opts.WindowClusters = DefaultReplicaVerifyWindowClusters
+	}
+
+	numClusters := opts.NumClusters
+	if numClusters == 0 {
+		for _, r := range replicas {
+			fragmap, err := r.Client.BdevLvolGetFragmap(r.Snapshot, 0, 0)
+			if err != nil {
+				return nil, errors.Wrapf(err, "failed to get fragmap of snapshot %v of replica %v", r.Snapshot, r.Name)
+			}
+			if numClusters != 0 && fragmap.NumClusters != numClusters {
+				return nil, fmt.Errorf("snapshot %v of replica %v has %d clusters, expected %d",
+					r.Snapshot, r.Name, fragmap.NumClusters, numClusters)
+			}
+			numClusters = fragmap.NumClusters
+		}
+	}
+
+	reference := replicas[0]
+	divergent := make([][]uint64, len(replicas))
+	for start := uint64(0); start < numClusters; start += opts.WindowClusters {
+		count := opts.WindowClusters
+		if start+count > numClusters {
+			count = numClusters - start
+		}
+
+		refChecksums, err := reference.Client.BdevLvolGetRangeChecksums(reference.Snapshot, start, count)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to get range checksums of snapshot %v of replica %v", reference.Snapshot, reference.Name)
+		}
+		for i, r := range replicas[1:] {
+			checksums, err := r.Client.BdevLvolGetRangeChecksums(r.Snapshot, start, count)
+			if err != nil {
+				return nil, errors.Wrapf(err, "failed to get range checksums of snapshot %v of replica %v", r.Snapshot, r.Name)
+			}
+			divergent[i+1] = append(divergent[i+1], diffRangeChecksums(refChecksums, checksums, start, count)...)
+		}
+	}
+
+	result := &ReplicaVerifyResult{
+		Reference:   reference.Name,
+		NumClusters: numClusters,
+	}
+	for i, r := range replicas[1:] {
+		clusters := divergent[i+1]
+		if len(clusters) == 0 {
+			continue
+		}
+		result.Divergences = append(result.Divergences, ReplicaDivergence{
+			Replica:  r.Name,
+			Ranges:   spdktypes.ClusterRangesFromIndexes(clusters),
+			Clusters: clusters,
+		})
+	}
+	return result, nil
+}
+
+// diffRangeChecksums returns the indexes, in increasing order, of the
+// clusters in the window whose checksums differ or are present in only one of
+// the maps.
+func diffRangeChecksums(expected, actual map[uint64]uint64, start, count uint64) []uint64 {
+	clusters := []uint64{}
+	for index := start; index < start+count; index++ {
+		want, wantOk := expected[index]
+		got, gotOk := actual[index]
+		if wantOk != gotOk || want != got {
+			clusters = append(clusters, index)
+		}
+	}
+	return clusters
+}
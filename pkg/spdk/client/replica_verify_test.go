@@ -0,0 +1,92 @@
+package client
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	spdktypes "github.com/longhorn/go-spdk-helper/pkg/spdk/types"
+)
+
+func rangeChecksumsStep(name string, start, count uint64, checksums ...spdktypes.BdevLvolRangeChecksum) jsonRPCScriptStep {
+	if checksums == nil {
+		checksums = []spdktypes.BdevLvolRangeChecksum{}
+	}
+	return jsonRPCScriptStep{
+		method: "bdev_lvol_get_snapshot_range_checksums",
+		params: map[string]interface{}{"name": name, "cluster_start_index": float64(start), "cluster_count": float64(count)},
+		result: checksums,
+	}
+}
+
+func fragmapStep(name string, numClusters uint64) jsonRPCScriptStep {
+	return jsonRPCScriptStep{
+		method: "bdev_lvol_get_fragmap",
+		params: map[string]interface{}{"name": name, "offset": float64(0), "size": float64(0)},
+		result: spdktypes.BdevLvolFragmap{ClusterSize: 1024, NumClusters: numClusters},
+	}
+}
+
+func TestVerifyReplicas(t *testing.T) {
+	steps := []jsonRPCScriptStep{
+		fragmapStep("lvs-a/snap", 5),
+		fragmapStep("lvs-b/snap", 5),
+		fragmapStep("lvs-c/snap", 5),
+		rangeChecksumsStep("lvs-a/snap", 0, 2, spdktypes.BdevLvolRangeChecksum{ClusterIndex: 0, Checksum: 10}, spdktypes.BdevLvolRangeChecksum{ClusterIndex: 1, Checksum: 11}),
+		rangeChecksumsStep("lvs-b/snap", 0, 2, spdktypes.BdevLvolRangeChecksum{ClusterIndex: 0, Checksum: 10}, spdktypes.BdevLvolRangeChecksum{ClusterIndex: 1, Checksum: 99}),
+		rangeChecksumsStep("lvs-c/snap", 0, 2, spdktypes.BdevLvolRangeChecksum{ClusterIndex: 0, Checksum: 10}, spdktypes.BdevLvolRangeChecksum{ClusterIndex: 1, Checksum: 11}),
+		rangeChecksumsStep("lvs-a/snap", 2, 2, spdktypes.BdevLvolRangeChecksum{ClusterIndex: 2, Checksum: 12}),
+		rangeChecksumsStep("lvs-b/snap", 2, 2, spdktypes.BdevLvolRangeChecksum{ClusterIndex: 3, Checksum: 13}),
+		rangeChecksumsStep("lvs-c/snap", 2, 2, spdktypes.BdevLvolRangeChecksum{ClusterIndex: 2, Checksum: 12}),
+		rangeChecksumsStep("lvs-a/snap", 4, 1),
+		rangeChecksumsStep("lvs-b/snap", 4, 1),
+		rangeChecksumsStep("lvs-c/snap", 4, 1),
+	}
+
+	runJSONRPCScriptTest(t, steps, func(cli *Client) {
+		replicas := []ReplicaSnapshot{
+			{Name: "a", Client: cli, Snapshot: "lvs-a/snap"},
+			{Name: "b", Client: cli, Snapshot: "lvs-b/snap"},
+			{Name: "c", Client: cli, Snapshot: "lvs-c/snap"},
+		}
+		result, err := VerifyReplicas(replicas, ReplicaVerifyOptions{WindowClusters: 2})
+		if err != nil {
+			t.Fatalf("failed to verify replicas: %v", err)
+		}
+
+		want := &ReplicaVerifyResult{
+			Reference:   "a",
+			NumClusters: 5,
+			Divergences: []ReplicaDivergence{
+				{
+					Replica:  "b",
+					Ranges:   []spdktypes.ClusterRange{{Start: 1, Count: 3}},
+					Clusters: []uint64{1, 2, 3},
+				},
+			},
+		}
+		if !reflect.DeepEqual(result, want) {
+			t.Fatalf("got %+v, want %+v", result, want)
+		}
+		if result.Consistent() {
+			t.Fatal("expected the replicas to be inconsistent")
+		}
+	})
+}
+
+func TestVerifyReplicasClusterCountMismatch(t *testing.T) {
+	steps := []jsonRPCScriptStep{
+		fragmapStep("lvs-a/snap", 4),
+		fragmapStep("lvs-b/snap", 8),
+	}
+
+	runJSONRPCScriptTest(t, steps, func(cli *Client) {
+		_, err := VerifyReplicas([]ReplicaSnapshot{
+			{Name: "a", Client: cli, Snapshot: "lvs-a/snap"},
+			{Name: "b", Client: cli, Snapshot: "lvs-b/snap"},
+		}, ReplicaVerifyOptions{})
+		if err == nil || !strings.Contains(err.Error(), "has 8 clusters, expected 4") {
+			t.Fatalf("expected a cluster count mismatch error, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,216 @@
+package client
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cockroachdb/errors"
+
+	"github.com/longhorn/go-spdk-helper/pkg/jsonrpc"
+)
+
+const (
+	// SnapshotMetadataVersion is the xattr holding the version of the snapshot
+	// metadata schema. Snapshots created before the schema have no version.
+	SnapshotMetadataVersion = "snapshot_metadata_version"
+	// SnapshotLabelPrefix is the prefix of the xattrs holding the labels of a
+	// snapshot, "label.<KEY>".
+	SnapshotLabelPrefix = "label."
+	// SnapshotLabelKeys is the xattr listing the label keys of a snapshot,
+	// separated by commas, since SPDK can only get an xattr by name.
+	SnapshotLabelKeys = "snapshot_label_keys"
+
+	CurrentSnapshotMetadataVersion = 1
+)
+
+var snapshotLabelKeyRegexp = regexp.MustCompile(`^[A-Za-z0-9]([-A-Za-z0-9_.]*[A-Za-z0-9])?$`)
+
+// SnapshotMetadata is the typed form of the xattrs of a snapshot.
+type SnapshotMetadata struct {
+	// Version is the schema version, 0 for a snapshot created before the schema.
+	Version      int       `json:"version"`
+	CreationTime time.Time `json:"creation_time,omitempty"`
+	UserCreated  bool      `json:"user_created"`
+	// Checksum is the checksum registered with BdevLvolRegisterSnapshotChecksum.
+	// It is kept by SPDK rather than in an xattr, so Xattrs ignores it.
+	Checksum string            `json:"checksum,omitempty"`
+	Labels   map[string]string `json:"labels,omitempty"`
+}
+
+// Xattrs returns the xattrs of the metadata, sorted by name, in the current
+// schema version. The zero creation time is omitted.
+func (m *SnapshotMetadata) Xattrs() ([]Xattr, error) {
+	xattrs := []Xattr{
+		{Name: SnapshotMetadataVersion, Value: strconv.Itoa(CurrentSnapshotMetadataVersion)},
+		{Name: UserCreated, Value: strconv.FormatBool(m.UserCreated)},
+	}
+	if !m.CreationTime.IsZero() {
+		xattrs = append(xattrs, Xattr{Name: SnapshotTimestamp, Value: m.CreationTime.UTC().Format(time.RFC3339)})
+	}
+	keys := []string{}
+	for key, value := range m.Labels {
+		if !snapshotLabelKeyRegexp.MatchString(key) {
+			return nil, fmt.Errorf("invalid snapshot label key %q", key)
+		}
+		keys = append(keys, key)
+		xattrs = append(xattrs, Xattr{Name: SnapshotLabelPrefix + key, Value: value})
+	}
+	if len(keys) > 0 {
+		sort.Strings(keys)
+		xattrs = append(xattrs, Xattr{Name: SnapshotLabelKeys, Value: strings.Join(keys, ",")})
+	}
+
+	sort.Slice(xattrs, func(i, j int) bool { return xattrs[i].Name < xattrs[j].Name })
+	return xattrs, nil
+}
+
+// ParseSnapshotMetadata parses the xattrs of a snapshot, as returned by
+// BdevLvolGetXattrs. The xattrs that are not part of the schema are ignored.
+// A snapshot without version is parsed as created before the schema, for
+// which a missing user created flag means the snapshot was created by the
+// user.
+func ParseSnapshotMetadata(xattrs map[string]string) (*SnapshotMetadata, error) {
+	m := &SnapshotMetadata{}
+
+	if value, ok := xattrs[SnapshotMetadataVersion]; ok {
+		version, err := strconv.Atoi(value)
+		if err != nil || version < 1 {
+			return nil, fmt.Errorf("invalid snapshot metadata version %q", value)
+		}
+		if version > CurrentSnapshotMetadataVersion {
+			return nil, fmt.Errorf("unsupported snapshot metadata version %d, the latest supported version is %d", version, CurrentSnapshotMetadataVersion)
+		}
+		m.Version = version
+	}
+
+	m.UserCreated = m.Version == 0
+	if value, ok := xattrs[UserCreated]; ok {
+		userCreated, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid snapshot user created flag %q", value)
+		}
+		m.UserCreated = userCreated
+	}
+
+	if value := xattrs[SnapshotTimestamp]; value != "" {
+		creationTime, err := time.Parse(time.RFC3339Nano, value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid snapshot timestamp %q", value)
+		}
+		m.CreationTime = creationTime
+	}
+
+	if value := xattrs[SnapshotChecksum]; value != "" {
+		if _, err := strconv.ParseUint(value, 10, 64); err != nil {
+			return nil, fmt.Errorf("invalid snapshot checksum %q", value)
+		}
+		m.Checksum = value
+	}
+
+	for name, value := range xattrs {
+		key, ok := strings.CutPrefix(name, SnapshotLabelPrefix)
+		if !ok {
+			continue
+		}
+		if !snapshotLabelKeyRegexp.MatchString(key) {
+			return nil, fmt.Errorf("invalid snapshot label key %q", key)
+		}
+		if m.Labels == nil {
+			m.Labels = map[string]string{}
+		}
+		m.Labels[key] = value
+	}
+
+	return m, nil
+}
+
+// BdevLvolGetXattrs returns the xattrs of the snapshot metadata schema of a
+// logical volume. SPDK cannot list the xattrs of a lvol, so they are the ones
+// BdevLvolGetWithFilter fills in BdevDriverSpecificLvol.Xattrs, including the
+// registered checksum of a snapshot as SnapshotChecksum, plus the schema
+// version and the labels listed in SnapshotLabelKeys. Missing or empty xattrs
+// are left out.
+//
+//	"name": Required. UUID or alias of the logical volume. The alias of a lvol is <LVSTORE NAME>/<LVOL NAME>.
+func (c *Client) BdevLvolGetXattrs(name string) (map[string]string, error) {
+	lvols, err := c.BdevLvolGet(name, 0)
+	if err != nil {
+		return nil, err
+	}
+	if len(lvols) != 1 {
+		return nil, fmt.Errorf("cannot find lvol %v", name)
+	}
+	lvol := lvols[0]
+
+	xattrs := map[string]string{}
+	for xattrName, value := range lvol.DriverSpecific.Lvol.Xattrs {
+		if value != "" {
+			xattrs[xattrName] = value
+		}
+	}
+
+	get := func(xattrName string) error {
+		value, err := c.BdevLvolGetXattr(lvol.Name, xattrName)
+		if err != nil {
+			if jsonrpc.IsJSONRPCRespErrorNoSuchFileOrDirectory(err) {
+				return nil
+			}
+			return errors.Wrapf(err, "failed to get xattr %v of lvol %v", xattrName, name)
+		}
+		if value != "" {
+			xattrs[xattrName] = value
+		}
+		return nil
+	}
+
+	if err := get(SnapshotMetadataVersion); err != nil {
+		return nil, err
+	}
+	if err := get(SnapshotLabelKeys); err != nil {
+		return nil, err
+	}
+	if keys := xattrs[SnapshotLabelKeys]; keys != "" {
+		for _, key := range strings.Split(keys, ",") {
+			if !snapshotLabelKeyRegexp.MatchString(key) {
+				return nil, fmt.Errorf("lvol %v has an invalid snapshot label key %q", name, key)
+			}
+			if err := get(SnapshotLabelPrefix + key); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return xattrs, nil
+}
+
+// BdevLvolGetSnapshotMetadata returns the parsed metadata of a snapshot.
+//
+//	"name": Required. UUID or alias of the snapshot. The alias of a snapshot is <LVSTORE NAME>/<SNAPSHOT NAME>.
+func (c *Client) BdevLvolGetSnapshotMetadata(name string) (*SnapshotMetadata, error) {
+	xattrs, err := c.BdevLvolGetXattrs(name)
+	if err != nil {
+		return nil, err
+	}
+	m, err := ParseSnapshotMetadata(xattrs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse metadata of snapshot %v: %w", name, err)
+	}
+	return m, nil
+}
+
+// BdevLvolSnapshotWithMetadata captures a snapshot like BdevLvolSnapshot,
+// with the xattrs of the metadata.
+//
+//	"name": Required. UUID or alias of the logical volume to create a snapshot from. The alias of a lvol is <LVSTORE NAME>/<LVOL NAME>.
+//
+//	"snapshotName": Required. the logical volume name for the newly created snapshot.
+func (c *Client) BdevLvolSnapshotWithMetadata(name, snapshotName string, m *SnapshotMetadata) (uuid string, err error) {
+	xattrs, err := m.Xattrs()
+	if err != nil {
+		return "", err
+	}
+	return c.BdevLvolSnapshot(name, snapshotName, xattrs)
+}
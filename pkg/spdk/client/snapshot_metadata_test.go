@@ -0,0 +1,146 @@
+package client
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/longhorn/go-spdk-helper/pkg/jsonrpc"
+	spdktypes "github.com/longhorn/go-spdk-helper/pkg/spdk/types"
+)
+
+func TestSnapshotMetadataRoundTrip(t *testing.T) {
+	m := &SnapshotMetadata{
+		CreationTime: time.Date(2026, 7, 16, 4, 13, 30, 0, time.UTC),
+		UserCreated:  false,
+		Checksum:     "12345",
+		Labels:       map[string]string{"backup": "daily", "app.kubernetes.io": "db"},
+	}
+
+	xattrs, err := m.Xattrs()
+	if err != nil {
+		t.Fatalf("failed to marshal metadata: %v", err)
+	}
+	want := []Xattr{
+		{Name: "label.app.kubernetes.io", Value: "db"},
+		{Name: "label.backup", Value: "daily"},
+		{Name: SnapshotLabelKeys, Value: "app.kubernetes.io,backup"},
+		{Name: SnapshotMetadataVersion, Value: "1"},
+		{Name: SnapshotTimestamp, Value: "2026-07-16T04:13:30Z"},
+		{Name: UserCreated, Value: "false"},
+	}
+	if !reflect.DeepEqual(xattrs, want) {
+		t.Fatalf("got xattrs %v, want %v", xattrs, want)
+	}
+
+	// The checksum is registered in SPDK rather than written as an xattr.
+	values := map[string]string{"unrelated": "kept out", SnapshotChecksum: "12345"}
+	for _, x := range xattrs {
+		values[x.Name] = x.Value
+	}
+	parsed, err := ParseSnapshotMetadata(values)
+	if err != nil {
+		t.Fatalf("failed to parse metadata: %v", err)
+	}
+	m.Version = CurrentSnapshotMetadataVersion
+	if !reflect.DeepEqual(parsed, m) {
+		t.Fatalf("got metadata %+v, want %+v", parsed, m)
+	}
+}
+
+func TestParseSnapshotMetadata(t *testing.T) {
+	tests := []struct {
+		name    string
+		xattrs  map[string]string
+		want    *SnapshotMetadata
+		wantErr bool
+	}{
+		{
+			name:   "snapshot created before the schema is user created by default",
+			xattrs: map[string]string{SnapshotTimestamp: "2026-07-16T04:13:30Z"},
+			want:   &SnapshotMetadata{CreationTime: time.Date(2026, 7, 16, 4, 13, 30, 0, time.UTC), UserCreated: true},
+		},
+		{
+			name:   "versioned snapshot is not user created by default",
+			xattrs: map[string]string{SnapshotMetadataVersion: "1"},
+			want:   &SnapshotMetadata{Version: 1},
+		},
+		{
+			name:    "newer version",
+			xattrs:  map[string]string{SnapshotMetadataVersion: "2"},
+			wantErr: true,
+		},
+		{
+			name:    "invalid user created flag",
+			xattrs:  map[string]string{UserCreated: "yes please"},
+			wantErr: true,
+		},
+		{
+			name:    "invalid timestamp",
+			xattrs:  map[string]string{SnapshotTimestamp: "yesterday"},
+			wantErr: true,
+		},
+		{
+			name:    "invalid checksum",
+			xattrs:  map[string]string{SnapshotChecksum: "abc"},
+			wantErr: true,
+		},
+		{
+			name:    "invalid label key",
+			xattrs:  map[string]string{SnapshotLabelPrefix + "-bad": "x"},
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := ParseSnapshotMetadata(test.xattrs)
+			if test.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got %+v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("failed to parse metadata: %v", err)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Fatalf("got %+v, want %+v", got, test.want)
+			}
+		})
+	}
+
+	if _, err := (&SnapshotMetadata{Labels: map[string]string{"a b": "c"}}).Xattrs(); err == nil {
+		t.Fatal("expected an error for an invalid label key")
+	}
+}
+
+func TestBdevLvolGetSnapshotMetadata(t *testing.T) {
+	missingXattr := &jsonrpc.ResponseError{
+		Code:    jsonrpc.RespErrorCodeInternalError,
+		Message: jsonrpc.RespErrorMsgNoSuchFileOrDirectory,
+	}
+
+	// bdev_get_bdevs does not return xattrs, each one is fetched by name.
+	steps := []jsonRPCScriptStep{
+		{method: "bdev_get_bdevs", params: map[string]interface{}{"name": "lvs0/snap"}, result: []spdktypes.BdevInfo{testLvol("snap-uuid", true)}},
+		getXattrStep("snap-uuid", UserCreated, "false", nil),
+		getXattrStep("snap-uuid", SnapshotTimestamp, "", missingXattr),
+		{method: "bdev_lvol_get_snapshot_checksum", params: map[string]interface{}{"name": "snap-uuid"}, result: spdktypes.BdevLvolSnapshotChecksum{Checksum: 12345}},
+		getXattrStep("snap-uuid", SnapshotMetadataVersion, "1", nil),
+		getXattrStep("snap-uuid", SnapshotLabelKeys, "owner,tier", nil),
+		getXattrStep("snap-uuid", SnapshotLabelPrefix+"owner", "engine-0", nil),
+		getXattrStep("snap-uuid", SnapshotLabelPrefix+"tier", "", missingXattr),
+	}
+
+	runJSONRPCScriptTest(t, steps, func(cli *Client) {
+		m, err := cli.BdevLvolGetSnapshotMetadata("lvs0/snap")
+		if err != nil {
+			t.Fatalf("failed to get metadata: %v", err)
+		}
+		want := &SnapshotMetadata{Version: 1, Checksum: "12345", Labels: map[string]string{"owner": "engine-0"}}
+		if !reflect.DeepEqual(m, want) {
+			t.Fatalf("got %+v, want %+v", m, want)
+		}
+	})
+}
@@ -183,6 +183,32 @@ type BdevEcGetScrubProgressRequest struct {
 	Name string `json:"ec_name"`
 }
 
+// BdevEcStartScrubRequest is the request for bdev_ec_start_scrub.
+// DirtyOnly limits the scrub to the regions marked dirty in the WIB.
+type BdevEcStartScrubRequest struct {
+	Name      string `json:"ec_name"`
+	DirtyOnly bool   `json:"dirty_only,omitempty"`
+}
+
+// BdevEcStartScrubResponse is the response for bdev_ec_start_scrub.
+type BdevEcStartScrubResponse struct {
+	EcName     string `json:"ec_name"`
+	NumRegions uint32 `json:"num_regions"`
+	DirtyOnly  bool   `json:"dirty_only"`
+}
+
+// BdevEcStopScrubRequest is the request for bdev_ec_stop_scrub.
+type BdevEcStopScrubRequest struct {
+	Name string `json:"ec_name"`
+}
+
+// BdevEcSetScrubQosRequest is the request for bdev_ec_set_scrub_qos.
+type BdevEcSetScrubQosRequest struct {
+	Name             string `json:"ec_name"`
+	MaxStripesPerSec uint32 `json:"max_stripes_per_sec"`
+	Paused           bool   `json:"paused"`
+}
+
 // BdevEcRebuildProgress is the response for bdev_ec_get_rebuild_progress.
 // It is also embedded in BdevEcInfo when RebuildInProgress is true.
 // RebuildState is not returned by the SPDK JSON-RPC; it is derived by the Go layer:
@@ -223,7 +249,8 @@ type BdevEcUnmapStatus struct {
 	PersistPending bool   `json:"persist_pending"`
 }
 
-// BdevEcScrubProgress is the response for bdev_ec_get_scrub_progress.
+// BdevEcScrubProgress is the response for bdev_ec_get_scrub_progress, for
+// the startup scrub as well as for a scrub started by bdev_ec_start_scrub.
 type BdevEcScrubProgress struct {
 	EcName            string `json:"ec_name"`
 	CurrentRegion     uint32 `json:"current_region"`
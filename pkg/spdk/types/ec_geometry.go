@@ -16,6 +16,21 @@ const (
 	ecCommitRecordStrips = 2    // commit record (stamp): one strip per double-buffer copy
 )
 
+const (
+	ecMinStripSizeKB = 4
+	ecMaxStripSizeKB = 1024
+)
+
+// ValidateECStripSize rejects strip sizes SPDK bdev_ec does not accept: the
+// strip size must be a power of two between 4 and 1024 KiB.
+func ValidateECStripSize(stripSizeKB uint32) error {
+	if stripSizeKB < ecMinStripSizeKB || stripSizeKB > ecMaxStripSizeKB || stripSizeKB&(stripSizeKB-1) != 0 {
+		return fmt.Errorf("invalid strip size %v KiB, it must be a power of two between %v and %v KiB",
+			stripSizeKB, ecMinStripSizeKB, ecMaxStripSizeKB)
+	}
+	return nil
+}
+
 // EcFrontReservationStrips returns the strips SPDK bdev_ec keeps at the front of each base
 // disk for metadata (the double-buffered unmapped bitmap, the commit record, and the WIB)
 // before user data, recomputing SPDK's ec_compute_geometry. Assumes a valid strip size
@@ -0,0 +1,225 @@
+package types
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// EcPlacementBackend is a lvstore that can host an EC shard.
+type EcPlacementBackend struct {
+	// Node is the node of the lvstore. The shards on nodes other than the
+	// local node of the request are attached over NVMe/TCP.
+	Node    string `json:"node"`
+	LvsName string `json:"lvs_name"`
+	// Domain is the failure domain of the lvstore, e.g. its node or zone.
+	Domain    string `json:"domain"`
+	FreeBytes uint64 `json:"free_bytes"`
+	// ClusterSize is the cluster size of the lvstore, to which a shard lvol
+	// is rounded up.
+	ClusterSize uint64 `json:"cluster_size"`
+}
+
+// NewEcPlacementBackend returns the backend of a lvstore returned by
+// BdevLvolGetLvstore.
+func NewEcPlacementBackend(node, domain string, lvs *LvstoreInfo) EcPlacementBackend {
+	return EcPlacementBackend{
+		Node:        node,
+		LvsName:     lvs.Name,
+		Domain:      domain,
+		FreeBytes:   lvs.FreeClusters * lvs.ClusterSize,
+		ClusterSize: lvs.ClusterSize,
+	}
+}
+
+type EcPlacementRequest struct {
+	// Name is the name of the EC bdev. The shard of slot i is the lvol
+	// <Name>-shard-<i>, and a remote shard is attached as controller
+	// <Name>-shard-<i>, exposing bdev <Name>-shard-<i>n1.
+	Name         string `json:"name"`
+	VolumeSize   int64  `json:"volume_size"`
+	DataChunks   uint32 `json:"data_chunks"`
+	ParityChunks uint32 `json:"parity_chunks"`
+	StripSizeKB  uint32 `json:"strip_size_kb"`
+	// LocalNode is the node the EC bdev is created on.
+	LocalNode string `json:"local_node"`
+	// DomainFailures is the number of failure domains the placement must
+	// survive the loss of. ParityChunks is used if it is 0.
+	DomainFailures uint32 `json:"domain_failures"`
+}
+
+// EcShardPlacement is the backend of the shard of a slot.
+type EcShardPlacement struct {
+	Slot     uint32         `json:"slot"`
+	Role     BdevEcSlotRole `json:"role"`
+	Node     string         `json:"node"`
+	LvsName  string         `json:"lvs_name"`
+	Domain   string         `json:"domain"`
+	LvolName string         `json:"lvol_name"`
+	Remote   bool           `json:"remote"`
+	// BaseBdev is the name of the shard bdev on the local node: the lvol
+	// alias for a local shard, or the namespace bdev of the NVMe controller
+	// for a remote one.
+	BaseBdev string `json:"base_bdev"`
+}
+
+// EcPlacement is the result of PlanECPlacement.
+type EcPlacement struct {
+	ShardSize uint64             `json:"shard_size"`
+	Shards    []EcShardPlacement `json:"shards"`
+	// DomainShards counts the shards per failure domain.
+	DomainShards map[string]int `json:"domain_shards"`
+	// Create is the request of BdevEcCreate for the placement.
+	Create BdevEcCreateRequest `json:"create"`
+}
+
+// EcPlacementError explains why no placement satisfies a request.
+type EcPlacementError struct {
+	Reasons []string
+}
+
+func (e *EcPlacementError) Error() string {
+	return "cannot place EC shards: " + strings.Join(e.Reasons, "; ")
+}
+
+// PlanECPlacement places the k+m shards of an EC bdev on distinct backends,
+// spreading them over the failure domains so that losing any DomainFailures
+// domains loses at most m shards. A backend is eligible if it has room for a
+// shard of ComputeShardSize. The shards are added one by one to the domain
+// with the fewest shards so far, which keeps the largest domains as small as
+// the eligible backends allow. Within a domain, a backend on the local node
+// is preferred, then the one with the most free space.
+//
+// The error is an *EcPlacementError listing the reasons when the request
+// cannot be satisfied.
+func PlanECPlacement(req EcPlacementRequest, backends []EcPlacementBackend) (*EcPlacement, error) {
+	k, m := req.DataChunks, req.ParityChunks
+	if k == 0 || m == 0 {
+		return nil, &EcPlacementError{Reasons: []string{fmt.Sprintf("invalid geometry k=%v m=%v, both must be positive", k, m)}}
+	}
+	if err := ValidateECStripSize(req.StripSizeKB); err != nil {
+		return nil, &EcPlacementError{Reasons: []string{err.Error()}}
+	}
+	if req.VolumeSize <= 0 {
+		return nil, &EcPlacementError{Reasons: []string{fmt.Sprintf("invalid volume size %v", req.VolumeSize)}}
+	}
+	if err := ValidateECCreationSize(req.VolumeSize, int(k), int(req.StripSizeKB)); err != nil {
+		return nil, &EcPlacementError{Reasons: []string{err.Error()}}
+	}
+	domainFailures := req.DomainFailures
+	if domainFailures == 0 {
+		domainFailures = m
+	}
+
+	shardSize := uint64(ComputeShardSize(req.VolumeSize, int(k), int(req.StripSizeKB)))
+	total := int(k + m)
+
+	reasons := []string{}
+	domains := map[string][]EcPlacementBackend{}
+	for _, b := range backends {
+		needed := shardSize
+		if b.ClusterSize > 0 {
+			needed = (shardSize + b.ClusterSize - 1) / b.ClusterSize * b.ClusterSize
+		}
+		if b.FreeBytes < needed {
+			reasons = append(reasons, fmt.Sprintf("lvstore %v on node %v has %v bytes free, %v are needed for a shard",
+				b.LvsName, b.Node, b.FreeBytes, needed))
+			continue
+		}
+		domains[b.Domain] = append(domains[b.Domain], b)
+	}
+	for _, candidates := range domains {
+		sort.SliceStable(candidates, func(i, j int) bool {
+			a, b := candidates[i], candidates[j]
+			if (a.Node == req.LocalNode) != (b.Node == req.LocalNode) {
+				return a.Node == req.LocalNode
+			}
+			if a.FreeBytes != b.FreeBytes {
+				return a.FreeBytes > b.FreeBytes
+			}
+			if a.Node != b.Node {
+				return a.Node < b.Node
+			}
+			return a.LvsName < b.LvsName
+		})
+	}
+
+	eligible := 0
+	for _, candidates := range domains {
+		eligible += len(candidates)
+	}
+	if eligible < total {
+		reasons = append(reasons, fmt.Sprintf("%v shards are needed, but only %v backends are eligible", total, eligible))
+		return nil, &EcPlacementError{Reasons: reasons}
+	}
+
+	domainNames := []string{}
+	for domain := range domains {
+		domainNames = append(domainNames, domain)
+	}
+	sort.Strings(domainNames)
+
+	domainShards := map[string]int{}
+	chosen := []EcPlacementBackend{}
+	for len(chosen) < total {
+		best := ""
+		for _, domain := range domainNames {
+			if domainShards[domain] == len(domains[domain]) {
+				continue
+			}
+			if best == "" || domainShards[domain] < domainShards[best] {
+				best = domain
+			}
+		}
+		chosen = append(chosen, domains[best][domainShards[best]])
+		domainShards[best]++
+	}
+
+	counts := []int{}
+	for _, count := range domainShards {
+		counts = append(counts, count)
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(counts)))
+	lost := 0
+	for i := 0; i < len(counts) && i < int(domainFailures); i++ {
+		lost += counts[i]
+	}
+	if lost > int(m) {
+		reasons = append(reasons, fmt.Sprintf("the eligible backends span %v failure domains, and the best spread of the %v shards loses %v of them when the %v largest domains fail, more than the %v parity chunks",
+			len(domains), total, lost, domainFailures, m))
+		return nil, &EcPlacementError{Reasons: reasons}
+	}
+
+	placement := &EcPlacement{
+		ShardSize:    shardSize,
+		DomainShards: domainShards,
+		Create: BdevEcCreateRequest{
+			Name:         req.Name,
+			DataChunks:   k,
+			ParityChunks: m,
+			StripSizeKB:  req.StripSizeKB,
+		},
+	}
+	for i, b := range chosen {
+		shard := EcShardPlacement{
+			Slot:     uint32(i),
+			Role:     BdevEcSlotRoleData,
+			Node:     b.Node,
+			LvsName:  b.LvsName,
+			Domain:   b.Domain,
+			LvolName: fmt.Sprintf("%s-shard-%d", req.Name, i),
+			Remote:   b.Node != req.LocalNode,
+		}
+		if uint32(i) >= k {
+			shard.Role = BdevEcSlotRoleParity
+		}
+		if shard.Remote {
+			shard.BaseBdev = shard.LvolName + "n1"
+		} else {
+			shard.BaseBdev = b.LvsName + "/" + shard.LvolName
+		}
+		placement.Shards = append(placement.Shards, shard)
+		placement.Create.BaseBdevs = append(placement.Create.BaseBdevs, shard.BaseBdev)
+	}
+	return placement, nil
+}
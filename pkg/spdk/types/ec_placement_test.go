@@ -0,0 +1,140 @@
+package types
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testEcVolumeSize = 10 * 1024 * 1024 * 1024
+
+func testEcBackend(node, lvsName, domain string, freeGiB uint64) EcPlacementBackend {
+	return EcPlacementBackend{
+		Node:        node,
+		LvsName:     lvsName,
+		Domain:      domain,
+		FreeBytes:   freeGiB * 1024 * 1024 * 1024,
+		ClusterSize: EcLvstoreClusterSize,
+	}
+}
+
+func TestPlanECPlacementSpreadsDomains(t *testing.T) {
+	req := EcPlacementRequest{
+		Name:         "ec0",
+		VolumeSize:   testEcVolumeSize,
+		DataChunks:   2,
+		ParityChunks: 1,
+		StripSizeKB:  64,
+		LocalNode:    "node-1",
+	}
+	backends := []EcPlacementBackend{
+		testEcBackend("node-2", "disk-a", "zone-1", 100),
+		testEcBackend("node-1", "disk-b", "zone-1", 50),
+		testEcBackend("node-3", "disk-c", "zone-2", 100),
+		testEcBackend("node-4", "disk-d", "zone-3", 100),
+		testEcBackend("node-5", "disk-e", "zone-3", 1),
+	}
+
+	placement, err := PlanECPlacement(req, backends)
+	if err != nil {
+		t.Fatalf("failed to plan placement: %v", err)
+	}
+
+	if placement.ShardSize != uint64(ComputeShardSize(testEcVolumeSize, 2, 64)) {
+		t.Fatalf("got shard size %v", placement.ShardSize)
+	}
+	if want := map[string]int{"zone-1": 1, "zone-2": 1, "zone-3": 1}; !reflect.DeepEqual(placement.DomainShards, want) {
+		t.Fatalf("got domain shards %v, want %v", placement.DomainShards, want)
+	}
+	want := BdevEcCreateRequest{
+		Name:         "ec0",
+		DataChunks:   2,
+		ParityChunks: 1,
+		StripSizeKB:  64,
+		// The local lvstore of zone-1 is preferred.
+		BaseBdevs: []string{"disk-b/ec0-shard-0", "ec0-shard-1n1", "ec0-shard-2n1"},
+	}
+	if !reflect.DeepEqual(placement.Create, want) {
+		t.Fatalf("got create request %+v, want %+v", placement.Create, want)
+	}
+	if s := placement.Shards[2]; s.Role != BdevEcSlotRoleParity || !s.Remote || s.Node != "node-4" || s.LvolName != "ec0-shard-2" {
+		t.Fatalf("unexpected parity shard %+v", s)
+	}
+}
+
+func TestPlanECPlacementWithFewerDomainFailures(t *testing.T) {
+	req := EcPlacementRequest{
+		Name:           "ec0",
+		VolumeSize:     testEcVolumeSize,
+		DataChunks:     4,
+		ParityChunks:   2,
+		StripSizeKB:    64,
+		LocalNode:      "node-1",
+		DomainFailures: 1,
+	}
+	backends := []EcPlacementBackend{}
+	for _, zone := range []string{"zone-1", "zone-2", "zone-3"} {
+		for _, disk := range []string{"disk-a", "disk-b", "disk-c"} {
+			backends = append(backends, testEcBackend(zone+"-node", disk, zone, 100))
+		}
+	}
+
+	placement, err := PlanECPlacement(req, backends)
+	if err != nil {
+		t.Fatalf("failed to plan placement: %v", err)
+	}
+	if want := map[string]int{"zone-1": 2, "zone-2": 2, "zone-3": 2}; !reflect.DeepEqual(placement.DomainShards, want) {
+		t.Fatalf("got domain shards %v, want %v", placement.DomainShards, want)
+	}
+
+	// Surviving two zone failures needs six zones.
+	req.DomainFailures = 0
+	_, err = PlanECPlacement(req, backends)
+	var placementErr *EcPlacementError
+	if !errors.As(err, &placementErr) || !strings.Contains(err.Error(), "loses 4 of them when the 2 largest domains fail") {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
+
+func TestPlanECPlacementErrors(t *testing.T) {
+	valid := EcPlacementRequest{Name: "ec0", VolumeSize: testEcVolumeSize, DataChunks: 2, ParityChunks: 1, StripSizeKB: 64}
+	backends := []EcPlacementBackend{
+		testEcBackend("node-1", "disk-a", "zone-1", 100),
+		testEcBackend("node-2", "disk-b", "zone-2", 100),
+		testEcBackend("node-3", "disk-c", "zone-3", 1),
+	}
+
+	tests := map[string]struct {
+		modify func(*EcPlacementRequest)
+		reason string
+	}{
+		"no parity":          {modify: func(r *EcPlacementRequest) { r.ParityChunks = 0 }, reason: "invalid geometry"},
+		"strip size":         {modify: func(r *EcPlacementRequest) { r.StripSizeKB = 48 }, reason: "invalid strip size 48 KiB"},
+		"volume size":        {modify: func(r *EcPlacementRequest) { r.VolumeSize = 0 }, reason: "invalid volume size"},
+		"not enough backend": {modify: func(r *EcPlacementRequest) {}, reason: "lvstore disk-c on node node-3 has 1073741824 bytes free"},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			req := valid
+			test.modify(&req)
+			_, err := PlanECPlacement(req, backends)
+			if err == nil || !strings.Contains(err.Error(), test.reason) {
+				t.Fatalf("got error %v, want reason %q", err, test.reason)
+			}
+		})
+	}
+}
+
+func TestValidateECStripSize(t *testing.T) {
+	for _, stripSizeKB := range []uint32{4, 64, 1024} {
+		if err := ValidateECStripSize(stripSizeKB); err != nil {
+			t.Fatalf("unexpected error for %v KiB: %v", stripSizeKB, err)
+		}
+	}
+	for _, stripSizeKB := range []uint32{0, 2, 48, 2048} {
+		if err := ValidateECStripSize(stripSizeKB); err == nil {
+			t.Fatalf("expected an error for %v KiB", stripSizeKB)
+		}
+	}
+}
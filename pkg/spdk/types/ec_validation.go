@@ -0,0 +1,76 @@
+package types
+
+import "fmt"
+
+// EcBaseBdevError is returned by ValidateECBaseBdevs for a base bdev that
+// cannot back the EC bdev.
+type EcBaseBdevError struct {
+	Bdev   string
+	Reason string
+}
+
+func (e *EcBaseBdevError) Error() string {
+	return fmt.Sprintf("invalid EC base bdev %v: %v", e.Bdev, e.Reason)
+}
+
+// ValidateECBaseBdevs checks a bdev_ec_create request against its base bdevs
+// before sending it, so that it fails with the offending base bdev named
+// rather than with the SPDK error. bdevs holds the base bdevs returned by
+// bdev_get_bdevs by name, a missing one meaning it does not exist.
+//
+// The geometry must be valid and match the number of base bdevs. Every base
+// bdev must be listed once, be unclaimed, have the block size of the first
+// one, dividing the strip size, and hold EcFrontReservationBytes plus
+// minDataBytes, or plus one strip if minDataBytes is 0. The per-bdev errors
+// are *EcBaseBdevError.
+func ValidateECBaseBdevs(req *BdevEcCreateRequest, bdevs map[string]*BdevInfo, minDataBytes uint64) error {
+	if req.DataChunks == 0 || req.ParityChunks == 0 {
+		return fmt.Errorf("invalid geometry k=%v m=%v for EC bdev %v, both must be positive", req.DataChunks, req.ParityChunks, req.Name)
+	}
+	if err := ValidateECStripSize(req.StripSizeKB); err != nil {
+		return err
+	}
+	if n := req.DataChunks + req.ParityChunks; int(n) != len(req.BaseBdevs) {
+		return fmt.Errorf("EC bdev %v with k=%v m=%v needs %v base bdevs, got %v",
+			req.Name, req.DataChunks, req.ParityChunks, n, len(req.BaseBdevs))
+	}
+
+	stripBytes := uint64(req.StripSizeKB) * 1024
+	if minDataBytes == 0 {
+		minDataBytes = stripBytes
+	}
+	minBytes := EcFrontReservationBytes(req.StripSizeKB) + minDataBytes
+
+	seen := map[string]bool{}
+	var blockSize uint32
+	for _, name := range req.BaseBdevs {
+		if seen[name] {
+			return &EcBaseBdevError{Bdev: name, Reason: "listed more than once"}
+		}
+		seen[name] = true
+
+		bdev := bdevs[name]
+		if bdev == nil {
+			return &EcBaseBdevError{Bdev: name, Reason: "not found"}
+		}
+		if bdev.Claimed {
+			return &EcBaseBdevError{Bdev: name, Reason: fmt.Sprintf("already claimed (%v)", bdev.ClaimType)}
+		}
+		if blockSize == 0 {
+			blockSize = bdev.BlockSize
+		}
+		if bdev.BlockSize != blockSize {
+			return &EcBaseBdevError{Bdev: name, Reason: fmt.Sprintf("block size %v differs from block size %v of base bdev %v",
+				bdev.BlockSize, blockSize, req.BaseBdevs[0])}
+		}
+		if bdev.BlockSize == 0 || stripBytes%uint64(bdev.BlockSize) != 0 {
+			return &EcBaseBdevError{Bdev: name, Reason: fmt.Sprintf("block size %v does not divide the strip size %v KiB",
+				bdev.BlockSize, req.StripSizeKB)}
+		}
+		if size := bdev.NumBlocks * uint64(bdev.BlockSize); size < minBytes {
+			return &EcBaseBdevError{Bdev: name, Reason: fmt.Sprintf("size %v is less than the %v bytes of EC metadata plus the %v bytes of data needed",
+				size, EcFrontReservationBytes(req.StripSizeKB), minDataBytes)}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+package types
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestValidateECBaseBdevs(t *testing.T) {
+	// 64 KiB strips reserve 134479872 bytes at the front of each base bdev.
+	const minBytes = 134479872 + 64*1024
+	bdev := func(name string, blockSize uint32, size uint64) *BdevInfo {
+		info := &BdevInfo{}
+		info.Name = name
+		info.BlockSize = blockSize
+		info.NumBlocks = size / uint64(blockSize)
+		return info
+	}
+	bdevs := func(infos ...*BdevInfo) map[string]*BdevInfo {
+		m := map[string]*BdevInfo{}
+		for _, info := range infos {
+			m[info.Name] = info
+		}
+		return m
+	}
+	claimed := bdev("b2", 4096, minBytes)
+	claimed.Claimed = true
+	claimed.ClaimType = ClaimTypeExclusiveWrite
+
+	tests := map[string]struct {
+		dataChunks   uint32
+		stripSizeKB  uint32
+		baseBdevs    []string
+		bdevs        map[string]*BdevInfo
+		minDataBytes uint64
+		expectBdev   string
+		expectError  string
+	}{
+		"valid": {
+			bdevs: bdevs(bdev("b0", 4096, minBytes), bdev("b1", 4096, minBytes), bdev("b2", 4096, minBytes)),
+		},
+		"chunk count mismatch": {
+			dataChunks:  3,
+			expectError: "needs 4 base bdevs, got 3",
+		},
+		"strip size not a power of two": {
+			stripSizeKB: 48,
+			expectError: "invalid strip size 48 KiB",
+		},
+		"duplicate": {
+			baseBdevs:  []string{"b0", "b1", "b0"},
+			bdevs:      bdevs(bdev("b0", 4096, minBytes), bdev("b1", 4096, minBytes)),
+			expectBdev: "b0",
+		},
+		"missing": {
+			bdevs:       bdevs(bdev("b0", 4096, minBytes), bdev("b2", 4096, minBytes)),
+			expectBdev:  "b1",
+			expectError: "not found",
+		},
+		"claimed": {
+			bdevs:       bdevs(bdev("b0", 4096, minBytes), bdev("b1", 4096, minBytes), claimed),
+			expectBdev:  "b2",
+			expectError: "already claimed (exclusive_write)",
+		},
+		"block size mismatch": {
+			bdevs:       bdevs(bdev("b0", 4096, minBytes), bdev("b1", 512, minBytes), bdev("b2", 4096, minBytes)),
+			expectBdev:  "b1",
+			expectError: "block size 512 differs from block size 4096 of base bdev b0",
+		},
+		"too small": {
+			bdevs:       bdevs(bdev("b0", 4096, minBytes), bdev("b1", 4096, minBytes-4096), bdev("b2", 4096, minBytes)),
+			expectBdev:  "b1",
+			expectError: "less than the 134479872 bytes of EC metadata",
+		},
+		"too small for the data": {
+			bdevs:        bdevs(bdev("b0", 4096, minBytes), bdev("b1", 4096, minBytes), bdev("b2", 4096, minBytes)),
+			minDataBytes: 2 * 64 * 1024,
+			expectBdev:   "b0",
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			req := &BdevEcCreateRequest{
+				Name:         "ec0",
+				DataChunks:   2,
+				ParityChunks: 1,
+				StripSizeKB:  64,
+				BaseBdevs:    []string{"b0", "b1", "b2"},
+			}
+			if test.dataChunks != 0 {
+				req.DataChunks = test.dataChunks
+			}
+			if test.stripSizeKB != 0 {
+				req.StripSizeKB = test.stripSizeKB
+			}
+			if test.baseBdevs != nil {
+				req.BaseBdevs = test.baseBdevs
+			}
+
+			err := ValidateECBaseBdevs(req, test.bdevs, test.minDataBytes)
+			if test.expectBdev == "" && test.expectError == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("expected an error")
+			}
+			if test.expectError != "" && !strings.Contains(err.Error(), test.expectError) {
+				t.Fatalf("got error %q, want it to contain %q", err, test.expectError)
+			}
+			var bdevErr *EcBaseBdevError
+			if test.expectBdev != "" && (!errors.As(err, &bdevErr) || bdevErr.Bdev != test.expectBdev) {
+				t.Fatalf("got error %v, want one for base bdev %v", err, test.expectBdev)
+			}
+		})
+	}
+}
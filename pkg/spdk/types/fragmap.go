@@ -0,0 +1,85 @@
+package types
+
+import (
+	"encoding/base64"
+	"fmt"
+)
+
+// ClusterRange is a run of consecutive clusters.
+type ClusterRange struct {
+	Start uint64 `json:"start"`
+	Count uint64 `json:"count"`
+}
+
+// Bitmap decodes the base64 fragmap. Bit i, counting from the least
+// significant bit of the first byte, is set if cluster i is allocated.
+func (f *BdevLvolFragmap) Bitmap() ([]byte, error) {
+	bitmap, err := base64.StdEncoding.DecodeString(f.Fragmap)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode fragmap: %w", err)
+	}
+	if uint64(len(bitmap))*8 < f.NumClusters {
+		return nil, fmt.Errorf("fragmap of %d bytes is too short for %d clusters", len(bitmap), f.NumClusters)
+	}
+	return bitmap, nil
+}
+
+// AllocatedClusterRanges returns the runs of allocated clusters in the fragmap.
+func (f *BdevLvolFragmap) AllocatedClusterRanges() ([]ClusterRange, error) {
+	bitmap, err := f.Bitmap()
+	if err != nil {
+		return nil, err
+	}
+	return ClusterRangesFromBitmap(bitmap, f.NumClusters), nil
+}
+
+// ClusterRangesFromBitmap returns the runs of set bits among the first
+// numClusters bits of the bitmap.
+func ClusterRangesFromBitmap(bitmap []byte, numClusters uint64) []ClusterRange {
+	ranges := []ClusterRange{}
+	if max := uint64(len(bitmap)) * 8; numClusters > max {
+		numClusters = max
+	}
+
+	for i := uint64(0); i < numClusters; i++ {
+		if bitmap[i/8]&(1<<(i%8)) == 0 {
+			continue
+		}
+		if n := len(ranges); n > 0 && ranges[n-1].Start+ranges[n-1].Count == i {
+			ranges[n-1].Count++
+			continue
+		}
+		ranges = append(ranges, ClusterRange{Start: i, Count: 1})
+	}
+
+	return ranges
+}
+
+// MergeBitmaps returns the union of the bitmaps. The result is as long as the
+// longest bitmap.
+func MergeBitmaps(bitmaps ...[]byte) []byte {
+	merged := []byte{}
+	for _, bitmap := range bitmaps {
+		if len(bitmap) > len(merged) {
+			merged = append(merged, make([]byte, len(bitmap)-len(merged))...)
+		}
+		for i, b := range bitmap {
+			merged[i] |= b
+		}
+	}
+	return merged
+}
+
+// ClusterRangesFromIndexes returns the runs of consecutive clusters among the
+// cluster indexes, which must be sorted in increasing order.
+func ClusterRangesFromIndexes(indexes []uint64) []ClusterRange {
+	ranges := []ClusterRange{}
+	for _, i := range indexes {
+		if n := len(ranges); n > 0 && ranges[n-1].Start+ranges[n-1].Count == i {
+			ranges[n-1].Count++
+			continue
+		}
+		ranges = append(ranges, ClusterRange{Start: i, Count: 1})
+	}
+	return ranges
+}
@@ -0,0 +1,87 @@
+package types
+
+import (
+	"encoding/base64"
+	"reflect"
+	"testing"
+)
+
+func TestBdevLvolFragmapAllocatedClusterRanges(t *testing.T) {
+	tests := []struct {
+		name        string
+		bitmap      []byte
+		numClusters uint64
+		want        []ClusterRange
+		wantErr     bool
+	}{
+		{
+			name:        "empty",
+			bitmap:      []byte{0x00, 0x00},
+			numClusters: 16,
+			want:        []ClusterRange{},
+		},
+		{
+			name:        "runs across bytes",
+			bitmap:      []byte{0b1100_0011, 0b0000_0001},
+			numClusters: 16,
+			want:        []ClusterRange{{Start: 0, Count: 2}, {Start: 6, Count: 3}},
+		},
+		{
+			name:        "bits beyond the cluster count are ignored",
+			bitmap:      []byte{0b1111_0000},
+			numClusters: 6,
+			want:        []ClusterRange{{Start: 4, Count: 2}},
+		},
+		{
+			name:        "too short",
+			bitmap:      []byte{0xff},
+			numClusters: 9,
+			wantErr:     true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f := &BdevLvolFragmap{
+				ClusterSize: 1 << 20,
+				NumClusters: test.numClusters,
+				Fragmap:     base64.StdEncoding.EncodeToString(test.bitmap),
+			}
+			got, err := f.AllocatedClusterRanges()
+			if test.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Fatalf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+
+	if _, err := (&BdevLvolFragmap{Fragmap: "not base64!"}).AllocatedClusterRanges(); err == nil {
+		t.Fatal("expected an error for an invalid fragmap")
+	}
+}
+
+func TestMergeBitmaps(t *testing.T) {
+	got := MergeBitmaps([]byte{0b0001}, []byte{0b0100, 0b1000}, nil)
+	if want := []byte{0b0101, 0b1000}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %08b, want %08b", got, want)
+	}
+}
+
+func TestClusterRangesFromIndexes(t *testing.T) {
+	got := ClusterRangesFromIndexes([]uint64{0, 1, 2, 5, 7, 8})
+	want := []ClusterRange{{Start: 0, Count: 3}, {Start: 5, Count: 1}, {Start: 7, Count: 2}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	if got := ClusterRangesFromIndexes(nil); len(got) != 0 {
+		t.Fatalf("got %v, want no range", got)
+	}
+}
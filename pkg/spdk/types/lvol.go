@@ -15,6 +15,13 @@ type BdevDriverSpecificLvol struct {
 	Clone                bool              `json:"clone"`
 	Clones               []string          `json:"clones,omitempty"`
 	Xattrs               map[string]string `json:"xattrs,omitempty"`
+
+	// EsnapClone is set if the lvol is a clone of an external snapshot, whose
+	// bdev name or UUID is ExternalSnapshotName. Degraded is set while that
+	// bdev is missing, e.g. a remote NVMe bdev that is not connected.
+	EsnapClone           bool   `json:"esnap_clone,omitempty"`
+	ExternalSnapshotName string `json:"external_snapshot_name,omitempty"`
+	Degraded             bool   `json:"degraded,omitempty"`
 }
 
 type LvstoreInfo struct {
@@ -40,6 +47,10 @@ type LvolInfo struct {
 		Name string `json:"name"`
 		UUID string `json:"uuid"`
 	} `json:"lvs"`
+
+	// ExternalSnapshotName is the bdev name or UUID of the external snapshot
+	// of an esnap clone.
+	ExternalSnapshotName string `json:"external_snapshot_name,omitempty"`
 }
 
 type ShallowCopy struct {
@@ -147,6 +158,14 @@ type BdevLvolDecoupleParentRequest struct {
 	Name string `json:"name"`
 }
 
+type BdevLvolSetReadOnlyRequest struct {
+	Name string `json:"name"`
+}
+
+type BdevLvolInflateRequest struct {
+	Name string `json:"name"`
+}
+
 type BdevLvolDetachParentRequest struct {
 	Name string `json:"name"`
 }
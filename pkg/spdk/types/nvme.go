@@ -78,6 +78,25 @@ const (
 	BdevNvmeMultipathPolicyActiveActive  = "active_active"
 )
 
+type BdevNvmeMultipathSelector string
+
+const (
+	BdevNvmeMultipathSelectorRoundRobin = "round_robin"
+	BdevNvmeMultipathSelectorQueueDepth = "queue_depth"
+)
+
+// NvmeControllerState is the state of an NVMe controller reported by bdev_nvme_get_controllers.
+type NvmeControllerState string
+
+const (
+	NvmeControllerStateEnabled            = NvmeControllerState("enabled")
+	NvmeControllerStateResetting          = NvmeControllerState("resetting")
+	NvmeControllerStateReconnectIsDelayed = NvmeControllerState("reconnect_is_delayed")
+	NvmeControllerStateFailed             = NvmeControllerState("failed")
+	NvmeControllerStateDisabled           = NvmeControllerState("disabled")
+	NvmeControllerStateDeleting           = NvmeControllerState("deleting")
+)
+
 type BdevNvmeControllerInfo struct {
 	Name   string               `json:"name"`
 	Ctrlrs []NvmeControllerInfo `json:"ctrlrs"`
@@ -176,3 +195,88 @@ type BdevNvmeControllerHealthInfo struct {
 type BdevNvmeGetControllerHealthInfoRequest struct {
 	Name string `json:"name"`
 }
+
+type BdevNvmeSetMultipathPolicyRequest struct {
+	Name     string                    `json:"name"`
+	Policy   BdevNvmeMultipathPolicy   `json:"policy"`
+	Selector BdevNvmeMultipathSelector `json:"selector,omitempty"`
+	RrMinIo  uint32                    `json:"rr_min_io,omitempty"`
+}
+
+type BdevNvmeGetIOPathsRequest struct {
+	Name string `json:"name,omitempty"`
+}
+
+// BdevNvmeIOPaths represents the response of bdev_nvme_get_io_paths.
+type BdevNvmeIOPaths struct {
+	PollGroups []BdevNvmePollGroupIOPaths `json:"poll_groups"`
+}
+
+// BdevNvmePollGroupIOPaths holds the I/O paths of the NVMe bdevs on one poll group thread.
+type BdevNvmePollGroupIOPaths struct {
+	Thread  string           `json:"thread"`
+	IOPaths []BdevNvmeIOPath `json:"io_paths"`
+}
+
+// BdevNvmeIOPath is a path from an NVMe bdev to one of its controllers.
+// Current marks the path the poll group is using for I/O.
+type BdevNvmeIOPath struct {
+	BdevName   string          `json:"bdev_name"`
+	Cntlid     uint16          `json:"cntlid"`
+	Current    bool            `json:"current"`
+	Connected  bool            `json:"connected"`
+	Accessible bool            `json:"accessible"`
+	Transport  NvmeTransportID `json:"transport"`
+}
+
+type BdevNvmeSetPreferredPathRequest struct {
+	Name   string `json:"name"`
+	Cntlid uint16 `json:"cntlid"`
+}
+
+// BdevNvmeTransportStatistics represents the response of bdev_nvme_get_transport_statistics.
+type BdevNvmeTransportStatistics struct {
+	PollGroups []BdevNvmePollGroupTransportStatistics `json:"poll_groups"`
+}
+
+// BdevNvmePollGroupTransportStatistics holds the transport statistics of one poll group thread.
+type BdevNvmePollGroupTransportStatistics struct {
+	Thread     string                   `json:"thread"`
+	Transports []NvmeTransportStatistic `json:"transports"`
+}
+
+// NvmeTransportStatistic holds the counters of one transport. Which counters
+// are reported depends on the transport: TCP reports socket and NVMe
+// completions, PCIe reports completions and doorbell updates, and RDMA reports
+// the counters per device.
+type NvmeTransportStatistic struct {
+	Trname string `json:"trname"`
+
+	Polls             uint64 `json:"polls,omitempty"`
+	IdlePolls         uint64 `json:"idle_polls,omitempty"`
+	Completions       uint64 `json:"completions,omitempty"`
+	SubmittedRequests uint64 `json:"submitted_requests,omitempty"`
+	QueuedRequests    uint64 `json:"queued_requests,omitempty"`
+
+	SocketCompletions uint64 `json:"socket_completions,omitempty"`
+	NvmeCompletions   uint64 `json:"nvme_completions,omitempty"`
+
+	CqMmioDoorbellUpdates   uint64 `json:"cq_mmio_doorbell_updates,omitempty"`
+	CqShadowDoorbellUpdates uint64 `json:"cq_shadow_doorbell_updates,omitempty"`
+	SqMmioDoorbellUpdates   uint64 `json:"sq_mmio_doorbell_updates,omitempty"`
+	SqShadowDoorbellUpdates uint64 `json:"sq_shadow_doorbell_updates,omitempty"`
+
+	Devices []NvmeRdmaDeviceStatistic `json:"devices,omitempty"`
+}
+
+type NvmeRdmaDeviceStatistic struct {
+	DevName             string `json:"dev_name"`
+	Polls               uint64 `json:"polls"`
+	IdlePolls           uint64 `json:"idle_polls"`
+	Completions         uint64 `json:"completions"`
+	QueuedRequests      uint64 `json:"queued_requests"`
+	TotalSendWrs        uint64 `json:"total_send_wrs"`
+	SendDoorbellUpdates uint64 `json:"send_doorbell_updates"`
+	TotalRecvWrs        uint64 `json:"total_recv_wrs"`
+	RecvDoorbellUpdates uint64 `json:"recv_doorbell_updates"`
+}
@@ -150,6 +150,20 @@ type NvmfSubsystemAddHostRequest struct {
 	TgtName string `json:"tgt_name,omitempty"`
 }
 
+type NvmfSubsystemRemoveHostRequest struct {
+	Nqn  string `json:"nqn"`
+	Host string `json:"host"`
+
+	TgtName string `json:"tgt_name,omitempty"`
+}
+
+type NvmfSubsystemAllowAnyHostRequest struct {
+	Nqn          string `json:"nqn"`
+	AllowAnyHost bool   `json:"allow_any_host"`
+
+	TgtName string `json:"tgt_name,omitempty"`
+}
+
 type NvmfSubsystemAddNsRequest struct {
 	Nqn       string                 `json:"nqn"`
 	Namespace NvmfSubsystemNamespace `json:"namespace"`